@@ -0,0 +1,89 @@
+//go:build linux
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/cyrilix/robocar-arduino/pkg/simulator"
+	"go.uber.org/zap"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+func main() {
+	var scenario string
+	var frequency, noise int
+	var once bool
+
+	flag.StringVar(&scenario, "scenario", "demo", fmt.Sprintf("scenario to play: %v", strings.Join(simulator.ScenarioNames(), ", ")))
+	flag.IntVar(&frequency, "frequency", simulator.DefaultFrequency, "Number of lines to emit per second")
+	flag.IntVar(&noise, "noise", 0, "Amplitude of random noise added to pwm values")
+	flag.BoolVar(&once, "once", false, "Play scenario only one time, scenario is played in loop by default")
+	logLevel := zap.LevelFlag("log", zap.InfoLevel, "log level")
+	flag.Parse()
+
+	config := zap.NewDevelopmentConfig()
+	config.Level = zap.NewAtomicLevelAt(*logLevel)
+	lgr, err := config.Build()
+	if err != nil {
+		log.Fatalf("unable to init logger: %v", err)
+	}
+	defer func() {
+		if err := lgr.Sync(); err != nil {
+			log.Printf("unable to Sync logger: %v\n", err)
+		}
+	}()
+	zap.ReplaceGlobals(lgr)
+
+	sc, ok := simulator.Scenarios[scenario]
+	if !ok {
+		zap.S().Fatalf("unknown scenario '%v', available scenarios: %v", scenario, simulator.ScenarioNames())
+	}
+	options := []simulator.Option{simulator.WithRealTime(), simulator.WithFrequency(frequency)}
+	if !once {
+		options = append(options, simulator.WithLoop())
+	}
+	if noise > 0 {
+		options = append(options, simulator.WithNoise(noise, 0))
+	}
+	sim := simulator.New(sc, options...)
+
+	pty, err := simulator.OpenPty()
+	if err != nil {
+		zap.S().Fatalf("unable to open pseudo-terminal: %v", err)
+	}
+	defer func() {
+		if err := pty.Close(); err != nil {
+			zap.S().Errorf("unable to close pseudo-terminal: %v", err)
+		}
+	}()
+	zap.S().Infof("simulated arduino available on %v, run rc-arduino with '--device %v'", pty.Name, pty.Name)
+
+	// Log commands written by rc-arduino
+	go func() {
+		buf := make([]byte, 256)
+		for {
+			n, err := pty.Master.Read(buf)
+			if err != nil {
+				return
+			}
+			zap.S().Debugf("command received: %q", buf[:n])
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		_ = sim.Close()
+	}()
+
+	if _, err := io.Copy(pty.Master, sim); err != nil && err != io.ErrClosedPipe {
+		zap.S().Errorf("unable to write simulated lines: %v", err)
+	}
+}
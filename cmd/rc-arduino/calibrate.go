@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"github.com/cyrilix/robocar-arduino/pkg/arduino"
+	"github.com/cyrilix/robocar-arduino/pkg/calibration"
+	"github.com/cyrilix/robocar-arduino/pkg/config"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"time"
+)
+
+// calibrate guides user through calibration steps while reading serial stream and merges result into config file
+func calibrate(serial config.Serial, channelMapping arduino.ChannelMapping, configFile string, stepDuration time.Duration) error {
+	if configFile == "" {
+		return fmt.Errorf("no config file where to write calibration, use --config")
+	}
+	protocol, err := arduino.ParseProtocol(serial.Protocol)
+	if err != nil {
+		return fmt.Errorf("invalid serial protocol: %w", err)
+	}
+
+	s, err := arduino.NewSerialOpener(serial.Device, serial.Baud)()
+	if err != nil {
+		return err
+	}
+	if c, ok := s.(io.Closer); ok {
+		defer func() {
+			if err := c.Close(); err != nil {
+				zap.S().Errorf("unable to close serial port: %v", err)
+			}
+		}()
+	}
+	fr, err := arduino.NewFrameReader(s, protocol)
+	if err != nil {
+		return fmt.Errorf("unable to read serial stream: %w", err)
+	}
+
+	session := calibration.NewSession(channelMapping)
+	go func() {
+		for {
+			f, err := fr.ReadFrame()
+			if err != nil {
+				zap.S().Errorf("unable to read frame: %v", err)
+				return
+			}
+			session.Add(f)
+		}
+	}()
+
+	if err := session.Run(calibration.DefaultSteps, os.Stdin, os.Stdout, stepDuration); err != nil {
+		return err
+	}
+	c, err := session.Config()
+	if err != nil {
+		return err
+	}
+	if err := c.Merge(configFile); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(os.Stdout, "calibration written to pwm and drive_modes sections of %v\n", configFile)
+	return nil
+}
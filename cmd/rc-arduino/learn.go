@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cyrilix/robocar-arduino/pkg/arduino"
+	"github.com/cyrilix/robocar-arduino/pkg/calibration"
+	"github.com/cyrilix/robocar-arduino/pkg/config"
+	"github.com/cyrilix/robocar-arduino/pkg/record"
+	"io"
+	"os"
+	"time"
+)
+
+// learnThresholds fits throttle feedback thresholds on a session recorded while throttle was held at several constant
+// values, writes them to output file and prints fit report
+func learnThresholds(cfg *config.Config, sessionFile, output string, opts calibration.FitOptions) error {
+	if sessionFile == "" {
+		return fmt.Errorf("no session file, use --record-file")
+	}
+	if output == "" {
+		return fmt.Errorf("no output file, use --thresholds-output")
+	}
+	protocol, err := arduino.ParseProtocol(cfg.Serial.Protocol)
+	if err != nil {
+		return fmt.Errorf("invalid serial protocol: %w", err)
+	}
+	channelMapping := cfg.Mapping()
+	throttleChannel, ok := channelMapping.Channel(arduino.RoleThrottle)
+	if !ok {
+		return fmt.Errorf("no channel mapped to %v", arduino.RoleThrottle)
+	}
+	feedbackChannel, ok := channelMapping.Channel(arduino.RoleThrottleFeedback)
+	if !ok {
+		return fmt.Errorf("no channel mapped to %v", arduino.RoleThrottleFeedback)
+	}
+
+	f, err := os.Open(sessionFile)
+	if err != nil {
+		return fmt.Errorf("unable to open session file: %w", err)
+	}
+	defer f.Close()
+	fr, err := arduino.NewFrameReader(record.NewReplayer(f, 0), protocol)
+	if err != nil {
+		return fmt.Errorf("unable to read session: %w", err)
+	}
+
+	var samples []calibration.FeedbackSample
+	for {
+		fm, err := fr.ReadFrame()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read frame: %w", err)
+		}
+		throttle, _ := fm.Channel(throttleChannel)
+		feedback, _ := fm.Channel(feedbackChannel)
+		samples = append(samples, calibration.FeedbackSample{
+			Time:     time.UnixMilli(int64(fm.Timestamp)),
+			Throttle: float64(cfg.PWM.Throttle.Percent(throttle)),
+			Feedback: feedback,
+		})
+	}
+
+	tc, report, err := calibration.FitThresholds(samples, opts)
+	if err != nil {
+		return err
+	}
+	report.Print(os.Stdout)
+	if err := calibration.WriteThresholds(tc, output); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(os.Stdout, "thresholds written to %v, use them with --throttle-feedback-config\n", output)
+	return nil
+}
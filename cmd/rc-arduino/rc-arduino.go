@@ -2,113 +2,223 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"github.com/cyrilix/robocar-arduino/pkg/arduino"
+	"github.com/cyrilix/robocar-arduino/pkg/calibration"
+	"github.com/cyrilix/robocar-arduino/pkg/config"
+	"github.com/cyrilix/robocar-arduino/pkg/curve"
+	"github.com/cyrilix/robocar-arduino/pkg/record"
+	"github.com/cyrilix/robocar-arduino/pkg/speed"
+	"github.com/cyrilix/robocar-arduino/pkg/tools"
 	"github.com/cyrilix/robocar-base/cli"
 	"go.uber.org/zap"
 	"log"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"os"
 )
 
 const (
-	DefaultClientId = "robocar-arduino"
-
-	SteeringLeftPWM  = 1004
-	SteeringRightPWM = 1986
-
-	ThrottleZeroPWM = 1260
-	ThrottleMinPWM  = 972.0
-	ThrottleMaxPWM  = 1954.0
-)
-
-var (
-	SteeringCenterPWM = (SteeringRightPWM-SteeringLeftPWM)/2 + SteeringLeftPWM
+	CommandRun             = "run"
+	CommandReplay          = "replay"
+	CommandCalibrate       = "calibrate"
+	CommandLearnThresholds = "learn-thresholds"
 )
 
 func main() {
-	var mqttBroker, username, password, clientId string
-	var throttleTopic, steeringTopic, driveModeTopic, switchRecordTopic, throttleFeedbackTopic, maxThrottleCtrlTopic string
-	var feedbackConfig string
-	var device string
-	var baud int
-	var pubFrequency float64
+	// First arg is an optional command: run (default), replay, calibrate or learn-thresholds
+	command := CommandRun
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command = args[0]
+		args = args[1:]
+	}
 
-	mqttQos := cli.InitIntFlag("MQTT_QOS", 0)
-	_, mqttRetain := os.LookupEnv("MQTT_RETAIN")
+	// Config files are loaded before to define args, their values are overridden by env and then by args
+	configFile := argValue(args, "config", "CONFIG_FILE")
+	cfg, err := loadConfigFile(configFile)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
 
-	cli.InitMqttFlags(DefaultClientId, &mqttBroker, &username, &password, &clientId, &mqttQos, &mqttRetain)
+	var printConfig bool
+	var clockInterval, signalInterval time.Duration
+	var commandFrequency float64
+	var feedback throttleFeedbackArgs
+	var channelMappingConfig string
+	var httpListen string
+	var healthTimeout time.Duration
+	var replaySpeed float64
+	var calibrationStepDuration time.Duration
+	var thresholdsOutput string
+	var thresholdsSettleDelay time.Duration
+	var thresholdsTolerance float64
 
-	var steeringLeftPWM, steeringRightPWM, steeringCenterPWM int
-	if err := cli.SetIntDefaultValueFromEnv(&steeringLeftPWM, "STEERING_LEFT_PWM", SteeringLeftPWM); err != nil {
-		zap.S().Warnf("unable to init steeringLeftPWM arg: %v", err)
-	}
-	if err := cli.SetIntDefaultValueFromEnv(&steeringRightPWM, "STEERING_RIGHT_PWM", SteeringRightPWM); err != nil {
-		zap.S().Warnf("unable to init steeringRightPWM arg: %v", err)
-	}
-	if err := cli.SetIntDefaultValueFromEnv(&steeringCenterPWM, "STEERING_CENTER_PWM", SteeringCenterPWM); err != nil {
-		zap.S().Warnf("unable to init steeringCenterPWM arg: %v", err)
-	}
+	flag.StringVar(&configFile, "config", os.Getenv("CONFIG_FILE"), "json config file, env and args override its values (see config package for settings that stay outside of it), calibrate command writes calibrated pwm ranges and drive modes into it, CONFIG_FILE env if args not set")
+	flag.BoolVar(&printConfig, "print-config", false, "print effective config as json and exit")
 
-	var throttleMinPWM, throttleMaxPWM, throttleZeroPWM int
-	if err := cli.SetIntDefaultValueFromEnv(&throttleMinPWM, "THROTTLE_MIN_PWM", arduino.DefaultPwmThrottle.Min); err != nil {
-		zap.S().Warnf("unable to init throttleMinPWM arg: %v", err)
-	}
-	if err := cli.SetIntDefaultValueFromEnv(&throttleMaxPWM, "THROTTLE_MAX_PWM", arduino.DefaultPwmThrottle.Max); err != nil {
-		zap.S().Warnf("unable to init throttleMaxPWM arg: %v", err)
-	}
-	if err := cli.SetIntDefaultValueFromEnv(&throttleZeroPWM, "THROTTLE_CENTER_PWM", arduino.DefaultPwmThrottle.Middle); err != nil {
-		zap.S().Warnf("unable to init throttleZeroPWM arg: %v", err)
-	}
-	if err := cli.SetIntDefaultValueFromEnv(&throttleMinPWM, "THROTTLE_MIN_PWM", ThrottleMinPWM); err != nil {
-		zap.S().Warnf("unable to init steeringLeftPWM arg: %v", err)
-	}
-	if err := cli.SetIntDefaultValueFromEnv(&throttleMaxPWM, "THROTTLE_MAX_PWM", ThrottleMaxPWM); err != nil {
-		zap.S().Warnf("unable to init steeringRightPWM arg: %v", err)
+	// Mqtt args are defined here rather than with cli.InitMqttFlags, that ignores config file values
+	mqttConfig := &cfg.MQTT
+	flag.StringVar(&mqttConfig.Broker, "mqtt-broker", envOr("MQTT_BROKER", mqttConfig.Broker), "Broker Uri, use MQTT_BROKER env if arg not set")
+	flag.StringVar(&mqttConfig.Username, "mqtt-username", envOr("MQTT_USERNAME", mqttConfig.Username), "Broker Username, use MQTT_USERNAME env if arg not set")
+	mqttConfig.Password = envOr("MQTT_PASSWORD", mqttConfig.Password)
+	flag.Var(secretValue{&mqttConfig.Password}, "mqtt-password", "Broker Password, MQTT_PASSWORD env if args not set")
+	flag.StringVar(&mqttConfig.ClientId, "mqtt-client-id", envOr("MQTT_CLIENT_ID", mqttConfig.ClientId), "Mqtt client id, use MQTT_CLIENT_ID env if args not set")
+	intVar(&mqttConfig.Qos, "mqtt-qos", "MQTT_QOS", "Qos to publish message, use MQTT_QOS env if arg not set")
+	boolVar(&mqttConfig.Retain, "mqtt-retain", "MQTT_RETAIN", "Retain mqtt message, if not set, true if MQTT_RETAIN env variable is set")
+
+	topics := &cfg.MQTT.Topics
+	flag.Float64Var(&cfg.MQTT.PubFrequency, "mqtt-pub-frequency", cfg.MQTT.PubFrequency, "Number of messages to publish per second")
+	flag.StringVar(&topics.Throttle, "mqtt-topic-throttle", envOr("MQTT_TOPIC_THROTTLE", topics.Throttle), "Mqtt topic where to publish throttle values, use MQTT_TOPIC_THROTTLE if args not set")
+	flag.StringVar(&topics.Steering, "mqtt-topic-steering", envOr("MQTT_TOPIC_STEERING", topics.Steering), "Mqtt topic where to publish steering values, use MQTT_TOPIC_STEERING if args not set")
+	flag.StringVar(&topics.DriveMode, "mqtt-topic-drive-mode", envOr("MQTT_TOPIC_DRIVE_MODE", topics.DriveMode), "Mqtt topic where to publish drive mode state, use MQTT_TOPIC_DRIVE_MODE if args not set")
+	flag.StringVar(&topics.SwitchRecord, "mqtt-topic-switch-record", envOr("MQTT_TOPIC_SWITCH_RECORD", topics.SwitchRecord), "Mqtt topic where to publish switch record state, use MQTT_TOPIC_SWITCH_RECORD if args not set")
+	flag.StringVar(&topics.ThrottleFeedback, "mqtt-topic-throttle-feedback", envOr("MQTT_TOPIC_THROTTLE_FEEDBACK", topics.ThrottleFeedback), "Mqtt topic where to publish throttle feedback, use MQTT_TOPIC_THROTTLE_FEEDBACK if args not set")
+	flag.StringVar(&topics.MaxThrottleCtrl, "mqtt-topic-max-throttle-ctrl", envOr("MQTT_TOPIC_MAX_THROTTLE_CTRL", topics.MaxThrottleCtrl), "Mqtt topic where to publish max throttle value allowed, use MQTT_TOPIC_MAX_THROTTLE_CTRL if args not set")
+	flag.StringVar(&topics.LinkState, "mqtt-topic-link-state", envOr("MQTT_TOPIC_LINK_STATE", topics.LinkState), "Mqtt topic where to publish serial link state, use MQTT_TOPIC_LINK_STATE if args not set")
+	flag.StringVar(&topics.Failsafe, "mqtt-topic-failsafe", envOr("MQTT_TOPIC_FAILSAFE", topics.Failsafe), "Mqtt topic where to publish failsafe state, use MQTT_TOPIC_FAILSAFE if args not set")
+	flag.StringVar(&topics.Clock, "mqtt-topic-clock", envOr("MQTT_TOPIC_CLOCK", topics.Clock), "Mqtt topic where to publish arduino clock synchronization statistics, use MQTT_TOPIC_CLOCK if args not set")
+	flag.StringVar(&topics.Signal, "mqtt-topic-signal", envOr("MQTT_TOPIC_SIGNAL", topics.Signal), "Mqtt topic where to publish RC signal frequency reported by arduino, use MQTT_TOPIC_SIGNAL if args not set")
+	flag.DurationVar(&clockInterval, "clock-interval", durationFromEnv("CLOCK_INTERVAL", arduino.DefaultClockInterval), "delay between two clock synchronization messages, CLOCK_INTERVAL env if args not set")
+	flag.DurationVar(&signalInterval, "signal-interval", durationFromEnv("SIGNAL_INTERVAL", arduino.DefaultSignalInterval), "delay between two RC signal quality messages, SIGNAL_INTERVAL env if args not set")
+	flag.StringVar(&topics.SecondarySteering, "mqtt-topic-secondary-steering", envOr("MQTT_TOPIC_SECONDARY_STEERING", topics.SecondarySteering), "Mqtt topic where to publish secondary steering values (channel 7), use MQTT_TOPIC_SECONDARY_STEERING if args not set")
+	flag.StringVar(&topics.SecondaryThrottle, "mqtt-topic-secondary-throttle", envOr("MQTT_TOPIC_SECONDARY_THROTTLE", topics.SecondaryThrottle), "Mqtt topic where to publish secondary throttle values (channel 8), use MQTT_TOPIC_SECONDARY_THROTTLE if args not set")
+	flag.StringVar(&topics.AutopilotSteering, "mqtt-topic-autopilot-steering", envOr("MQTT_TOPIC_AUTOPILOT_STEERING", topics.AutopilotSteering), "Mqtt topic where to read autopilot steering to write on arduino, use MQTT_TOPIC_AUTOPILOT_STEERING if args not set")
+	flag.StringVar(&topics.AutopilotThrottle, "mqtt-topic-autopilot-throttle", envOr("MQTT_TOPIC_AUTOPILOT_THROTTLE", topics.AutopilotThrottle), "Mqtt topic where to read autopilot throttle to write on arduino, use MQTT_TOPIC_AUTOPILOT_THROTTLE if args not set")
+	flag.StringVar(&topics.Config, "mqtt-topic-config", envOr("MQTT_TOPIC_CONFIG", topics.Config), "Mqtt topic where to read json pwm configs and throttle feedback thresholds to apply at runtime, use MQTT_TOPIC_CONFIG if args not set")
+	flag.StringVar(&topics.ConfigStatus, "mqtt-topic-config-status", envOr("MQTT_TOPIC_CONFIG_STATUS", topics.ConfigStatus), "Mqtt topic where to publish version of applied runtime config, use MQTT_TOPIC_CONFIG_STATUS if args not set")
+	flag.StringVar(&topics.Speed, "mqtt-topic-speed", envOr("MQTT_TOPIC_SPEED", topics.Speed), "Mqtt topic where to publish motor rpm, wheel speed and travelled distance as json, use MQTT_TOPIC_SPEED if args not set")
+	flag.Float64Var(&commandFrequency, "command-frequency", arduino.DefaultCommandFrequency, "Max number of commands to write on arduino per second")
+	flag.StringVar(&cfg.Serial.Device, "device", cfg.Serial.Device, "Serial device")
+	flag.IntVar(&cfg.Serial.Baud, "baud", cfg.Serial.Baud, "Serial baud")
+	publishConfig := &cfg.Publish
+	flag.StringVar(&publishConfig.Mode, "publish-mode", envOr("PUBLISH_MODE", publishConfig.Mode), "ticker to publish all values at mqtt-pub-frequency, event to publish values on change, PUBLISH_MODE env if args not set")
+	floatVar(&publishConfig.Epsilon, "publish-epsilon", "PUBLISH_EPSILON", "event mode: min change of percent values to publish a new message, PUBLISH_EPSILON env if args not set")
+	durationVar(&publishConfig.MinInterval, "publish-min-interval", "PUBLISH_MIN_INTERVAL", "event mode: min delay between two messages on same topic, PUBLISH_MIN_INTERVAL env if args not set")
+	funcVar(roleDurationsSetter(&publishConfig.MinIntervals), func() string { return formatRoleDurations(publishConfig.MinIntervals) },
+		"publish-min-intervals", "PUBLISH_MIN_INTERVALS", "event mode: min delay between two messages by role (ex: 'throttle:10ms,drive-mode:100ms'), PUBLISH_MIN_INTERVALS env if args not set")
+	durationVar(&publishConfig.Heartbeat, "publish-heartbeat", "PUBLISH_HEARTBEAT", "event mode: max delay before to publish again an unchanged value, PUBLISH_HEARTBEAT env if args not set")
+	funcVar(topicPoliciesSetter(&mqttConfig.TopicPolicies), func() string { return formatTopicPolicies(mqttConfig.TopicPolicies) },
+		"mqtt-topic-policies", "MQTT_TOPIC_POLICIES", "qos and retain flag by topic, override mqtt-qos and mqtt-retain (ex: 'car/part/arduino/drive_mode:1:retain,car/part/arduino/throttle/target:0'), MQTT_TOPIC_POLICIES env if args not set")
+	flag.StringVar(&httpListen, "http-listen", os.Getenv("HTTP_LISTEN"), "Address where to expose prometheus metrics (/metrics) and health status (/healthz), ex: ':9100', disabled if empty, HTTP_LISTEN env if args not set")
+	flag.DurationVar(&healthTimeout, "health-timeout", durationFromEnv("HEALTH_TIMEOUT", arduino.DefaultHealthTimeout), "max delay without serial data before to report unhealthy status, HEALTH_TIMEOUT env if args not set")
+	flag.StringVar(&cfg.RecordFile, "record-file", envOr("RECORD_FILE", cfg.RecordFile), "run command: file where to record raw serial stream, replay and learn-thresholds commands: recorded session to read, RECORD_FILE env if args not set")
+	flag.Float64Var(&replaySpeed, "replay-speed", 1., "replay command: replay speed factor, 1 for real time, 0 to replay as fast as possible")
+	flag.DurationVar(&calibrationStepDuration, "calibration-step-duration", durationFromEnv("CALIBRATION_STEP_DURATION", calibration.DefaultStepDuration), "calibrate command: recording duration of each calibration step, CALIBRATION_STEP_DURATION env if args not set")
+	flag.StringVar(&thresholdsOutput, "thresholds-output", os.Getenv("THRESHOLDS_OUTPUT"), "learn-thresholds command: json file where to write throttle feedback thresholds, THRESHOLDS_OUTPUT env if args not set")
+	flag.DurationVar(&thresholdsSettleDelay, "thresholds-settle-delay", durationFromEnv("THRESHOLDS_SETTLE_DELAY", calibration.DefaultSettleDelay), "learn-thresholds command: delay after a throttle change before to use feedback values, THRESHOLDS_SETTLE_DELAY env if args not set")
+	thresholdsTolerance = calibration.DefaultThrottleTolerance
+	floatVar(&thresholdsTolerance, "thresholds-tolerance", "THRESHOLDS_TOLERANCE", "learn-thresholds command: max throttle change inside a throttle step, THRESHOLDS_TOLERANCE env if args not set")
+	flag.StringVar(&cfg.Serial.Protocol, "serial-protocol", envOr("SERIAL_PROTOCOL", cfg.Serial.Protocol), "Serial protocol used by arduino: auto, csv or binary, SERIAL_PROTOCOL env if args not set")
+	flag.StringVar(&feedback.config, "throttle-feedback-config", "", "config file that described thresholds to map pwm to percent the throttle feedback")
+	flag.StringVar(&feedback.interpolation, "throttle-feedback-interpolation", os.Getenv("THROTTLE_FEEDBACK_INTERPOLATION"), "interpolation between throttle feedback thresholds: step, linear or monotone-cubic, THROTTLE_FEEDBACK_INTERPOLATION env if args not set")
+	flag.StringVar(&feedback.outOfRange, "throttle-feedback-out-of-range", os.Getenv("THROTTLE_FEEDBACK_OUT_OF_RANGE"), "throttle feedback value outside of thresholds: zero, clamp or extrapolate, THROTTLE_FEEDBACK_OUT_OF_RANGE env if args not set")
+	var speedConfig speed.Config
+	if cfg.Speed != nil {
+		speedConfig = *cfg.Speed
 	}
-	if err := cli.SetIntDefaultValueFromEnv(&throttleZeroPWM, "THROTTLE_ZERO_PWM", ThrottleZeroPWM); err != nil {
-		zap.S().Warnf("unable to init steeringRightPWM arg: %v", err)
+	intVar(&speedConfig.PoleCount, "speed-pole-count", "SPEED_POLE_COUNT", "number of magnetic poles of motor, used to convert throttle feedback to speed, SPEED_POLE_COUNT env if args not set")
+	floatVar(&speedConfig.GearRatio, "speed-gear-ratio", "SPEED_GEAR_RATIO", "number of motor revolutions for one wheel revolution, SPEED_GEAR_RATIO env if args not set")
+	floatVar(&speedConfig.WheelDiameter, "speed-wheel-diameter", "SPEED_WHEEL_DIAMETER", "wheel diameter in meters, SPEED_WHEEL_DIAMETER env if args not set")
+	intVar(&speedConfig.MinPeriod, "speed-min-period", "SPEED_MIN_PERIOD", "min valid throttle feedback period in µs, motor is considered stopped under, SPEED_MIN_PERIOD env if args not set")
+	intVar(&speedConfig.MaxPeriod, "speed-max-period", "SPEED_MAX_PERIOD", "max valid throttle feedback period in µs, motor is considered stopped above, 0 to disable, SPEED_MAX_PERIOD env if args not set")
+	flag.StringVar(&channelMappingConfig, "channel-mapping-config", os.Getenv("CHANNEL_MAPPING_CONFIG"), "json config file that maps arduino channels to their role (steering, throttle, drive-mode...), replaces channel_mapping of config file, CHANNEL_MAPPING_CONFIG env if args not set")
+
+	steering := &cfg.PWM.Steering
+	intVar(&steering.Min, "steering-left-pwm", "STEERING_LEFT_PWM", "maxPwm left value for steering PWM, STEERING_LEFT_PWM env if args not set")
+	intVar(&steering.Max, "steering-right-pwm", "STEERING_RIGHT_PWM", "maxPwm right value for steering PWM, STEERING_RIGHT_PWM env if args not set")
+	intVar(&steering.Middle, "steering-center-pwm", "STEERING_CENTER_PWM", "middlePwm value for steering PWM, STEERING_CENTER_PWM env if args not set")
+	intVar(&steering.Deadband, "steering-deadband-pwm", "STEERING_DEADBAND_PWM", "pwm half width around steering center where value is 0, STEERING_DEADBAND_PWM env if args not set")
+	intVar(&steering.Trim, "steering-trim-pwm", "STEERING_TRIM_PWM", "pwm offset applied to steering center, STEERING_TRIM_PWM env if args not set")
+	boolVar(&steering.Reverse, "steering-reverse", "STEERING_REVERSE", "reverse steering direction, STEERING_REVERSE env if args not set")
+
+	throttle := &cfg.PWM.Throttle
+	// THROTTLE_ZERO_PWM is the legacy name of THROTTLE_CENTER_PWM env
+	throttleCenterEnv := "THROTTLE_CENTER_PWM"
+	if os.Getenv(throttleCenterEnv) == "" && os.Getenv("THROTTLE_ZERO_PWM") != "" {
+		throttleCenterEnv = "THROTTLE_ZERO_PWM"
 	}
+	intVar(&throttle.Min, "throttle-min-pwm", "THROTTLE_MIN_PWM", "maxPwm min value for throttle PWM, THROTTLE_MIN_PWM env if args not set")
+	intVar(&throttle.Max, "throttle-max-pwm", "THROTTLE_MAX_PWM", "maxPwm max value for throttle PWM, THROTTLE_MAX_PWM env if args not set")
+	intVar(&throttle.Middle, "throttle-center-pwm", throttleCenterEnv, "middlePwm value for throttle PWM, THROTTLE_CENTER_PWM env (or legacy THROTTLE_ZERO_PWM) if args not set")
+	intVar(&throttle.Deadband, "throttle-deadband-pwm", "THROTTLE_DEADBAND_PWM", "pwm half width around throttle center where value is 0, THROTTLE_DEADBAND_PWM env if args not set")
+	intVar(&throttle.Trim, "throttle-trim-pwm", "THROTTLE_TRIM_PWM", "pwm offset applied to throttle center, THROTTLE_TRIM_PWM env if args not set")
+	boolVar(&throttle.Reverse, "throttle-reverse", "THROTTLE_REVERSE", "reverse throttle direction, THROTTLE_REVERSE env if args not set")
 
-	flag.Float64Var(&pubFrequency, "mqtt-pub-frequency", 25., "Number of messages to publish per second")
-	flag.StringVar(&throttleTopic, "mqtt-topic-throttle", os.Getenv("MQTT_TOPIC_THROTTLE"), "Mqtt topic where to publish throttle values, use MQTT_TOPIC_THROTTLE if args not set")
-	flag.StringVar(&steeringTopic, "mqtt-topic-steering", os.Getenv("MQTT_TOPIC_STEERING"), "Mqtt topic where to publish steering values, use MQTT_TOPIC_STEERING if args not set")
-	flag.StringVar(&driveModeTopic, "mqtt-topic-drive-mode", os.Getenv("MQTT_TOPIC_DRIVE_MODE"), "Mqtt topic where to publish drive mode state, use MQTT_TOPIC_DRIVE_MODE if args not set")
-	flag.StringVar(&switchRecordTopic, "mqtt-topic-switch-record", os.Getenv("MQTT_TOPIC_SWITCH_RECORD"), "Mqtt topic where to publish switch record state, use MQTT_TOPIC_SWITCH_RECORD if args not set")
-	flag.StringVar(&throttleFeedbackTopic, "mqtt-topic-throttle-feedback", os.Getenv("MQTT_TOPIC_THROTTLE_FEEDBACK"), "Mqtt topic where to publish throttle feedback, use MQTT_TOPIC_THROTTLE_FEEDBACK if args not set")
-	flag.StringVar(&maxThrottleCtrlTopic, "mqtt-topic-max-throttle-ctrl", os.Getenv("MQTT_TOPIC_MAX_THROTTLE_CTRL"), "Mqtt topic where to publish max throttle value allowed, use MQTT_TOPIC_MAX_THROTTLE_CTRL if args not set")
-	flag.StringVar(&device, "device", "/dev/serial0", "Serial device")
-	flag.IntVar(&baud, "baud", 115200, "Serial baud")
-	flag.StringVar(&feedbackConfig, "throttle-feedback-config", "", "config file that described thresholds to map pwm to percent the throttle feedback")
+	ctrlThrottle := &cfg.PWM.MaxThrottleCtrl
+	intVar(&ctrlThrottle.Min, "ctrl-throttle-min-pwm", "CTRL_THROTTLE_MIN_PWM", "maxPwm min value for control throttle PWM, CTRL_THROTTLE_MIN_PWM env if args not set")
+	intVar(&ctrlThrottle.Max, "ctrl-throttle-max-pwm", "CTRL_THROTTLE_MAX_PWM", "maxPwm max value for control throttle PWM, CTRL_THROTTLE_MAX_PWM env if args not set")
+	intVar(&ctrlThrottle.Deadband, "max-throttle-ctrl-deadband-pwm", "MAX_THROTTLE_CTRL_DEADBAND_PWM", "pwm half width around max throttle ctrl center where value is 0.5, MAX_THROTTLE_CTRL_DEADBAND_PWM env if args not set")
+	intVar(&ctrlThrottle.Trim, "max-throttle-ctrl-trim-pwm", "MAX_THROTTLE_CTRL_TRIM_PWM", "pwm offset applied to max throttle ctrl center, MAX_THROTTLE_CTRL_TRIM_PWM env if args not set")
+	boolVar(&ctrlThrottle.Reverse, "max-throttle-ctrl-reverse", "MAX_THROTTLE_CTRL_REVERSE", "reverse max throttle ctrl direction, MAX_THROTTLE_CTRL_REVERSE env if args not set")
 
-	flag.IntVar(&steeringLeftPWM, "steering-left-pwm", steeringLeftPWM, "maxPwm left value for steering PWM, STEERING_LEFT_PWM env if args not set")
-	flag.IntVar(&steeringRightPWM, "steering-right-pwm", steeringRightPWM, "maxPwm right value for steering PWM, STEERING_RIGHT_PWM env if args not set")
-	flag.IntVar(&steeringCenterPWM, "steering-center-pwm", steeringCenterPWM, "middlePwm value for steering PWM, STEERING_CENTER_PWM env if args not set")
+	secondarySteering := &cfg.PWM.SecondarySteering
+	intVar(&secondarySteering.Min, "secondary-steering-left-pwm", "SECONDARY_STEERING_LEFT_PWM", "maxPwm left value for secondary steering PWM, SECONDARY_STEERING_LEFT_PWM env if args not set")
+	intVar(&secondarySteering.Max, "secondary-steering-right-pwm", "SECONDARY_STEERING_RIGHT_PWM", "maxPwm right value for secondary steering PWM, SECONDARY_STEERING_RIGHT_PWM env if args not set")
+	intVar(&secondarySteering.Middle, "secondary-steering-center-pwm", "SECONDARY_STEERING_CENTER_PWM", "middlePwm value for secondary steering PWM, SECONDARY_STEERING_CENTER_PWM env if args not set")
 
-	flag.IntVar(&throttleMinPWM, "throttle-min-pwm", throttleMinPWM, "maxPwm min value for throttle PWM, THROTTLE_MIN_PWM env if args not set")
-	flag.IntVar(&throttleMaxPWM, "throttle-max-pwm", throttleMaxPWM, "maxPwm max value for throttle PWM, THROTTLE_MAX_PWM env if args not set")
-	flag.IntVar(&throttleZeroPWM, "throttle-center-pwm", throttleZeroPWM, "middlePwm value for throttle PWM, THROTTLE_CENTER_PWM env if args not set")
+	secondaryThrottle := &cfg.PWM.SecondaryThrottle
+	intVar(&secondaryThrottle.Min, "secondary-throttle-min-pwm", "SECONDARY_THROTTLE_MIN_PWM", "maxPwm min value for secondary throttle PWM, SECONDARY_THROTTLE_MIN_PWM env if args not set")
+	intVar(&secondaryThrottle.Max, "secondary-throttle-max-pwm", "SECONDARY_THROTTLE_MAX_PWM", "maxPwm max value for secondary throttle PWM, SECONDARY_THROTTLE_MAX_PWM env if args not set")
+	intVar(&secondaryThrottle.Middle, "secondary-throttle-center-pwm", "SECONDARY_THROTTLE_CENTER_PWM", "middlePwm value for secondary throttle PWM, SECONDARY_THROTTLE_CENTER_PWM env if args not set")
 
-	var ctrlThrottleMinPWM, ctrlThrottleMaxPWM int
-	if err := cli.SetIntDefaultValueFromEnv(&ctrlThrottleMinPWM, "CTRL_THROTTLE_MIN_PWM", arduino.DefaultPwmThrottle.Min); err != nil {
-		zap.S().Warnf("unable to init ctlThrottleMinPWM arg: %v", err)
-	}
-	if err := cli.SetIntDefaultValueFromEnv(&ctrlThrottleMaxPWM, "CTRL_THROTTLE_MAX_PWM", arduino.DefaultPwmThrottle.Max); err != nil {
-		zap.S().Warnf("unable to init ctrlThrottleMaxPWM arg: %v", err)
-	}
-	flag.IntVar(&ctrlThrottleMinPWM, "ctrl-throttle-min-pwm", ctrlThrottleMinPWM, "maxPwm min value for control throttle PWM, CTRL_THROTTLE_MIN_PWM env if args not set")
-	flag.IntVar(&ctrlThrottleMaxPWM, "ctrl-throttle-max-pwm", ctrlThrottleMaxPWM, "maxPwm max value for control throttle PWM, CTRL_THROTTLE_MAX_PWM env if args not set")
+	override := &cfg.Override
+	flag.StringVar(&override.Priority, "override-priority", envOr("OVERRIDE_PRIORITY", override.Priority), "controls to publish on steering/throttle topics when secondary transmitter is used: none, primary (primary overrides secondary) or secondary (secondary overrides primary), OVERRIDE_PRIORITY env if args not set")
+	floatVar(&override.Threshold, "override-threshold", "OVERRIDE_THRESHOLD", "percent value under which controls are considered as neutral for override, OVERRIDE_THRESHOLD env if args not set")
+
+	copilotMinPWM, pilotMinPWM := arduino.DefaultDriveModeTable[1].Min, arduino.DefaultDriveModeTable[2].Min
+	var driveModeConfig, curvesConfig string
+	switches := &cfg.Switches
+	flag.StringVar(&driveModeConfig, "drive-mode-config", os.Getenv("DRIVE_MODE_CONFIG"), "json config file that maps pwm ranges of drive mode switch to drive modes (ex: '[{\"min\": 900, \"max\": 1499, \"mode\": \"USER\"}, {\"min\": 1500, \"max\": 2100, \"mode\": \"PILOT\"}]'), replaces drive_modes of config file and has precedence over copilot/pilot min pwm args, DRIVE_MODE_CONFIG env if args not set")
+	flag.StringVar(&curvesConfig, "curves-config", os.Getenv("CURVES_CONFIG"), "json config file with response curves by role (ex: '{\"steering\": {\"type\": \"expo\", \"expo\": 0.3, \"rate\": 0.8}}'), replaces curves of config file, CURVES_CONFIG env if args not set")
+	funcVar(roleFiltersSetter(&cfg.Filters), func() string { return formatRoleFilters(cfg.Filters) },
+		"filters", "FILTERS", "filters applied on raw pwm values by role, list of 'role=filter' separated by ';', filters are moving-average:N, ema:alpha, median:N or slew:N and can be chained with ',' (ex: 'steering=median:5,ema:0.3;throttle=slew:20'), FILTERS env if args not set")
+	intVar(&copilotMinPWM, "drive-mode-copilot-min-pwm", "DRIVE_MODE_COPILOT_MIN_PWM", "min pwm value of 3 positions drive mode switch to select copilot mode, replaces drive_modes of config file, DRIVE_MODE_COPILOT_MIN_PWM env if args not set")
+	intVar(&pilotMinPWM, "drive-mode-pilot-min-pwm", "DRIVE_MODE_PILOT_MIN_PWM", "min pwm value of 3 positions drive mode switch to select pilot mode, replaces drive_modes of config file, DRIVE_MODE_PILOT_MIN_PWM env if args not set")
+	intVar(&switches.RecordMinPWM, "record-min-pwm", "RECORD_MIN_PWM", "min pwm value of record switch to enable record, RECORD_MIN_PWM env if args not set")
+	intVar(&switches.DriveModeHysteresis, "drive-mode-hysteresis", "DRIVE_MODE_HYSTERESIS", "pwm band around drive mode thresholds where current mode is kept, DRIVE_MODE_HYSTERESIS env if args not set")
+	intVar(&switches.RecordHysteresis, "record-hysteresis", "RECORD_HYSTERESIS", "pwm band around record threshold where current state is kept, RECORD_HYSTERESIS env if args not set")
+	durationVar(&switches.DriveModeDebounce, "drive-mode-debounce", "DRIVE_MODE_DEBOUNCE", "min duration a new drive mode must be stable before to be applied, DRIVE_MODE_DEBOUNCE env if args not set")
+	durationVar(&switches.RecordDebounce, "record-debounce", "RECORD_DEBOUNCE", "min duration a new record state must be stable before to be applied, RECORD_DEBOUNCE env if args not set")
+
+	failsafe := &cfg.Failsafe
+	durationVar(&failsafe.Timeout, "failsafe-timeout", "FAILSAFE_TIMEOUT", "max delay without valid serial line before to engage failsafe, 0 to disable, FAILSAFE_TIMEOUT env if args not set")
+	funcVar(channelDurationsSetter(&failsafe.ChannelTimeouts), func() string { return formatChannelDurations(failsafe.ChannelTimeouts) },
+		"failsafe-channel-timeouts", "FAILSAFE_CHANNEL_TIMEOUTS", "max delay without valid pulse per channel before to engage failsafe (ex: '1:500ms,2:500ms'), FAILSAFE_CHANNEL_TIMEOUTS env if args not set")
+	funcVar(channelIntsSetter(&failsafe.ReceiverPWM), func() string { return formatChannelInts(failsafe.ReceiverPWM) },
+		"failsafe-receiver-pwm", "FAILSAFE_RECEIVER_PWM", "pwm values sent by RC receiver in failsafe mode (ex: '1:1500,2:1000'), FAILSAFE_RECEIVER_PWM env if args not set")
+	intVar(&failsafe.ReceiverTolerance, "failsafe-receiver-tolerance", "FAILSAFE_RECEIVER_TOLERANCE", "tolerance on receiver failsafe pwm values, FAILSAFE_RECEIVER_TOLERANCE env if args not set")
+	durationVar(&failsafe.ReceiverDelay, "failsafe-receiver-delay", "FAILSAFE_RECEIVER_DELAY", "min duration at receiver failsafe pwm values before to engage failsafe, FAILSAFE_RECEIVER_DELAY env if args not set")
+	intVar(&failsafe.MinFrequency, "failsafe-min-frequency", "FAILSAFE_MIN_FREQUENCY", "min RC signal frequency reported by arduino before to engage failsafe, 0 to disable, FAILSAFE_MIN_FREQUENCY env if args not set")
+	durationVar(&failsafe.FrequencyDelay, "failsafe-frequency-delay", "FAILSAFE_FREQUENCY_DELAY", "min duration under failsafe min frequency before to engage failsafe, FAILSAFE_FREQUENCY_DELAY env if args not set")
 
 	logLevel := zap.LevelFlag("log", zap.InfoLevel, "log level")
-	flag.Parse()
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %v [run|replay|calibrate|learn-thresholds] [flags]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	if err := flag.CommandLine.Parse(args); err != nil {
+		os.Exit(2)
+	}
 
 	if len(os.Args) <= 1 {
-		flag.PrintDefaults()
+		flag.Usage()
+		os.Exit(1)
+	}
+	if command != CommandRun && command != CommandReplay && command != CommandCalibrate && command != CommandLearnThresholds {
+		fmt.Fprintf(flag.CommandLine.Output(), "unknown command '%v'\n", command)
+		flag.Usage()
 		os.Exit(1)
 	}
 
-	config := zap.NewDevelopmentConfig()
-	config.Level = zap.NewAtomicLevelAt(*logLevel)
-	lgr, err := config.Build()
+	logConfig := zap.NewDevelopmentConfig()
+	logConfig.Level = zap.NewAtomicLevelAt(*logLevel)
+	lgr, err := logConfig.Build()
 	if err != nil {
 		log.Fatalf("unable to init logger: %v", err)
 	}
@@ -119,29 +229,514 @@ func main() {
 	}()
 	zap.ReplaceGlobals(lgr)
 
-	client, err := cli.Connect(mqttBroker, username, password, clientId)
+	if isSet("ctrl-throttle-min-pwm", "CTRL_THROTTLE_MIN_PWM") || isSet("ctrl-throttle-max-pwm", "CTRL_THROTTLE_MAX_PWM") {
+		ctrlThrottle.Middle = ctrlThrottle.Min + (ctrlThrottle.Max-ctrlThrottle.Min)/2
+	}
+	if isSet("drive-mode-copilot-min-pwm", "DRIVE_MODE_COPILOT_MIN_PWM") || isSet("drive-mode-pilot-min-pwm", "DRIVE_MODE_PILOT_MIN_PWM") {
+		cfg.DriveModes = arduino.NewDriveModeTable(copilotMinPWM, pilotMinPWM)
+	}
+	if driveModeConfig != "" {
+		cfg.DriveModes, err = arduino.NewDriveModeTableFromJson(driveModeConfig)
+		if err != nil {
+			zap.S().Fatalf("unable to load drive mode table: %v", err)
+		}
+	}
+	if channelMappingConfig != "" {
+		cfg.ChannelMapping, err = arduino.NewChannelMappingFromJson(channelMappingConfig)
+		if err != nil {
+			zap.S().Fatalf("unable to load channel mapping: %v", err)
+		}
+	}
+	if curvesConfig != "" {
+		cfg.Curves, err = curve.NewConfigsFromJson(curvesConfig)
+		if err != nil {
+			zap.S().Fatalf("unable to load response curves: %v", err)
+		}
+	}
+	if feedback.isSet() {
+		if err := feedback.apply(cfg); err != nil {
+			zap.S().Fatalf("unable to load throttle feedback thresholds: %v", err)
+		}
+	}
+	// Speed estimation is enabled by config file or by any speed arg
+	speedEnabled := cfg.Speed != nil
+	for _, arg := range []struct{ name, env string }{
+		{"speed-pole-count", "SPEED_POLE_COUNT"},
+		{"speed-gear-ratio", "SPEED_GEAR_RATIO"},
+		{"speed-wheel-diameter", "SPEED_WHEEL_DIAMETER"},
+		{"speed-min-period", "SPEED_MIN_PERIOD"},
+		{"speed-max-period", "SPEED_MAX_PERIOD"},
+	} {
+		speedEnabled = speedEnabled || isSet(arg.name, arg.env)
+	}
+	if speedEnabled {
+		cfg.Speed = &speedConfig
+	}
+	if err := cfg.Validate(); err != nil {
+		zap.S().Fatalf("invalid config: %v", err)
+	}
+	if printConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			zap.S().Fatalf("unable to print config: %v", err)
+		}
+		return
+	}
+
+	if command == CommandCalibrate {
+		if err := calibrate(cfg.Serial, cfg.Mapping(), configFile, calibrationStepDuration); err != nil {
+			zap.S().Fatalf("unable to calibrate: %v", err)
+		}
+		return
+	}
+	if command == CommandLearnThresholds {
+		opts := calibration.FitOptions{
+			SettleDelay:   thresholdsSettleDelay,
+			Tolerance:     thresholdsTolerance,
+			MinValid:      cfg.ThrottleFeedback.MinValid,
+			Interpolation: cfg.ThrottleFeedback.Interpolation,
+			OutOfRange:    cfg.ThrottleFeedback.OutOfRange,
+		}
+		if err := learnThresholds(cfg, cfg.RecordFile, thresholdsOutput, opts); err != nil {
+			zap.S().Fatalf("unable to learn thresholds: %v", err)
+		}
+		return
+	}
+
+	client, err := cli.Connect(cfg.MQTT.Broker, cfg.MQTT.Username, cfg.MQTT.Password, cfg.MQTT.ClientId)
 	if err != nil {
 		zap.S().Fatalf("unable to connect to mqtt broker: %v", err)
 	}
 	defer client.Disconnect(10)
 
-	sc := arduino.NewAsymetricPWMConfig(steeringLeftPWM, steeringRightPWM, steeringCenterPWM)
-	ctrlThrottlrConfig := arduino.NewPWMConfig(ctrlThrottleMinPWM, ctrlThrottleMaxPWM)
-	tc := arduino.NewAsymetricPWMConfig(throttleMinPWM, throttleMaxPWM, throttleZeroPWM)
-
-	a := arduino.NewPart(client, device, baud, throttleTopic, steeringTopic, driveModeTopic, switchRecordTopic,
-		throttleFeedbackTopic, maxThrottleCtrlTopic,
-		pubFrequency,
-		arduino.WithThrottleFeedbackConfig(feedbackConfig),
-		arduino.WithThrottleConfig(tc),
-		arduino.WithSteeringConfig(sc),
-		arduino.WithMaxThrottleCtrl(ctrlThrottlrConfig),
+	// Config values have been checked by cfg.Validate()
+	protocol, _ := arduino.ParseProtocol(cfg.Serial.Protocol)
+	priority, _ := arduino.ParseOverridePriority(cfg.Override.Priority)
+	curves, _ := arduino.NewCurves(cfg.Curves)
+	filters, _ := arduino.NewFilters(cfg.Filters)
+	publishPolicy := arduino.PublishPolicy{Qos: byte(cfg.MQTT.Qos), Retain: cfg.MQTT.Retain}
+
+	var serialOptions []arduino.Option
+	switch command {
+	case CommandReplay:
+		if cfg.RecordFile == "" {
+			zap.S().Fatalf("no session file to replay, use --record-file")
+		}
+		f, err := os.Open(cfg.RecordFile)
+		if err != nil {
+			zap.S().Fatalf("unable to open session file: %v", err)
+		}
+		serialOptions = append(serialOptions, arduino.WithSerial(record.NewReplayer(f, replaySpeed)))
+	case CommandRun:
+		if cfg.RecordFile != "" {
+			f, err := os.OpenFile(cfg.RecordFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+			if err != nil {
+				zap.S().Fatalf("unable to open record file: %v", err)
+			}
+			defer func() {
+				if err := f.Close(); err != nil {
+					zap.S().Errorf("unable to close record file: %v", err)
+				}
+			}()
+			serialOptions = append(serialOptions, arduino.WithRecorder(f))
+		}
+	}
+
+	options := append([]arduino.Option{
+		arduino.WithThrottleFeedbackThresholds(cfg.ThrottleFeedback),
+		arduino.WithThrottleConfig(throttle),
+		arduino.WithSteeringConfig(steering),
+		arduino.WithMaxThrottleCtrl(ctrlThrottle),
+		arduino.WithLinkStateTopic(topics.LinkState),
+		arduino.WithFailsafe(cfg.FailsafeConfig()),
+		arduino.WithFailsafeTopic(topics.Failsafe),
+		arduino.WithClockTopic(topics.Clock),
+		arduino.WithClockInterval(clockInterval),
+		arduino.WithSignalTopic(topics.Signal),
+		arduino.WithSignalInterval(signalInterval),
+		arduino.WithChannelMapping(cfg.Mapping()),
+		arduino.WithSwitchConfig(cfg.SwitchConfig()),
+		arduino.WithCurves(curves),
+		arduino.WithFilters(filters),
+		arduino.WithSecondarySteeringConfig(secondarySteering),
+		arduino.WithSecondaryThrottleConfig(secondaryThrottle),
+		arduino.WithSecondaryTopics(topics.SecondarySteering, topics.SecondaryThrottle),
+		arduino.WithOverride(priority, float32(cfg.Override.Threshold)),
+		arduino.WithProtocol(protocol),
+		arduino.WithAutopilotTopics(topics.AutopilotSteering, topics.AutopilotThrottle),
+		arduino.WithConfigTopics(topics.Config, topics.ConfigStatus),
+		arduino.WithSpeedEstimator(cfg.Speed),
+		arduino.WithSpeedTopic(topics.Speed),
+		arduino.WithCommandFrequency(commandFrequency),
+		arduino.WithPublishPolicy(publishPolicy),
+		arduino.WithTopicPolicies(cfg.MQTT.TopicPolicies),
+		arduino.WithEventPublish(cfg.EventPublishConfig()),
+		arduino.WithHTTPListener(httpListen),
+		arduino.WithHealthTimeout(healthTimeout),
+	}, serialOptions...)
+	a := arduino.NewPart(client, cfg.Serial.Device, cfg.Serial.Baud, topics.Throttle, topics.Steering, topics.DriveMode,
+		topics.SwitchRecord, topics.ThrottleFeedback, topics.MaxThrottleCtrl,
+		cfg.MQTT.PubFrequency,
+		options...,
 	)
 
 	cli.HandleExit(a)
+	reloadOnSignal(a, cfg, configFile, &feedback)
 
 	err = a.Start()
 	if err != nil {
 		zap.S().Errorw("unable to start service", "error", err)
 	}
 }
+
+// isSet returns true if value is given by arg or env
+func isSet(name, env string) bool {
+	if _, ok := os.LookupEnv(env); ok {
+		return true
+	}
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// loadConfigFile returns default config updated with config file values, empty file name is ignored
+func loadConfigFile(configFile string) (*config.Config, error) {
+	cfg := config.Default()
+	if configFile != "" {
+		if err := cfg.Load(configFile); err != nil {
+			return nil, fmt.Errorf("unable to load config: %w", err)
+		}
+	}
+	return cfg, nil
+}
+
+// reloadOnSignal reloads pwm configs and throttle feedback thresholds from config file each time SIGHUP is
+// received, values given by env or args keep precedence over reloaded ones
+func reloadOnSignal(a *arduino.Part, current *config.Config, configFile string, feedback *throttleFeedbackArgs) {
+	base, err := loadConfigFile(configFile)
+	if err != nil {
+		zap.S().Errorf("unable to load config file, reload on SIGHUP is disabled: %v", err)
+		return
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			zap.S().Info("SIGHUP received, reload config file")
+			reloaded, err := loadConfigFile(configFile)
+			if err != nil {
+				zap.S().Errorf("unable to reload config: %v", err)
+				continue
+			}
+			c := config.Reload(base, current, reloaded)
+			if feedback.isSet() {
+				c.ThrottleFeedback = reloaded.ThrottleFeedback
+				if err := feedback.apply(c); err != nil {
+					zap.S().Errorf("unable to reload throttle feedback thresholds: %v", err)
+					continue
+				}
+			}
+			if err := c.Validate(); err != nil {
+				zap.S().Errorf("reloaded config rejected: %v", err)
+				continue
+			}
+			if err := a.Reload(c.RuntimeConfig()); err != nil {
+				zap.S().Errorf("reloaded config rejected: %v", err)
+				continue
+			}
+			base, current = reloaded, c
+		}
+	}()
+}
+
+// throttleFeedbackArgs are args that override throttle feedback thresholds of config file
+type throttleFeedbackArgs struct {
+	config        string
+	interpolation string
+	outOfRange    string
+}
+
+func (f *throttleFeedbackArgs) isSet() bool {
+	return f.config != "" || f.interpolation != "" || f.outOfRange != ""
+}
+
+// apply replaces throttle feedback thresholds of c with thresholds file, then sets interpolation and out of range modes
+func (f *throttleFeedbackArgs) apply(c *config.Config) error {
+	thresholds := c.ThrottleFeedback.Copy()
+	if f.config != "" {
+		var err error
+		thresholds, err = tools.NewThresholdConfigFromJson(f.config)
+		if err != nil {
+			return err
+		}
+	}
+	if f.interpolation != "" {
+		thresholds.Interpolation = tools.Interpolation(f.interpolation)
+	}
+	if f.outOfRange != "" {
+		thresholds.OutOfRange = tools.OutOfRange(f.outOfRange)
+	}
+	c.ThrottleFeedback = thresholds
+	return nil
+}
+
+// argValue returns value of arg name, or value of env if arg isn't given. It reads args before they are parsed.
+func argValue(args []string, name, env string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		for _, prefix := range []string{"-" + name, "--" + name} {
+			if arg == prefix && i+1 < len(args) {
+				return args[i+1]
+			}
+			if strings.HasPrefix(arg, prefix+"=") {
+				return strings.TrimPrefix(arg, prefix+"=")
+			}
+		}
+	}
+	return os.Getenv(env)
+}
+
+// envOr returns value of env, or defaultValue if env isn't set
+func envOr(env, defaultValue string) string {
+	if v := os.Getenv(env); v != "" {
+		return v
+	}
+	return defaultValue
+}
+
+// intVar defines int arg, its default value is env value if set, else current value
+func intVar(value *int, name, env, usage string) {
+	if err := cli.SetIntDefaultValueFromEnv(value, env, *value); err != nil {
+		zap.S().Warnf("unable to init %v arg: %v", name, err)
+	}
+	flag.IntVar(value, name, *value, usage)
+}
+
+// floatVar defines float arg, its default value is env value if set, else current value
+func floatVar(value *float64, name, env, usage string) {
+	if err := cli.SetFloat64DefaultValueFromEnv(value, env, *value); err != nil {
+		zap.S().Warnf("unable to init %v arg: %v", name, err)
+	}
+	flag.Float64Var(value, name, *value, usage)
+}
+
+// boolVar defines bool arg, its default value is true if env is set, else current value
+func boolVar(value *bool, name, env, usage string) {
+	if _, ok := os.LookupEnv(env); ok {
+		*value = true
+	}
+	flag.BoolVar(value, name, *value, usage)
+}
+
+// durationVar defines duration arg, its default value is env value if set, else current value
+func durationVar(value *config.Duration, name, env, usage string) {
+	d := (*time.Duration)(value)
+	*d = durationFromEnv(env, *d)
+	flag.DurationVar(d, name, *d, usage)
+}
+
+// funcValue is an arg parsed by set and printed by format
+type funcValue struct {
+	set    func(s string) error
+	format func() string
+}
+
+func (f funcValue) String() string {
+	if f.format == nil {
+		return ""
+	}
+	return f.format()
+}
+
+func (f funcValue) Set(s string) error {
+	return f.set(s)
+}
+
+// funcVar defines arg parsed by set, env value is parsed over current value if set
+func funcVar(set func(s string) error, format func() string, name, env, usage string) {
+	if v := os.Getenv(env); v != "" {
+		if err := set(v); err != nil {
+			log.Fatalf("invalid %v env: %v", env, err)
+		}
+	}
+	flag.Var(funcValue{set: set, format: format}, name, usage)
+}
+
+// secretValue is a string arg whose value is redacted in usage
+type secretValue struct {
+	value *string
+}
+
+func (s secretValue) String() string {
+	if s.value == nil || *s.value == "" {
+		return ""
+	}
+	return "********"
+}
+
+func (s secretValue) Set(v string) error {
+	*s.value = v
+	return nil
+}
+
+func durationFromEnv(key string, defaultValue time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		zap.S().Warnf("invalid duration value for %v env: %v", key, err)
+		return defaultValue
+	}
+	return d
+}
+
+// parseChannelValues parses list of 'channel:value' separated by ','
+func parseChannelValues(s string, parseValue func(channel int, v string) error) error {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		fields := strings.SplitN(strings.TrimSpace(item), ":", 2)
+		if len(fields) != 2 {
+			return fmt.Errorf("invalid item '%v', should be 'channel:value'", item)
+		}
+		channel, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return fmt.Errorf("invalid channel in item '%v': %w", item, err)
+		}
+		if err := parseValue(channel, fields[1]); err != nil {
+			return fmt.Errorf("invalid value in item '%v': %w", item, err)
+		}
+	}
+	return nil
+}
+
+func parseChannelDurations(s string) (map[int]config.Duration, error) {
+	result := make(map[int]config.Duration)
+	err := parseChannelValues(s, func(channel int, v string) error {
+		d, err := time.ParseDuration(v)
+		result[channel] = config.Duration(d)
+		return err
+	})
+	return result, err
+}
+
+func parseChannelInts(s string) (map[int]int, error) {
+	result := make(map[int]int)
+	err := parseChannelValues(s, func(channel int, v string) error {
+		i, err := strconv.Atoi(v)
+		result[channel] = i
+		return err
+	})
+	return result, err
+}
+
+// parseRoleDurations parses list of 'role:duration' separated by ','
+func parseRoleDurations(s string) (map[string]config.Duration, error) {
+	result := make(map[string]config.Duration)
+	if strings.TrimSpace(s) == "" {
+		return result, nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		fields := strings.SplitN(strings.TrimSpace(item), ":", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid item '%v', should be 'role:duration'", item)
+		}
+		d, err := time.ParseDuration(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration in item '%v': %w", item, err)
+		}
+		result[fields[0]] = config.Duration(d)
+	}
+	return result, nil
+}
+
+// parseRoleFilters parses list of 'role=filter' separated by ';'
+func parseRoleFilters(s string) (map[string]string, error) {
+	result := make(map[string]string)
+	if strings.TrimSpace(s) == "" {
+		return result, nil
+	}
+	for _, item := range strings.Split(s, ";") {
+		role, spec, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid item '%v', should be 'role=filter'", item)
+		}
+		result[strings.TrimSpace(role)] = spec
+	}
+	return result, nil
+}
+
+// setter returns func that replaces *m with map parsed from its arg
+func setter[K comparable, V any](m *map[K]V, parse func(s string) (map[K]V, error)) func(s string) error {
+	return func(s string) error {
+		v, err := parse(s)
+		if err != nil {
+			return err
+		}
+		*m = v
+		return nil
+	}
+}
+
+func channelDurationsSetter(m *map[int]config.Duration) func(s string) error {
+	return setter(m, parseChannelDurations)
+}
+
+func channelIntsSetter(m *map[int]int) func(s string) error {
+	return setter(m, parseChannelInts)
+}
+
+func roleDurationsSetter(m *map[string]config.Duration) func(s string) error {
+	return setter(m, parseRoleDurations)
+}
+
+func roleFiltersSetter(m *map[string]string) func(s string) error {
+	return setter(m, parseRoleFilters)
+}
+
+func topicPoliciesSetter(m *map[string]arduino.PublishPolicy) func(s string) error {
+	return setter(m, arduino.ParseTopicPolicies)
+}
+
+// formatMap formats sorted items of m separated by sep, it is the reverse of parse funcs
+func formatMap[K comparable, V any](m map[K]V, sep string, item func(k K, v V) string) string {
+	items := make([]string, 0, len(m))
+	for k, v := range m {
+		items = append(items, item(k, v))
+	}
+	sort.Strings(items)
+	return strings.Join(items, sep)
+}
+
+func formatChannelDurations(m map[int]config.Duration) string {
+	return formatMap(m, ",", func(ch int, d config.Duration) string { return fmt.Sprintf("%d:%v", ch, d) })
+}
+
+func formatChannelInts(m map[int]int) string {
+	return formatMap(m, ",", func(ch int, v int) string { return fmt.Sprintf("%d:%d", ch, v) })
+}
+
+func formatRoleDurations(m map[string]config.Duration) string {
+	return formatMap(m, ",", func(role string, d config.Duration) string { return fmt.Sprintf("%v:%v", role, d) })
+}
+
+func formatRoleFilters(m map[string]string) string {
+	return formatMap(m, ";", func(role string, spec string) string { return fmt.Sprintf("%v=%v", role, spec) })
+}
+
+func formatTopicPolicies(m map[string]arduino.PublishPolicy) string {
+	return formatMap(m, ",", func(topic string, p arduino.PublishPolicy) string {
+		if p.Retain {
+			return fmt.Sprintf("%v:%d:retain", topic, p.Qos)
+		}
+		return fmt.Sprintf("%v:%d", topic, p.Qos)
+	})
+}
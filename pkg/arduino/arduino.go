@@ -1,18 +1,24 @@
 package arduino
 
 import (
-	"bufio"
+	"errors"
+	"fmt"
+	"github.com/cyrilix/robocar-arduino/pkg/clock"
+	"github.com/cyrilix/robocar-arduino/pkg/curve"
+	"github.com/cyrilix/robocar-arduino/pkg/filter"
+	"github.com/cyrilix/robocar-arduino/pkg/frame"
+	"github.com/cyrilix/robocar-arduino/pkg/metrics"
+	"github.com/cyrilix/robocar-arduino/pkg/speed"
 	"github.com/cyrilix/robocar-arduino/pkg/tools"
 	"github.com/cyrilix/robocar-protobuf/go/events"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
-	"github.com/tarm/serial"
 	"go.uber.org/zap"
 	"google.golang.org/protobuf/proto"
 	"io"
+	"net/http"
 	"regexp"
-	"strconv"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -44,18 +50,127 @@ type Part struct {
 	ctrlRecord                                                                             bool
 	driveMode                                                                              events.DriveMode
 	cancel                                                                                 chan interface{}
+	loopsWG                                                                                sync.WaitGroup
+
+	serialOpener                             SerialOpener
+	serialMutex                              sync.Mutex
+	recorder                                 io.Writer
+	reconnectMinBackoff, reconnectMaxBackoff time.Duration
+	linkStateTopic                           string
+	linkState                                LinkState
+	linkConnected                            bool
+	reconnections                            int
+
+	failsafeConfig        *FailsafeConfig
+	failsafeTopic         string
+	failsafe              bool
+	lastLine              time.Time
+	lastChannelValues     map[int]time.Time
+	receiverFailsafeSince time.Time
+	lowFrequencySince     time.Time
 
 	pwmSteeringConfig        *PWMConfig
 	pwmThrottleConfig        *PWMConfig
 	pwmMaxThrottleCtrlConfig *PWMConfig
 
+	channelMapping ChannelMapping
+	// decodeTime is the receive time of frame being decoded
+	decodeTime time.Time
+
+	curves             map[ChannelRole]curve.Curve
+	filters            map[ChannelRole]filter.Filter
+	switchConfig       *SwitchConfig
+	driveModeDebouncer debouncer
+	recordDebouncer    debouncer
+	protocol           Protocol
+	activeProtocol     Protocol
+	frameCounters      frame.Counters
+
+	autopilotSteeringTopic, autopilotThrottleTopic       string
+	autopilotSteering, autopilotThrottle                 float32
+	autopilotSteeringReceived, autopilotThrottleReceived bool
+	commandPending, neutralCommandPending                bool
+	commandFrequency                                     float64
+
+	secondarySteeringTopic, secondaryThrottleTopic string
+	pwmSecondarySteeringConfig                     *PWMConfig
+	pwmSecondaryThrottleConfig                     *PWMConfig
+	secondarySteering, secondaryThrottle           float32
+	secondarySteeringValid, secondaryThrottleValid bool
+	overridePriority                               OverridePriority
+	overrideThreshold                              float32
+
 	throttleFeedbackThresholds *tools.ThresholdConfig
+
+	speedEstimator *speed.Estimator
+	speedTopic     string
+
+	configTopic, configStatusTopic string
+	configVersion                  int64
+	reloadMutex                    sync.Mutex
+
+	eventPublish   *EventPublishConfig
+	eventPublisher eventPublisher
+	publishPolicy  PublishPolicy
+	topicPolicies  map[string]PublishPolicy
+	publishTimeout time.Duration
+	publishFailing atomic.Bool
+
+	signalTopic    string
+	signalInterval time.Duration
+
+	httpAddr            string
+	httpServer          *http.Server
+	healthTimeout       time.Duration
+	frequency           int
+	frameRate           float64
+	frameRateStart      time.Time
+	frameRateCount      int
+	framesTotal         metrics.Counter
+	invalidLines        metrics.Counter
+	publishErrors       metrics.Counter
+	publishDuration     metrics.Histogram
+	publishCallDuration metrics.Histogram
+
+	clockSync       *clock.Sync
+	clockTopic      string
+	clockInterval   time.Duration
+	frameSeq        uint64
+	frameCreatedAt  time.Time
+	frameReceivedAt time.Time
 }
 
 type PWMConfig struct {
-	Min    int
-	Max    int
-	Middle int
+	Min    int `json:"min"`
+	Max    int `json:"max"`
+	Middle int `json:"middle"`
+	// Deadband is the max distance to neutral (Middle + Trim) of pwm values converted to 0
+	Deadband int `json:"deadband,omitempty"`
+	// Trim shifts neutral pwm value
+	Trim int `json:"trim,omitempty"`
+	// Reverse inverts direction of converted values
+	Reverse bool `json:"reverse,omitempty"`
+}
+
+func (c *PWMConfig) Validate() error {
+	if c.Min >= c.Middle || c.Middle >= c.Max {
+		return fmt.Errorf("middle %d should be strictly between min %d and max %d", c.Middle, c.Min, c.Max)
+	}
+	if c.Deadband < 0 {
+		return fmt.Errorf("deadband %d can't be negative", c.Deadband)
+	}
+	low, high := c.neutral()
+	if low <= c.Min || high >= c.Max {
+		return fmt.Errorf("neutral range [%d, %d] (middle %d, trim %d, deadband %d) should be strictly between min %d and max %d",
+			low, high, c.Middle, c.Trim, c.Deadband, c.Min, c.Max)
+	}
+	return nil
+}
+
+// neutral returns bounds of pwm values converted to 0
+func (c *PWMConfig) neutral() (low, high int) {
+	center := c.Middle + c.Trim
+	return center - c.Deadband, center + c.Deadband
 }
 
 func NewPWMConfig(min, max int) *PWMConfig {
@@ -92,6 +207,13 @@ func WithMaxThrottleCtrl(throttleCtrl *PWMConfig) Option {
 	}
 }
 
+// WithThrottleFeedbackThresholds maps throttle feedback pwm values to percent values with thresholds
+func WithThrottleFeedbackThresholds(thresholds *tools.ThresholdConfig) Option {
+	return func(p *Part) {
+		p.throttleFeedbackThresholds = thresholds
+	}
+}
+
 func WithThrottleFeedbackConfig(filename string) Option {
 	return func(p *Part) {
 		if filename == "" {
@@ -109,14 +231,9 @@ func WithThrottleFeedbackConfig(filename string) Option {
 
 func NewPart(client mqtt.Client, name string, baud int, throttleTopic, steeringTopic, driveModeTopic,
 	switchRecordTopic, throttleFeedbackTopic, maxThrottleCtrlTopic string, pubFrequency float64, options ...Option) *Part {
-	c := &serial.Config{Name: name, Baud: baud}
-	s, err := serial.OpenPort(c)
-	if err != nil {
-		zap.S().Panicw("unable to open serial port: %v", err)
-	}
 	p := &Part{
 		client:                client,
-		serial:                s,
+		serialOpener:          NewSerialOpener(name, baud),
 		throttleTopic:         throttleTopic,
 		steeringTopic:         steeringTopic,
 		driveModeTopic:        driveModeTopic,
@@ -127,11 +244,22 @@ func NewPart(client mqtt.Client, name string, baud int, throttleTopic, steeringT
 		driveMode:             events.DriveMode_INVALID,
 		cancel:                make(chan interface{}),
 
+		reconnectMinBackoff: DefaultReconnectMinBackoff,
+		reconnectMaxBackoff: DefaultReconnectMaxBackoff,
+
 		pwmSteeringConfig:        &DefaultPwmThrottle,
 		pwmThrottleConfig:        &DefaultPwmThrottle,
 		pwmMaxThrottleCtrlConfig: &DefaultPwmThrottle,
 
+		pwmSecondarySteeringConfig: &DefaultPwmThrottle,
+		pwmSecondaryThrottleConfig: &DefaultPwmThrottle,
+		protocol:                   ProtocolAuto,
+		commandFrequency:           DefaultCommandFrequency,
+		overridePriority:           OverrideDisabled,
+		overrideThreshold:          DefaultOverrideThreshold,
+
 		throttleFeedbackThresholds: tools.NewThresholdConfig(),
+		publishTimeout:             DefaultPublishTimeout,
 	}
 
 	for _, o := range options {
@@ -142,59 +270,117 @@ func NewPart(client mqtt.Client, name string, baud int, throttleTopic, steeringT
 
 func (a *Part) Start() error {
 	zap.S().Info("start arduino part")
-	go a.publishLoop()
+	a.mutex.Lock()
+	a.lastLine = time.Now()
+	a.mutex.Unlock()
+	if err := a.startHTTP(); err != nil {
+		return err
+	}
+	a.loopsWG.Add(1)
+	go func() {
+		defer a.loopsWG.Done()
+		a.publishLoop()
+	}()
+	if a.clockTopic != "" {
+		a.loopsWG.Add(1)
+		go func() {
+			defer a.loopsWG.Done()
+			a.clockLoop()
+		}()
+	}
+	if a.signalTopic != "" {
+		a.loopsWG.Add(1)
+		go func() {
+			defer a.loopsWG.Done()
+			a.signalLoop()
+		}()
+	}
+	if a.client != nil {
+		if err := a.registerCallbacks(); err != nil {
+			return fmt.Errorf("unable to subscribe to mqtt topics: %w", err)
+		}
+	}
+	if a.autopilotEnabled() {
+		if a.commandFrequency <= 0 {
+			a.commandFrequency = DefaultCommandFrequency
+		}
+		a.loopsWG.Add(1)
+		go func() {
+			defer a.loopsWG.Done()
+			a.commandLoop()
+		}()
+	}
 	for {
-		buff := bufio.NewReader(a.serial)
-		line, err := buff.ReadString('\n')
-		if err == io.EOF || line == "" {
-			zap.S().Error("remote connection closed")
-			break
+		s, err := a.connect()
+		if err != nil {
+			if errors.Is(err, errPartStopped) {
+				return nil
+			}
+			return err
 		}
 
-		zap.L().Debug("raw line: %s", zap.String("raw", line))
-		if !serialLineRegex.MatchString(line) {
-			zap.S().Errorf("invalid line: '%v'", line)
-			continue
+		err = a.readFrames(s)
+		if a.stopped() {
+			return nil
+		}
+		zap.S().Errorf("remote connection closed: %v", err)
+		if a.serialOpener == nil {
+			a.setLinkState(LinkDown, err)
+			return nil
 		}
-		values := strings.Split(strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), ",")
+		a.disconnect(err)
+	}
+}
 
-		a.updateValues(values)
+func (a *Part) readFrames(r io.Reader) error {
+	fr, protocol, err := newFrameReader(r, a.protocol, &a.frameCounters, &a.invalidLines)
+	if err != nil {
+		return err
+	}
+	a.serialMutex.Lock()
+	a.activeProtocol = protocol
+	a.serialMutex.Unlock()
+	for {
+		f, err := fr.ReadFrame()
+		if err != nil {
+			return err
+		}
+		a.updateValues(f)
+		if a.eventPublish != nil {
+			a.publishChanged(time.Now())
+		}
 	}
-	return nil
 }
 
-func (a *Part) updateValues(values []string) {
+func (a *Part) updateValues(f *frame.Frame) {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
-	a.processChannel1(values[1])
-	a.processChannel2(values[2])
-	a.processChannel3(values[3])
-	a.processChannel4(values[4])
-	a.processChannel5(values[5])
-	a.processChannel6(values[6])
-	a.processChannel7(values[7])
-	a.processChannel8(values[8])
-	a.processChannel9(values[9])
+	now := time.Now()
+	a.decodeChannels(f, now)
+	a.recordChannels(f, now)
+	a.updateStats(f, now)
+	a.updateFrameRef(f, now)
 }
 
 func (a *Part) Stop() {
 	zap.S().Info("stop ArduinoPart")
+	a.unregisterCallbacks()
 	close(a.cancel)
+	a.serialMutex.Lock()
 	switch s := a.serial.(type) {
 	case io.ReadCloser:
 		if err := s.Close(); err != nil {
 			zap.S().Fatalf("unable to close serial port: %v", err)
 		}
 	}
+	a.serialMutex.Unlock()
+	a.stopHTTP()
+	a.loopsWG.Wait()
 }
 
-func (a *Part) processChannel1(v string) {
-	zap.L().Debug("process new value for steering on channel1", zap.String("value", v))
-	value, err := strconv.Atoi(v)
-	if err != nil {
-		zap.S().Errorf("invalid steering value for channel1, should be an int: %v", err)
-	}
-	a.steering = convertPwmToPercent(value, a.pwmSteeringConfig)
+func (a *Part) processSteering(value int) {
+	zap.L().Debug("process new value for steering", zap.Int("value", value))
+	a.steering = a.applyCurve(RoleSteering, convertPwmToPercent(value, a.pwmSteeringConfig))
 }
 
 func convertPwmToPercent(value int, c *PWMConfig) float32 {
@@ -203,147 +389,118 @@ func convertPwmToPercent(value int, c *PWMConfig) float32 {
 	} else if value > c.Max {
 		value = c.Max
 	}
-	if value == c.Middle {
-		return 0.
+	low, high := c.neutral()
+	var result float32
+	if value < low {
+		result = (float32(value) - float32(low)) / float32(low-c.Min)
+	} else if value > high {
+		//  high < value < max
+		result = (float32(value) - float32(high)) / float32(c.Max-high)
 	}
-	if value < c.Middle {
-		return (float32(value) - float32(c.Middle)) / float32(c.Middle-c.Min)
+	if c.Reverse {
+		return -result
 	}
-	//  middle < value < max
-	return (float32(value) - float32(c.Middle)) / float32(c.Max-c.Middle)
+	return result
 }
 
-func (a *Part) processChannel2(v string) {
-	zap.L().Debug("process new throttle value on channel2", zap.String("value", v))
-	value, err := strconv.Atoi(v)
-	if err != nil {
-		zap.S().Errorf("invalid throttle value for channel2, should be an int: %v", err)
-	}
+// Percent converts pwm value to percent value, between -1 and 1
+func (c *PWMConfig) Percent(value int) float32 {
+	return convertPwmToPercent(value, c)
+}
+
+func (a *Part) processThrottle(value int) {
+	zap.L().Debug("process new throttle value", zap.Int("value", value))
 	if value < a.pwmThrottleConfig.Min {
 		value = a.pwmThrottleConfig.Min
 	} else if value > a.pwmThrottleConfig.Max {
 		value = a.pwmThrottleConfig.Max
 	}
 
+	low, high := a.pwmThrottleConfig.neutral()
 	throttle := 0.
-	if value > a.pwmThrottleConfig.Middle {
-		throttle = (float64(value) - float64(a.pwmThrottleConfig.Middle)) / float64(a.pwmThrottleConfig.Max-a.pwmThrottleConfig.Middle)
+	if value > high {
+		throttle = (float64(value) - float64(high)) / float64(a.pwmThrottleConfig.Max-high)
 	}
-	if value < a.pwmThrottleConfig.Middle {
-		throttle = -1. * (float64(a.pwmThrottleConfig.Middle) - float64(value)) / (float64(a.pwmThrottleConfig.Middle - a.pwmThrottleConfig.Min))
+	if value < low {
+		throttle = -1. * (float64(low) - float64(value)) / (float64(low - a.pwmThrottleConfig.Min))
+	}
+	if a.pwmThrottleConfig.Reverse {
+		throttle = -throttle
 	}
 
-	a.throttle = float32(throttle)
+	a.throttle = a.applyCurve(RoleThrottle, float32(throttle))
 }
 
-func (a *Part) processChannel3(v string) {
-	zap.L().Debug("process new value for channel3", zap.String("value", v))
-
-	value, err := strconv.Atoi(v)
-	if err != nil {
-		zap.S().Errorf("invalid steering value for channel1, should be an int: %v", err)
-	}
-	a.maxThrottleCtrl = (convertPwmToPercent(value, a.pwmSteeringConfig) + 1) / 2
+func (a *Part) processMaxThrottleCtrl(value int) {
+	zap.L().Debug("process new value for max throttle ctrl", zap.Int("value", value))
+	a.maxThrottleCtrl = (convertPwmToPercent(value, a.pwmMaxThrottleCtrlConfig) + 1) / 2
 }
 
-func (a *Part) processChannel4(v string) {
-	zap.L().Debug("process new value for channel4", zap.String("value", v))
-	value, err := strconv.Atoi(v)
-	if err != nil {
-		zap.S().Errorf("invalid throttle value for channel2, should be an int: %v", err)
-	}
+func (a *Part) processThrottleFeedback(value int) {
+	zap.L().Debug("process new value for throttle feedback", zap.Int("value", value))
 	a.throttleFeedback = a.convertPwmFeedBackToPercent(value)
+	a.updateSpeed(value)
 }
 
-func (a *Part) processChannel5(v string) {
-	zap.L().Debug("process new value for channel5", zap.String("value", v))
-
-	value, err := strconv.Atoi(v)
-	if err != nil {
-		zap.S().Errorf("invalid value for channel5 'record', should be an int: %v", err)
-	}
-
-	if value < 1800 {
-		if !a.ctrlRecord {
-			zap.S().Infof("Update channel 5 with value %v, record: %v", true, false)
-			a.ctrlRecord = true
-		}
-	} else {
-		if a.ctrlRecord {
-			zap.S().Infof("Update channel 5 with value %v, record: %v", false, true)
-			a.ctrlRecord = false
-		}
-	}
+func (a *Part) Throttle() float32 {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.throttleValue()
 }
 
-func (a *Part) processChannel6(v string) {
-	zap.L().Debug("process new value for channel6", zap.String("value", v))
-	value, err := strconv.Atoi(v)
-	if err != nil {
-		zap.S().Errorf("invalid value for channel6 'drive-mode', should be an int: %v", err)
-		return
-	}
-	if value < 0 {
-		// No value, ignore it
-		return
-	}
-	if value <= 1800 && value > 1200 {
-		if a.driveMode != events.DriveMode_COPILOT {
-			zap.S().Infof("Update channel 6 'drive-mode' with value %v, new user_mode: %v", value, events.DriveMode_PILOT)
-			a.driveMode = events.DriveMode_COPILOT
-		}
-	} else if value > 1800 {
-		if a.driveMode != events.DriveMode_PILOT {
-			zap.S().Infof("Update channel 6 'drive-mode' with value %v, new user_mode: %v", value, events.DriveMode_PILOT)
-			a.driveMode = events.DriveMode_PILOT
-		}
-	} else {
-		if a.driveMode != events.DriveMode_USER {
-			zap.S().Infof("Update channel 6 'drive-mode' with value %v, new user_mode: %v", value, events.DriveMode_USER)
-		}
-		a.driveMode = events.DriveMode_USER
+// throttleValue returns throttle to publish, or neutral value when failsafe is engaged, mutex should be locked
+func (a *Part) throttleValue() float32 {
+	if a.failsafe {
+		return 0.
 	}
+	_, throttle := a.controls()
+	return throttle
 }
 
-func (a *Part) processChannel7(v string) {
-	zap.L().Debug("process new value for secondary steering on channel7", zap.String("value", v))
-}
-
-func (a *Part) processChannel8(v string) {
-	zap.L().Debug("process new throttle value on channel8", zap.String("value", v))
-}
-
-func (a *Part) processChannel9(v string) {
-	zap.L().Debug("process new value for channel9", zap.String("value", v))
-}
-
-func (a *Part) Throttle() float32 {
+func (a *Part) ThrottleFeedback() float32 {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
-	return a.throttle
+	return a.throttleFeedbackValue()
 }
 
-func (a *Part) ThrottleFeedback() float32 {
-	a.mutex.Lock()
-	defer a.mutex.Unlock()
+// throttleFeedbackValue returns last throttle feedback, mutex should be locked
+func (a *Part) throttleFeedbackValue() float32 {
 	return a.throttleFeedback
 }
 
 func (a *Part) MaxThrottleCtrl() float32 {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
+	return a.maxThrottleCtrlValue()
+}
+
+// maxThrottleCtrlValue returns last max throttle ctrl, mutex should be locked
+func (a *Part) maxThrottleCtrlValue() float32 {
 	return a.maxThrottleCtrl
 }
 
 func (a *Part) Steering() float32 {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
-	return a.steering
+	return a.steeringValue()
+}
+
+// steeringValue returns steering to publish, or neutral value when failsafe is engaged, mutex should be locked
+func (a *Part) steeringValue() float32 {
+	if a.failsafe {
+		return 0.
+	}
+	steering, _ := a.controls()
+	return steering
 }
 
+// DriveMode returns mode selected on remote control, or DriveMode_INVALID when failsafe is engaged
 func (a *Part) DriveMode() events.DriveMode {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
+	if a.failsafe {
+		return events.DriveMode_INVALID
+	}
 	return a.driveMode
 }
 
@@ -359,7 +516,14 @@ func (a *Part) publishLoop() {
 	for {
 		select {
 		case <-ticker.C:
-			a.publishValues()
+			now := time.Now()
+			a.updateFailsafe(now)
+			if a.eventPublish != nil {
+				a.publishChanged(now)
+			} else {
+				a.publishValues()
+			}
+			a.publishSpeed()
 		case <-a.cancel:
 			ticker.Stop()
 			return
@@ -367,18 +531,11 @@ func (a *Part) publishLoop() {
 	}
 }
 
-func (a *Part) publishValues() {
-	a.publishThrottle()
-	a.publishThrottleFeedback()
-	a.publishSteering()
-	a.publishDriveMode()
-	a.publishSwitchRecord()
-	a.publishMaxThrottleCtrl()
-}
-
 func (a *Part) publishThrottle() {
+	value, ref := a.valueWithFrameRef(a.throttleValue)
 	throttle := events.ThrottleMessage{
-		Throttle:   a.Throttle(),
+		Throttle:   value,
+		FrameRef:   ref,
 		Confidence: 1.0,
 	}
 	throttleMessage, err := proto.Marshal(&throttle)
@@ -386,13 +543,15 @@ func (a *Part) publishThrottle() {
 		zap.S().Errorf("unable to marshal protobuf throttle message: %v", err)
 		return
 	}
-	zap.L().Debug("throttle channel", zap.Float32("throttle", a.throttle))
-	publish(a.client, a.throttleTopic, throttleMessage)
+	zap.L().Debug("throttle channel", zap.Float32("throttle", throttle.Throttle))
+	a.publishMessage(a.throttleTopic, throttleMessage)
 }
 
 func (a *Part) publishSteering() {
+	value, ref := a.valueWithFrameRef(a.steeringValue)
 	steering := events.SteeringMessage{
-		Steering:   a.Steering(),
+		Steering:   value,
+		FrameRef:   ref,
 		Confidence: 1.0,
 	}
 	steeringMessage, err := proto.Marshal(&steering)
@@ -400,13 +559,15 @@ func (a *Part) publishSteering() {
 		zap.S().Errorf("unable to marshal protobuf steering message: %v", err)
 		return
 	}
-	zap.L().Debug("steering channel", zap.Float32("steering", a.steering))
-	publish(a.client, a.steeringTopic, steeringMessage)
+	zap.L().Debug("steering channel", zap.Float32("steering", steering.Steering))
+	a.publishMessage(a.steeringTopic, steeringMessage)
 }
 
 func (a *Part) publishThrottleFeedback() {
+	value, ref := a.valueWithFrameRef(a.throttleFeedbackValue)
 	tm := events.ThrottleMessage{
-		Throttle:   a.ThrottleFeedback(),
+		Throttle:   value,
+		FrameRef:   ref,
 		Confidence: 1.,
 	}
 	tfMessage, err := proto.Marshal(&tm)
@@ -414,12 +575,14 @@ func (a *Part) publishThrottleFeedback() {
 		zap.S().Errorf("unable to marshal protobuf throttleFeedback message: %v", err)
 		return
 	}
-	publish(a.client, a.throttleFeedbackTopic, tfMessage)
+	a.publishMessage(a.throttleFeedbackTopic, tfMessage)
 }
 
 func (a *Part) publishMaxThrottleCtrl() {
+	value, ref := a.valueWithFrameRef(a.maxThrottleCtrlValue)
 	tm := events.ThrottleMessage{
-		Throttle:   a.MaxThrottleCtrl(),
+		Throttle:   value,
+		FrameRef:   ref,
 		Confidence: 1.,
 	}
 	tfMessage, err := proto.Marshal(&tm)
@@ -427,9 +590,10 @@ func (a *Part) publishMaxThrottleCtrl() {
 		zap.S().Errorf("unable to marshal protobuf maxThrottleCtrl message: %v", err)
 		return
 	}
-	publish(a.client, a.maxThrottleCtrlTopic, tfMessage)
+	a.publishMessage(a.maxThrottleCtrlTopic, tfMessage)
 }
 
+// publishDriveMode publishes drive mode, DriveModeMessage and SwitchRecordMessage have no FrameRef field
 func (a *Part) publishDriveMode() {
 	dm := events.DriveModeMessage{
 		DriveMode: a.DriveMode(),
@@ -439,7 +603,7 @@ func (a *Part) publishDriveMode() {
 		zap.S().Errorf("unable to marshal protobuf driveMode message: %v", err)
 		return
 	}
-	publish(a.client, a.driveModeTopic, driveModeMessage)
+	a.publishMessage(a.driveModeTopic, driveModeMessage)
 }
 
 func (a *Part) publishSwitchRecord() {
@@ -451,13 +615,13 @@ func (a *Part) publishSwitchRecord() {
 		zap.S().Errorf("unable to marshal protobuf SwitchRecord message: %v", err)
 		return
 	}
-	publish(a.client, a.switchRecordTopic, switchRecordMessage)
+	a.publishMessage(a.switchRecordTopic, switchRecordMessage)
 }
 
 func (a *Part) convertPwmFeedBackToPercent(value int) float32 {
 	return float32(a.throttleFeedbackThresholds.ValueOf(value))
 }
 
-var publish = func(client mqtt.Client, topic string, payload []byte) {
-	client.Publish(topic, 0, false, payload)
+var publish = func(client mqtt.Client, topic string, qos byte, retain bool, payload []byte) mqtt.Token {
+	return client.Publish(topic, qos, retain, payload)
 }
@@ -3,6 +3,7 @@ package arduino
 import (
 	"bufio"
 	"fmt"
+	"github.com/cyrilix/robocar-arduino/pkg/simulator"
 	"github.com/cyrilix/robocar-arduino/pkg/tools"
 	"github.com/cyrilix/robocar-protobuf/go/events"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
@@ -27,44 +28,24 @@ func TestArduinoPart_Update(t *testing.T) {
 	oldPublish := publish
 	defer func() { publish = oldPublish }()
 
-	publish = func(client mqtt.Client, topic string, payload []byte) {}
+	publish = func(client mqtt.Client, topic string, qos byte, retain bool, payload []byte) mqtt.Token { return nil }
 
-	ln, err := net.Listen("tcp", ":8080")
-	if err != nil {
-		t.Fatalf("unable to init connection for test")
-	}
-	defer func() {
-		if err := ln.Close(); err != nil {
-			t.Errorf("unable to close resource: %v", err)
-		}
-	}()
-
-	serialClient, err := net.Dial("tcp", "localhost:8080")
-	if err != nil {
-		t.Fatalf("unable to init connection for test")
-	}
+	conn, serialClient := net.Pipe()
 	defer func() {
 		if err := serialClient.Close(); err != nil {
 			t.Errorf("unable to close resource: %v", err)
 		}
 	}()
 
-	conn, err := ln.Accept()
-	if err != nil {
-		t.Fatalf("unable to init connection for test")
-	}
-	defer func() {
-		if err := conn.Close(); err != nil {
-			t.Errorf("unable to close resource: %v", err)
-		}
-	}()
-
 	defaultPwmThrottleConfig := NewPWMConfig(MinPwmThrottle, MaxPwmThrottle)
 	a := Part{client: nil, serial: conn, pubFrequency: 100,
 		pwmSteeringConfig:          NewAsymetricPWMConfig(MinPwmAngle, MaxPwmAngle, MiddlePwmAngle),
 		pwmThrottleConfig:          &DefaultPwmThrottle,
-		pwmMaxThrottleCtrlConfig:   &DefaultPwmThrottle,
+		pwmMaxThrottleCtrlConfig:   NewAsymetricPWMConfig(MinPwmAngle, MaxPwmAngle, MiddlePwmAngle),
+		pwmSecondarySteeringConfig: &DefaultPwmThrottle,
+		pwmSecondaryThrottleConfig: &DefaultPwmThrottle,
 		throttleFeedbackThresholds: tools.NewThresholdConfig(),
+		cancel:                     make(chan interface{}),
 	}
 	go func() {
 		err := a.Start()
@@ -73,6 +54,8 @@ func TestArduinoPart_Update(t *testing.T) {
 			t.Fail()
 		}
 	}()
+	// Stop part and close serial connection
+	defer a.Stop()
 
 	channel1, channel2, channel3, channel4, channel5, channel6, channel7, channel8, channel9 := 678, 910, 1012, 1678, 1910, 112, 0, 0, 0
 	cases := []struct {
@@ -153,7 +136,7 @@ func TestArduinoPart_Update(t *testing.T) {
 			defaultPwmThrottleConfig, 1., -1., 0.01, events.DriveMode_USER, false},
 		{"Throttle: zero not middle",
 			fmt.Sprintf("12440,%d,%d,%d,%d,%d,%d,%d,%d,%d,50\n", channel1, 1600, channel3, channel4, channel5, channel6, channel7, channel8, channel9),
-			&PWMConfig{1000, 1700, 1500},
+			&PWMConfig{Min: 1000, Max: 1700, Middle: 1500},
 			0.5, -1., 0.01, events.DriveMode_USER, false},
 		{"MaxThrottleCtrl: Too low value",
 			fmt.Sprintf("12440,%d,%d,%d,%d,%d,%d,%d,%d,%d,50\n", channel1, channel2, 100, channel4, channel5, channel6, 2000, 2008, channel9),
@@ -180,6 +163,11 @@ func TestArduinoPart_Update(t *testing.T) {
 
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
+			a.mutex.Lock()
+			a.pwmThrottleConfig = c.throttlePwmConfig
+			a.driveMode = events.DriveMode_INVALID
+			a.mutex.Unlock()
+
 			w := bufio.NewWriter(serialClient)
 			_, err := w.WriteString(c.content)
 			if err != nil {
@@ -190,9 +178,6 @@ func TestArduinoPart_Update(t *testing.T) {
 				t.Error("unable to flush content")
 			}
 
-			a.pwmThrottleConfig = c.throttlePwmConfig
-			a.driveMode = events.DriveMode_INVALID
-
 			time.Sleep(10 * time.Millisecond)
 			a.mutex.Lock()
 			a.mutex.Unlock()
@@ -221,28 +206,15 @@ func TestPublish(t *testing.T) {
 
 	var muPublishedEvents sync.Mutex
 	pulishedEvents := make(map[string][]byte)
-	publish = func(client mqtt.Client, topic string, payload []byte) {
+	publish = func(client mqtt.Client, topic string, qos byte, retain bool, payload []byte) mqtt.Token {
 		muPublishedEvents.Lock()
 		defer muPublishedEvents.Unlock()
 		pulishedEvents[topic] = payload
+		return nil
 	}
 
-	ln, err := net.Listen("tcp", ":8080")
-	if err != nil {
-		t.Fatalf("unable to init connection for test")
-	}
-	defer ln.Close()
-
-	client, err := net.Dial("tcp", "localhost:8080")
-	if err != nil {
-		t.Fatalf("unable to init connection for test")
-	}
+	conn, client := net.Pipe()
 	defer client.Close()
-
-	conn, err := ln.Accept()
-	if err != nil {
-		t.Fatalf("unable to init connection for test")
-	}
 	defer conn.Close()
 
 	pubFrequency := 100.
@@ -300,7 +272,8 @@ func TestPublish(t *testing.T) {
 		},
 	}
 
-	for _, c := range cases {
+	for i := range cases {
+		c := &cases[i]
 		a.mutex.Lock()
 		a.throttle = c.throttle
 		a.steering = c.steering
@@ -533,6 +506,83 @@ func Test_convertPwmSteeringToPercent(t *testing.T) {
 	}
 }
 
+func Test_convertPwmToPercent_deadbandTrimReverse(t *testing.T) {
+	// Neutral range is [1490, 1530]
+	c := PWMConfig{Min: 1000, Max: 2000, Middle: 1500, Deadband: 20, Trim: 10}
+	reversed := c
+	reversed.Reverse = true
+	tests := []struct {
+		value int
+		want  float32
+	}{
+		{value: 900, want: -1.},
+		{value: 1000, want: -1.},
+		{value: 1245, want: -0.5},
+		{value: 1489, want: -1. / 490},
+		{value: 1490, want: 0.},
+		{value: 1500, want: 0.},
+		{value: 1530, want: 0.},
+		{value: 1531, want: 1. / 470},
+		{value: 1765, want: 0.5},
+		{value: 2000, want: 1.},
+		{value: 2100, want: 1.},
+	}
+	for _, tt := range tests {
+		if got := convertPwmToPercent(tt.value, &c); got != tt.want {
+			t.Errorf("convertPwmToPercent(%v) = %v, want %v", tt.value, got, tt.want)
+		}
+		if got := convertPwmToPercent(tt.value, &reversed); got != -tt.want {
+			t.Errorf("reversed convertPwmToPercent(%v) = %v, want %v", tt.value, got, -tt.want)
+		}
+	}
+}
+
+func TestPart_processThrottle_deadbandTrimReverse(t *testing.T) {
+	a := Part{pwmThrottleConfig: &PWMConfig{Min: 1000, Max: 2000, Middle: 1500, Deadband: 20, Trim: -10, Reverse: true}}
+	for value, want := range map[int]float32{1000: 1., 1235: 0.5, 1470: 0., 1490: 0., 1510: 0., 1755: -0.5, 2000: -1.} {
+		a.processThrottle(value)
+		if got := a.Throttle(); math.Abs(float64(got-want)) > 0.01 {
+			t.Errorf("processThrottle(%v): throttle = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestPart_processMaxThrottleCtrl_ownConfig(t *testing.T) {
+	a := Part{
+		pwmSteeringConfig:        &PWMConfig{Min: 1000, Max: 2000, Middle: 1500},
+		pwmMaxThrottleCtrlConfig: &PWMConfig{Min: 1000, Max: 2000, Middle: 1500, Deadband: 100, Reverse: true},
+	}
+	for value, want := range map[int]float32{1000: 1., 1450: 0.5, 1550: 0.5, 1800: 0.25, 2000: 0.} {
+		a.processMaxThrottleCtrl(value)
+		if got := a.MaxThrottleCtrl(); math.Abs(float64(got-want)) > 0.01 {
+			t.Errorf("processMaxThrottleCtrl(%v): max throttle ctrl = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestPWMConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  PWMConfig
+		wantErr bool
+	}{
+		{name: "default throttle", config: DefaultPwmThrottle},
+		{name: "deadband and trim", config: PWMConfig{Min: 1000, Max: 2000, Middle: 1500, Deadband: 50, Trim: -100}},
+		{name: "negative deadband", config: PWMConfig{Min: 1000, Max: 2000, Middle: 1500, Deadband: -1}, wantErr: true},
+		{name: "deadband too large", config: PWMConfig{Min: 1000, Max: 2000, Middle: 1500, Deadband: 500}, wantErr: true},
+		{name: "trim out of range", config: PWMConfig{Min: 1000, Max: 2000, Middle: 1500, Trim: 600}, wantErr: true},
+		{name: "middle out of range", config: PWMConfig{Min: 1000, Max: 2000, Middle: 2000}, wantErr: true},
+		{name: "min greater than max", config: PWMConfig{Min: 2000, Max: 1000, Middle: 1500}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestPart_convertPwmFeedBackToPercent(t *testing.T) {
 	type fields struct {
 	}
@@ -595,3 +645,35 @@ func TestPart_convertPwmFeedBackToPercent(t *testing.T) {
 		})
 	}
 }
+
+func TestPart_Simulator(t *testing.T) {
+	oldPublish := publish
+	defer func() { publish = oldPublish }()
+	publish = func(client mqtt.Client, topic string, qos byte, retain bool, payload []byte) mqtt.Token { return nil }
+
+	sim := simulator.New(simulator.Concat(
+		simulator.StickSweep(simulator.SteeringChannel, 200*time.Millisecond),
+		simulator.Set(simulator.SteeringChannel, simulator.PwmMax, 20*time.Millisecond),
+		simulator.DriveMode(events.DriveMode_COPILOT, 20*time.Millisecond),
+		simulator.RecordToggle(20*time.Millisecond),
+	), simulator.WithNoise(2, 1))
+
+	a := NewPart(nil, "", 0, "", "", "", "", "", "", 100,
+		WithSerial(sim),
+		WithSteeringConfig(NewAsymetricPWMConfig(simulator.PwmMin, simulator.PwmMax, simulator.PwmCenter)),
+	)
+	if err := a.Start(); err != nil {
+		t.Errorf("unable to start part: %v", err)
+	}
+	defer a.Stop()
+
+	if math.Abs(float64(a.Steering()-1.)) > 0.01 {
+		t.Errorf("bad steering, expected: %v, actual: %v", 1., a.Steering())
+	}
+	if a.DriveMode() != events.DriveMode_COPILOT {
+		t.Errorf("bad drive mode, expected: %v, actual: %v", events.DriveMode_COPILOT, a.DriveMode())
+	}
+	if !a.SwitchRecord() {
+		t.Errorf("bad switch record, expected: %v, actual: %v", true, a.SwitchRecord())
+	}
+}
@@ -0,0 +1,168 @@
+package arduino
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/cyrilix/robocar-arduino/pkg/frame"
+	"go.uber.org/zap"
+	"os"
+	"sort"
+	"time"
+)
+
+type ChannelRole string
+
+const (
+	RoleNone             ChannelRole = "none"
+	RoleSteering         ChannelRole = "steering"
+	RoleThrottle         ChannelRole = "throttle"
+	RoleMaxThrottleCtrl  ChannelRole = "max-throttle-ctrl"
+	RoleThrottleFeedback ChannelRole = "throttle-feedback"
+	RoleSwitchRecord     ChannelRole = "switch-record"
+	RoleDriveMode        ChannelRole = "drive-mode"
+
+	RoleSecondarySteering ChannelRole = "secondary-steering"
+	RoleSecondaryThrottle ChannelRole = "secondary-throttle"
+)
+
+type channelRole struct {
+	// decode updates part state from channel value, part mutex is locked by caller
+	decode  func(a *Part, value int)
+	publish func(a *Part)
+	// value returns published value, used to detect changes in event publish mode
+	value func(a *Part) float32
+	// discrete values are published on any change, whatever the epsilon
+	discrete bool
+}
+
+var (
+	channelRoles = map[ChannelRole]channelRole{
+		RoleSteering:         {decode: (*Part).processSteering, publish: (*Part).publishSteering, value: (*Part).Steering},
+		RoleThrottle:         {decode: (*Part).processThrottle, publish: (*Part).publishThrottle, value: (*Part).Throttle},
+		RoleMaxThrottleCtrl:  {decode: (*Part).processMaxThrottleCtrl, publish: (*Part).publishMaxThrottleCtrl, value: (*Part).MaxThrottleCtrl},
+		RoleThrottleFeedback: {decode: (*Part).processThrottleFeedback, publish: (*Part).publishThrottleFeedback, value: (*Part).ThrottleFeedback},
+		RoleSwitchRecord: {decode: (*Part).processSwitchRecord, publish: (*Part).publishSwitchRecord, discrete: true,
+			value: func(a *Part) float32 { return float32(boolToFloat(a.SwitchRecord())) }},
+		RoleDriveMode: {decode: (*Part).processDriveMode, publish: (*Part).publishDriveMode, discrete: true,
+			value: func(a *Part) float32 { return float32(a.DriveMode()) }},
+
+		RoleSecondarySteering: {decode: (*Part).processSecondarySteering, publish: (*Part).publishSecondarySteering, value: (*Part).SecondarySteering},
+		RoleSecondaryThrottle: {decode: (*Part).processSecondaryThrottle, publish: (*Part).publishSecondaryThrottle, value: (*Part).SecondaryThrottle},
+	}
+
+	// publishOrder is the order used to publish role values
+	publishOrder = []ChannelRole{RoleThrottle, RoleThrottleFeedback, RoleSteering, RoleDriveMode, RoleSwitchRecord, RoleMaxThrottleCtrl,
+		RoleSecondarySteering, RoleSecondaryThrottle}
+
+	DefaultChannelMapping = ChannelMapping{
+		1: RoleSteering,
+		2: RoleThrottle,
+		3: RoleMaxThrottleCtrl,
+		4: RoleThrottleFeedback,
+		5: RoleSwitchRecord,
+		6: RoleDriveMode,
+		7: RoleSecondarySteering,
+		8: RoleSecondaryThrottle,
+	}
+)
+
+// ChannelMapping associates arduino channel numbers (1 to 9) to their role
+type ChannelMapping map[int]ChannelRole
+
+func NewChannelMappingFromJson(fileName string) (ChannelMapping, error) {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read content from %s file: %w", fileName, err)
+	}
+	var m ChannelMapping
+	err = json.Unmarshal(content, &m)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unmarshal json content from %s file: %w", fileName, err)
+	}
+	if err := m.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid channel mapping in %s file: %w", fileName, err)
+	}
+	return m, nil
+}
+
+func (m ChannelMapping) Validate() error {
+	channels := make(map[ChannelRole]int, len(m))
+	for _, ch := range m.Channels() {
+		role := m[ch]
+		if ch < 1 || ch > frame.ChannelCount {
+			return fmt.Errorf("invalid channel %d for role %v, should be between 1 and %d", ch, role, frame.ChannelCount)
+		}
+		if role == RoleNone {
+			continue
+		}
+		if _, ok := channelRoles[role]; !ok {
+			return fmt.Errorf("unknown role '%v' for channel %d", role, ch)
+		}
+		if other, ok := channels[role]; ok {
+			return fmt.Errorf("role '%v' is mapped to channels %d and %d", role, other, ch)
+		}
+		channels[role] = ch
+	}
+	return nil
+}
+
+// Channels returns sorted channel numbers
+func (m ChannelMapping) Channels() []int {
+	channels := make([]int, 0, len(m))
+	for ch := range m {
+		channels = append(channels, ch)
+	}
+	sort.Ints(channels)
+	return channels
+}
+
+// Channel returns the channel number mapped to role
+func (m ChannelMapping) Channel(role ChannelRole) (int, bool) {
+	for ch, r := range m {
+		if r == role {
+			return ch, true
+		}
+	}
+	return 0, false
+}
+
+func WithChannelMapping(mapping ChannelMapping) Option {
+	return func(p *Part) {
+		p.channelMapping = mapping
+	}
+}
+
+func (a *Part) mapping() ChannelMapping {
+	if a.channelMapping == nil {
+		return DefaultChannelMapping
+	}
+	return a.channelMapping
+}
+
+// decodeChannels applies role decoder of each mapped channel, part mutex is locked by caller
+func (a *Part) decodeChannels(f *frame.Frame, now time.Time) {
+	a.decodeTime = now
+	m := a.mapping()
+	for _, ch := range m.Channels() {
+		role, ok := channelRoles[m[ch]]
+		if !ok {
+			continue
+		}
+		value, ok := f.Channel(ch)
+		if !ok {
+			zap.S().Errorf("invalid channel %d for role '%v'", ch, m[ch])
+			continue
+		}
+		role.decode(a, a.applyFilter(m[ch], value))
+	}
+}
+
+func (a *Part) publishValues() {
+	m := a.mapping()
+	for _, r := range publishOrder {
+		if _, ok := m.Channel(r); !ok {
+			continue
+		}
+		channelRoles[r].publish(a)
+	}
+}
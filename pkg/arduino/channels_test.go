@@ -0,0 +1,93 @@
+package arduino
+
+import (
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestNewChannelMappingFromJson(t *testing.T) {
+	got, err := NewChannelMappingFromJson("test_data/channels.json")
+	if err != nil {
+		t.Fatalf("NewChannelMappingFromJson() error = %v", err)
+	}
+	want := ChannelMapping{2: RoleSteering, 1: RoleThrottle, 5: RoleDriveMode, 6: RoleSwitchRecord, 7: RoleNone}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NewChannelMappingFromJson() got = %v, want %v", got, want)
+	}
+}
+
+func TestChannelMapping_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		mapping ChannelMapping
+		wantErr bool
+	}{
+		{name: "default", mapping: DefaultChannelMapping},
+		{name: "none roles", mapping: ChannelMapping{1: RoleSteering, 7: RoleNone, 8: RoleNone}},
+		{name: "duplicated role", mapping: ChannelMapping{1: RoleSteering, 7: RoleSteering}, wantErr: true},
+		{name: "unknown role", mapping: ChannelMapping{1: "unknown"}, wantErr: true},
+		{name: "channel too low", mapping: ChannelMapping{0: RoleSteering}, wantErr: true},
+		{name: "channel too high", mapping: ChannelMapping{10: RoleSteering}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.mapping.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPart_decodeChannels(t *testing.T) {
+	a := Part{
+		pwmSteeringConfig: NewAsymetricPWMConfig(MinPwmAngle, MaxPwmAngle, MiddlePwmAngle),
+		pwmThrottleConfig: &DefaultPwmThrottle,
+		channelMapping:    ChannelMapping{2: RoleSteering, 9: RoleThrottle, 5: RoleDriveMode},
+	}
+
+	a.updateValues(mustParseLine(t, "12345,999,1985,1500,1500,1900,1000,0,0,1954,50"))
+
+	if a.Steering() != 1. {
+		t.Errorf("bad steering value, expected: %v, actual: %v", 1., a.Steering())
+	}
+	if a.Throttle() != 1. {
+		t.Errorf("bad throttle value, expected: %v, actual: %v", 1., a.Throttle())
+	}
+	if a.DriveMode() != events.DriveMode_PILOT {
+		t.Errorf("bad drive mode, expected: %v, actual: %v", events.DriveMode_PILOT, a.DriveMode())
+	}
+	if a.MaxThrottleCtrl() != 0. {
+		t.Errorf("unmapped max throttle ctrl should not be updated, actual: %v", a.MaxThrottleCtrl())
+	}
+}
+
+func TestPart_publishValuesOfMappedRoles(t *testing.T) {
+	oldPublish := publish
+	defer func() { publish = oldPublish }()
+
+	var topics []string
+	publish = func(client mqtt.Client, topic string, qos byte, retain bool, payload []byte) mqtt.Token {
+		topics = append(topics, topic)
+		return nil
+	}
+
+	a := Part{
+		throttleTopic:         "throttle",
+		steeringTopic:         "steering",
+		driveModeTopic:        "drive_mode",
+		switchRecordTopic:     "switch_record",
+		throttleFeedbackTopic: "throttle_feedback",
+		maxThrottleCtrlTopic:  "max_throttle_ctrl",
+		channelMapping:        ChannelMapping{2: RoleSteering, 1: RoleThrottle, 5: RoleDriveMode, 7: RoleNone},
+	}
+	a.publishValues()
+
+	sort.Strings(topics)
+	want := []string{"drive_mode", "steering", "throttle"}
+	if !reflect.DeepEqual(topics, want) {
+		t.Errorf("publishValues() published on %v, want %v", topics, want)
+	}
+}
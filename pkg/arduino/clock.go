@@ -0,0 +1,109 @@
+package arduino
+
+import (
+	"encoding/json"
+	"github.com/cyrilix/robocar-arduino/pkg/clock"
+	"github.com/cyrilix/robocar-arduino/pkg/frame"
+	"go.uber.org/zap"
+	"time"
+)
+
+const DefaultClockInterval = time.Second
+
+type clockMessage struct {
+	Offset          time.Time `json:"offset"`
+	DriftPpm        float64   `json:"drift_ppm"`
+	Frames          uint64    `json:"frames"`
+	DroppedFrames   uint64    `json:"dropped_frames"`
+	Rollovers       int       `json:"rollovers"`
+	Resets          int       `json:"resets"`
+	IntervalMs      float64   `json:"interval_ms"`
+	JitterMs        float64   `json:"jitter_ms"`
+	LatencyJitterMs float64   `json:"latency_jitter_ms"`
+}
+
+// WithClockTopic sets mqtt topic where clock synchronization statistics are published
+func WithClockTopic(topic string) Option {
+	return func(p *Part) {
+		p.clockTopic = topic
+	}
+}
+
+// WithClockInterval sets delay between two clock synchronization messages
+func WithClockInterval(interval time.Duration) Option {
+	return func(p *Part) {
+		p.clockInterval = interval
+	}
+}
+
+// updateClock synchronizes arduino clock with frame timestamp and returns frame capture time on host clock. Must be
+// called with a.mutex locked.
+func (a *Part) updateClock(f *frame.Frame, now time.Time) time.Time {
+	if a.clockSync == nil {
+		a.clockSync = clock.NewSync()
+	}
+	resets, rollovers := a.clockSync.Resets(), a.clockSync.Rollovers()
+	createdAt := a.clockSync.Update(f.Timestamp, now)
+	if a.clockSync.Resets() > resets {
+		zap.S().Warnf("arduino reset detected, timestamp went back to %v", f.Timestamp)
+	}
+	if a.clockSync.Rollovers() > rollovers {
+		zap.S().Infof("arduino clock rollover")
+	}
+	return createdAt
+}
+
+// ClockStats returns synchronization statistics between arduino and host clocks
+func (a *Part) ClockStats() clock.Stats {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.clockSync == nil {
+		return clock.Stats{}
+	}
+	return a.clockSync.Stats()
+}
+
+func (a *Part) clockLoop() {
+	interval := a.clockInterval
+	if interval <= 0 {
+		interval = DefaultClockInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.publishClock()
+		case <-a.cancel:
+			return
+		}
+	}
+}
+
+func (a *Part) publishClock() {
+	if a.clockTopic == "" {
+		return
+	}
+	s := a.ClockStats()
+	msg := clockMessage{
+		Offset:          s.Offset,
+		DriftPpm:        s.Drift,
+		Frames:          s.Frames,
+		DroppedFrames:   s.DroppedFrames,
+		Rollovers:       s.Rollovers,
+		Resets:          s.Resets,
+		IntervalMs:      durationToMillis(s.Interval),
+		JitterMs:        durationToMillis(s.Jitter),
+		LatencyJitterMs: durationToMillis(s.LatencyJitter),
+	}
+	payload, err := json.Marshal(&msg)
+	if err != nil {
+		zap.S().Errorf("unable to marshal clock message: %v", err)
+		return
+	}
+	a.publishMessage(a.clockTopic, payload)
+}
+
+func durationToMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,60 @@
+package arduino
+
+import (
+	"encoding/json"
+	"github.com/cyrilix/robocar-arduino/pkg/frame"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"testing"
+	"time"
+)
+
+func TestPart_publishClock(t *testing.T) {
+	oldPublish := publish
+	defer func() { publish = oldPublish }()
+	published := make(map[string][]byte)
+	publish = func(client mqtt.Client, topic string, qos byte, retain bool, payload []byte) mqtt.Token {
+		published[topic] = payload
+		return nil
+	}
+
+	a := Part{clockTopic: "clock"}
+	a.publishClock()
+	var msg clockMessage
+	if err := json.Unmarshal(published["clock"], &msg); err != nil {
+		t.Fatalf("unable to unmarshal clock message: %v", err)
+	}
+	if msg.Frames != 0 {
+		t.Errorf("no frame expected before first frame: %+v", msg)
+	}
+
+	start := time.Now()
+	var ts uint32 = 10000
+	for i := 0; i < 20; i++ {
+		if i == 10 {
+			// one frame lost
+			ts += 20
+		}
+		a.mutex.Lock()
+		a.updateClock(&frame.Frame{Timestamp: ts}, start.Add(time.Duration(ts)*time.Millisecond))
+		a.mutex.Unlock()
+		ts += 20
+	}
+	a.mutex.Lock()
+	a.updateClock(&frame.Frame{Timestamp: 42}, start.Add(time.Minute))
+	a.mutex.Unlock()
+
+	a.publishClock()
+	if err := json.Unmarshal(published["clock"], &msg); err != nil {
+		t.Fatalf("unable to unmarshal clock message: %v", err)
+	}
+	if msg.Frames != 21 || msg.DroppedFrames != 1 || msg.Resets != 1 || msg.Rollovers != 0 {
+		t.Errorf("bad clock message: %+v", msg)
+	}
+	// Offset is arduino boot time since reset
+	if want := start.Add(time.Minute - 42*time.Millisecond); !msg.Offset.Equal(want) {
+		t.Errorf("bad offset, expected: %v, actual: %v", want, msg.Offset)
+	}
+	if a.ClockStats().Resets != 1 {
+		t.Errorf("bad ClockStats(): %+v", a.ClockStats())
+	}
+}
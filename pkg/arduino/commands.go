@@ -0,0 +1,206 @@
+package arduino
+
+import (
+	"fmt"
+	"github.com/cyrilix/robocar-arduino/pkg/frame"
+	"github.com/cyrilix/robocar-base/service"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"math"
+	"time"
+)
+
+const DefaultCommandFrequency = 50.
+
+// WithAutopilotTopics configures topics where autopilot steering and throttle are read to drive arduino outputs
+func WithAutopilotTopics(steeringTopic, throttleTopic string) Option {
+	return func(p *Part) {
+		p.autopilotSteeringTopic = steeringTopic
+		p.autopilotThrottleTopic = throttleTopic
+	}
+}
+
+// WithCommandFrequency sets the max number of commands written to arduino per second
+func WithCommandFrequency(frequency float64) Option {
+	return func(p *Part) {
+		p.commandFrequency = frequency
+	}
+}
+
+func (a *Part) autopilotEnabled() bool {
+	return a.autopilotSteeringTopic != "" || a.autopilotThrottleTopic != ""
+}
+
+func (a *Part) registerCallbacks() error {
+	if a.autopilotSteeringTopic != "" {
+		if err := service.RegisterCallback(a.client, a.autopilotSteeringTopic, a.onAutopilotSteering); err != nil {
+			return err
+		}
+	}
+	if a.autopilotThrottleTopic != "" {
+		if err := service.RegisterCallback(a.client, a.autopilotThrottleTopic, a.onAutopilotThrottle); err != nil {
+			return err
+		}
+	}
+	if a.configTopic != "" {
+		if err := service.RegisterCallback(a.client, a.configTopic, a.onConfig); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Part) unregisterCallbacks() {
+	var topics []string
+	for _, t := range []string{a.autopilotSteeringTopic, a.autopilotThrottleTopic, a.configTopic} {
+		if t != "" {
+			topics = append(topics, t)
+		}
+	}
+	if len(topics) == 0 || a.client == nil {
+		return
+	}
+	token := a.client.Unsubscribe(topics...)
+	token.Wait()
+	if token.Error() != nil {
+		zap.S().Errorf("unable to unsubscribe topics: %v", token.Error())
+	}
+}
+
+func (a *Part) onAutopilotSteering(_ mqtt.Client, message mqtt.Message) {
+	var msg events.SteeringMessage
+	if err := proto.Unmarshal(message.Payload(), &msg); err != nil {
+		zap.S().Errorf("unable to unmarshal protobuf %T message: %v", &msg, err)
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.autopilotSteering = msg.GetSteering()
+	a.autopilotSteeringReceived = true
+	a.commandPending = true
+}
+
+func (a *Part) onAutopilotThrottle(_ mqtt.Client, message mqtt.Message) {
+	var msg events.ThrottleMessage
+	if err := proto.Unmarshal(message.Payload(), &msg); err != nil {
+		zap.S().Errorf("unable to unmarshal protobuf %T message: %v", &msg, err)
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.autopilotThrottle = msg.GetThrottle()
+	a.autopilotThrottleReceived = true
+	a.commandPending = true
+}
+
+// commandLoop writes latest autopilot values to arduino, at most commandFrequency times per second
+func (a *Part) commandLoop() {
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / a.commandFrequency))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			cmd, ok := a.nextCommand()
+			if !ok {
+				continue
+			}
+			if err := a.writeCommand(cmd); err != nil {
+				zap.S().Warnf("unable to write command to arduino: %v", err)
+				a.retryNeutralCommand()
+			}
+		case <-a.cancel:
+			return
+		}
+	}
+}
+
+// nextCommand returns command to write if new autopilot values are available and current drive mode allows it.
+// In PILOT mode, steering and throttle are driven by autopilot. In COPILOT mode, only steering is driven by
+// autopilot, throttle stays under user control. When failsafe engages, a single neutral command is returned so
+// arduino doesn't keep applying last autopilot values.
+func (a *Part) nextCommand() (*frame.Command, bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.failsafe {
+		if !a.neutralCommandPending {
+			return nil, false
+		}
+		a.neutralCommandPending = false
+		return &frame.Command{
+			Steering: convertPercentToPwm(0, a.pwmSteeringConfig),
+			Throttle: convertPercentToPwm(0, a.pwmThrottleConfig),
+		}, true
+	}
+	if !a.commandPending {
+		return nil, false
+	}
+
+	steering, throttle := a.controls()
+	switch a.driveMode {
+	case events.DriveMode_PILOT:
+		if a.autopilotThrottleReceived {
+			throttle = a.autopilotThrottle
+		}
+	case events.DriveMode_COPILOT:
+	default:
+		return nil, false
+	}
+	if a.autopilotSteeringReceived {
+		steering = a.autopilotSteering
+	}
+	a.commandPending = false
+	return &frame.Command{
+		Steering: convertPercentToPwm(steering, a.pwmSteeringConfig),
+		Throttle: convertPercentToPwm(throttle, a.pwmThrottleConfig),
+	}, true
+}
+
+// retryNeutralCommand schedules a new neutral command after a write error while failsafe is engaged
+func (a *Part) retryNeutralCommand() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.neutralCommandPending = a.failsafe
+}
+
+// writeCommand writes command on serial port, serial mutex prevents write while port is reopened or closed
+func (a *Part) writeCommand(cmd *frame.Command) error {
+	a.serialMutex.Lock()
+	defer a.serialMutex.Unlock()
+
+	w, ok := a.serial.(io.Writer)
+	if !ok {
+		return fmt.Errorf("serial link is not available for writing")
+	}
+	var payload []byte
+	if a.activeProtocol == ProtocolBinary {
+		payload = frame.MarshalCommand(cmd)
+	} else {
+		payload = []byte(fmt.Sprintf("%d,%d\n", cmd.Steering, cmd.Throttle))
+	}
+	zap.L().Debug("write command", zap.Int("steering", cmd.Steering), zap.Int("throttle", cmd.Throttle))
+	_, err := w.Write(payload)
+	return err
+}
+
+// convertPercentToPwm is the inverse of convertPwmToPercent, 0 is converted to neutral pwm value (middle + trim)
+func convertPercentToPwm(value float32, c *PWMConfig) int {
+	if value < -1. {
+		value = -1.
+	} else if value > 1. {
+		value = 1.
+	}
+	if c.Reverse {
+		value = -value
+	}
+	low, high := c.neutral()
+	if value < 0 {
+		return low + int(math.Round(float64(value)*float64(low-c.Min)))
+	}
+	if value > 0 {
+		return high + int(math.Round(float64(value)*float64(c.Max-high)))
+	}
+	return c.Middle + c.Trim
+}
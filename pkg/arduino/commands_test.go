@@ -0,0 +1,236 @@
+package arduino
+
+import (
+	"bufio"
+	"github.com/cyrilix/robocar-arduino/pkg/frame"
+	"github.com/cyrilix/robocar-arduino/pkg/tools"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"google.golang.org/protobuf/proto"
+	"net"
+	"testing"
+	"time"
+)
+
+type fakeMessage struct {
+	topic   string
+	payload []byte
+}
+
+func (f *fakeMessage) Duplicate() bool   { return false }
+func (f *fakeMessage) Qos() byte         { return 0 }
+func (f *fakeMessage) Retained() bool    { return false }
+func (f *fakeMessage) Topic() string     { return f.topic }
+func (f *fakeMessage) MessageID() uint16 { return 0 }
+func (f *fakeMessage) Payload() []byte   { return f.payload }
+func (f *fakeMessage) Ack()              {}
+
+func newFakeMessage(t *testing.T, topic string, msg proto.Message) mqtt.Message {
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatalf("unable to marshal %T: %v", msg, err)
+	}
+	return &fakeMessage{topic: topic, payload: payload}
+}
+
+func Test_convertPercentToPwm(t *testing.T) {
+	c := NewAsymetricPWMConfig(1000, 2000, 1400)
+	tests := []struct {
+		value float32
+		want  int
+	}{
+		{value: -2., want: 1000},
+		{value: -1., want: 1000},
+		{value: -0.5, want: 1200},
+		{value: 0., want: 1400},
+		{value: 0.5, want: 1700},
+		{value: 1., want: 2000},
+		{value: 2., want: 2000},
+	}
+	for _, tt := range tests {
+		if got := convertPercentToPwm(tt.value, c); got != tt.want {
+			t.Errorf("convertPercentToPwm(%v) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+	for pwm := c.Min; pwm <= c.Max; pwm++ {
+		if got := convertPercentToPwm(convertPwmToPercent(pwm, c), c); got != pwm {
+			t.Errorf("convertPercentToPwm(convertPwmToPercent(%v)) = %v", pwm, got)
+		}
+	}
+}
+
+func Test_convertPercentToPwm_deadbandTrimReverse(t *testing.T) {
+	// Neutral range is [1490, 1530]
+	c := &PWMConfig{Min: 1000, Max: 2000, Middle: 1500, Deadband: 20, Trim: 10, Reverse: true}
+	for value, want := range map[float32]int{-1.: 2000, -0.5: 1765, 0.: 1510, 0.5: 1245, 1.: 1000} {
+		if got := convertPercentToPwm(value, c); got != want {
+			t.Errorf("convertPercentToPwm(%v) = %v, want %v", value, got, want)
+		}
+	}
+	for pwm := c.Min; pwm <= c.Max; pwm++ {
+		want := pwm
+		if pwm >= 1490 && pwm <= 1530 {
+			want = 1510
+		}
+		if got := convertPercentToPwm(convertPwmToPercent(pwm, c), c); got != want {
+			t.Errorf("convertPercentToPwm(convertPwmToPercent(%v)) = %v, want %v", pwm, got, want)
+		}
+	}
+}
+
+func TestPart_Commands(t *testing.T) {
+	oldPublish := publish
+	defer func() { publish = oldPublish }()
+	publish = func(client mqtt.Client, topic string, qos byte, retain bool, payload []byte) mqtt.Token { return nil }
+
+	server, client := net.Pipe()
+	defer client.Close()
+	a := Part{
+		serial:                     server,
+		pubFrequency:               100,
+		cancel:                     make(chan interface{}),
+		pwmSteeringConfig:          NewAsymetricPWMConfig(MinPwmAngle, MaxPwmAngle, MiddlePwmAngle),
+		pwmThrottleConfig:          &DefaultPwmThrottle,
+		pwmMaxThrottleCtrlConfig:   &DefaultPwmThrottle,
+		pwmSecondarySteeringConfig: &DefaultPwmThrottle,
+		pwmSecondaryThrottleConfig: &DefaultPwmThrottle,
+		throttleFeedbackThresholds: tools.NewThresholdConfig(),
+		autopilotSteeringTopic:     "car/part/autopilot/steering",
+		autopilotThrottleTopic:     "car/part/autopilot/throttle",
+		commandFrequency:           200,
+	}
+	go func() {
+		if err := a.Start(); err != nil {
+			t.Errorf("unable to start part: %v", err)
+		}
+	}()
+	defer a.Stop()
+
+	reader := bufio.NewReader(client)
+	readCommand := func() string {
+		if err := client.SetReadDeadline(time.Now().Add(100 * time.Millisecond)); err != nil {
+			t.Fatalf("unable to set read deadline: %v", err)
+		}
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return ""
+		}
+		return line
+	}
+	sendLine := func(line string, driveMode events.DriveMode) {
+		if _, err := client.Write([]byte(line)); err != nil {
+			t.Fatalf("unable to write line: %v", err)
+		}
+		waitFor(t, "drive mode "+driveMode.String(), func() bool { return a.DriveMode() == driveMode })
+	}
+	sendAutopilot := func(steering, throttle float32) {
+		a.onAutopilotSteering(nil, newFakeMessage(t, a.autopilotSteeringTopic, &events.SteeringMessage{Steering: steering, Confidence: 1.}))
+		a.onAutopilotThrottle(nil, newFakeMessage(t, a.autopilotThrottleTopic, &events.ThrottleMessage{Throttle: throttle, Confidence: 1.}))
+	}
+
+	sendLine("12345,1492,1954,1500,1500,1900,1000,0,0,0,50\n", events.DriveMode_USER)
+	sendAutopilot(1., -1.)
+	if cmd := readCommand(); cmd != "" {
+		t.Errorf("no command should be written in user mode: %v", cmd)
+	}
+
+	sendLine("12350,1492,1954,1500,1500,1900,1900,0,0,0,50\n", events.DriveMode_PILOT)
+	sendAutopilot(1., -1.)
+	if cmd := readCommand(); cmd != "1985,972\n" {
+		t.Errorf("bad pilot command: '%v'", cmd)
+	}
+	if cmd := readCommand(); cmd != "" {
+		t.Errorf("command should be written only on new autopilot values: '%v'", cmd)
+	}
+
+	sendLine("12355,1492,1954,1500,1500,1900,1500,0,0,0,50\n", events.DriveMode_COPILOT)
+	sendAutopilot(-1., -1.)
+	if cmd := readCommand(); cmd != "999,1954\n" {
+		t.Errorf("bad copilot command: '%v'", cmd)
+	}
+}
+
+func TestPart_CommandsFailsafe(t *testing.T) {
+	oldPublish := publish
+	defer func() { publish = oldPublish }()
+	publish = func(client mqtt.Client, topic string, qos byte, retain bool, payload []byte) mqtt.Token { return nil }
+
+	server, client := net.Pipe()
+	defer client.Close()
+	a := Part{
+		serial:                     server,
+		pubFrequency:               100,
+		cancel:                     make(chan interface{}),
+		pwmSteeringConfig:          NewAsymetricPWMConfig(MinPwmAngle, MaxPwmAngle, MiddlePwmAngle),
+		pwmThrottleConfig:          &DefaultPwmThrottle,
+		pwmMaxThrottleCtrlConfig:   &DefaultPwmThrottle,
+		pwmSecondarySteeringConfig: &DefaultPwmThrottle,
+		pwmSecondaryThrottleConfig: &DefaultPwmThrottle,
+		throttleFeedbackThresholds: tools.NewThresholdConfig(),
+		autopilotSteeringTopic:     "car/part/autopilot/steering",
+		autopilotThrottleTopic:     "car/part/autopilot/throttle",
+		commandFrequency:           200,
+		failsafeConfig:             &FailsafeConfig{LineTimeout: 200 * time.Millisecond},
+	}
+	go func() {
+		if err := a.Start(); err != nil {
+			t.Errorf("unable to start part: %v", err)
+		}
+	}()
+	defer a.Stop()
+
+	reader := bufio.NewReader(client)
+	readCommand := func() string {
+		if err := client.SetReadDeadline(time.Now().Add(100 * time.Millisecond)); err != nil {
+			t.Fatalf("unable to set read deadline: %v", err)
+		}
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return ""
+		}
+		return line
+	}
+	sendAutopilot := func(steering, throttle float32) {
+		a.onAutopilotSteering(nil, newFakeMessage(t, a.autopilotSteeringTopic, &events.SteeringMessage{Steering: steering, Confidence: 1.}))
+		a.onAutopilotThrottle(nil, newFakeMessage(t, a.autopilotThrottleTopic, &events.ThrottleMessage{Throttle: throttle, Confidence: 1.}))
+	}
+
+	if _, err := client.Write([]byte("12350,1492,1954,1500,1500,1900,1900,0,0,0,50\n")); err != nil {
+		t.Fatalf("unable to write line: %v", err)
+	}
+	waitFor(t, "drive mode PILOT", func() bool { return a.DriveMode() == events.DriveMode_PILOT })
+	sendAutopilot(1., 1.)
+	if cmd := readCommand(); cmd != "1985,1954\n" {
+		t.Errorf("bad pilot command: '%v'", cmd)
+	}
+
+	waitFor(t, "failsafe engaged", a.Failsafe)
+	if cmd := readCommand(); cmd != "1492,1463\n" {
+		t.Errorf("neutral command should be written when failsafe engages: '%v'", cmd)
+	}
+	sendAutopilot(1., 1.)
+	if cmd := readCommand(); cmd != "" {
+		t.Errorf("no command should be written while failsafe is engaged: '%v'", cmd)
+	}
+}
+
+func TestPart_writeBinaryCommand(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	a := Part{serial: server, activeProtocol: ProtocolBinary}
+
+	cmd := frame.Command{Steering: 1500, Throttle: 1200}
+	go func() {
+		if err := a.writeCommand(&cmd); err != nil {
+			t.Errorf("writeCommand() error = %v", err)
+		}
+	}()
+	buf := make([]byte, frame.CommandSize)
+	if _, err := client.Read(buf); err != nil {
+		t.Fatalf("unable to read command: %v", err)
+	}
+	if string(buf) != string(frame.MarshalCommand(&cmd)) {
+		t.Errorf("bad binary command: %x", buf)
+	}
+}
@@ -0,0 +1,62 @@
+package arduino
+
+import (
+	"fmt"
+	"github.com/cyrilix/robocar-arduino/pkg/curve"
+)
+
+// curveRoles are the roles with a configurable response curve
+var curveRoles = []ChannelRole{RoleSteering, RoleThrottle, RoleSecondarySteering, RoleSecondaryThrottle}
+
+// WithCurve applies a response curve on percent values of role (steering, throttle, secondary-steering or
+// secondary-throttle)
+func WithCurve(role ChannelRole, c curve.Curve) Option {
+	return func(p *Part) {
+		if p.curves == nil {
+			p.curves = make(map[ChannelRole]curve.Curve, len(curveRoles))
+		}
+		p.curves[role] = c
+	}
+}
+
+// WithCurves applies response curves by role
+func WithCurves(curves map[ChannelRole]curve.Curve) Option {
+	return func(p *Part) {
+		p.curves = curves
+	}
+}
+
+// NewCurves builds response curves from configs by role name
+func NewCurves(configs map[string]curve.Config) (map[ChannelRole]curve.Curve, error) {
+	curves := make(map[ChannelRole]curve.Curve, len(configs))
+	for name, c := range configs {
+		role := ChannelRole(name)
+		if !hasCurve(role) {
+			return nil, fmt.Errorf("no response curve for role '%v', should be one of %v", name, curveRoles)
+		}
+		cv, err := c.Curve()
+		if err != nil {
+			return nil, fmt.Errorf("invalid curve for role '%v': %w", name, err)
+		}
+		curves[role] = cv
+	}
+	return curves, nil
+}
+
+func hasCurve(role ChannelRole) bool {
+	for _, r := range curveRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// applyCurve applies response curve of role on value, part mutex is locked by caller
+func (a *Part) applyCurve(role ChannelRole, value float32) float32 {
+	c, ok := a.curves[role]
+	if !ok {
+		return value
+	}
+	return float32(c.Apply(float64(value)))
+}
@@ -0,0 +1,56 @@
+package arduino
+
+import (
+	"github.com/cyrilix/robocar-arduino/pkg/curve"
+	"testing"
+)
+
+func TestNewCurves(t *testing.T) {
+	curves, err := NewCurves(map[string]curve.Config{
+		"steering": {Type: curve.TypeExpo, Expo: 0.5},
+		"throttle": {Type: curve.TypeLinear, Rate: 0.5},
+	})
+	if err != nil {
+		t.Fatalf("NewCurves() error = %v", err)
+	}
+	if len(curves) != 2 || curves[RoleSteering] == nil || curves[RoleThrottle] == nil {
+		t.Errorf("bad curves: %v", curves)
+	}
+
+	if _, err := NewCurves(map[string]curve.Config{"drive-mode": {Type: curve.TypeLinear}}); err == nil {
+		t.Errorf("NewCurves() should reject role without curve")
+	}
+	if _, err := NewCurves(map[string]curve.Config{"steering": {Type: curve.TypeExpo, Expo: 2}}); err == nil {
+		t.Errorf("NewCurves() should reject invalid curve")
+	}
+}
+
+func TestPart_applyCurve(t *testing.T) {
+	a := Part{
+		pwmSteeringConfig: NewPWMConfig(1000, 2000),
+		pwmThrottleConfig: NewPWMConfig(1000, 2000),
+		channelMapping:    ChannelMapping{1: RoleSteering, 2: RoleThrottle},
+	}
+	WithCurve(RoleSteering, curve.Expo{Expo: 0.5})(&a)
+	WithCurve(RoleThrottle, curve.DualRate{Rate: 0.5, Curve: curve.Linear{}})(&a)
+
+	tests := []struct {
+		line                       string
+		wantSteering, wantThrottle float32
+	}{
+		{line: "12345,1500,1500,1500,1500,1500,1500,0,0,0,50", wantSteering: 0, wantThrottle: 0},
+		{line: "12345,1750,1750,1500,1500,1500,1500,0,0,0,50", wantSteering: 0.3125, wantThrottle: 0.25},
+		{line: "12345,1250,1250,1500,1500,1500,1500,0,0,0,50", wantSteering: -0.3125, wantThrottle: -0.25},
+		{line: "12345,2000,2000,1500,1500,1500,1500,0,0,0,50", wantSteering: 1, wantThrottle: 0.5},
+		{line: "12345,1000,1000,1500,1500,1500,1500,0,0,0,50", wantSteering: -1, wantThrottle: -0.5},
+	}
+	for _, tt := range tests {
+		a.updateValues(mustParseLine(t, tt.line))
+		if a.Steering() != tt.wantSteering {
+			t.Errorf("bad steering for line %v, expected: %v, actual: %v", tt.line, tt.wantSteering, a.Steering())
+		}
+		if a.Throttle() != tt.wantThrottle {
+			t.Errorf("bad throttle for line %v, expected: %v, actual: %v", tt.line, tt.wantThrottle, a.Throttle())
+		}
+	}
+}
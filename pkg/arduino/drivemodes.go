@@ -0,0 +1,127 @@
+package arduino
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DriveModeRange associates a range of pwm values of drive mode switch, bounds included, to a drive mode
+type DriveModeRange struct {
+	Min  int
+	Max  int
+	Mode events.DriveMode
+}
+
+type driveModeRangeJson struct {
+	Min  int             `json:"min"`
+	Max  int             `json:"max"`
+	Mode json.RawMessage `json:"mode"`
+}
+
+// UnmarshalJSON reads drive mode as name ("PILOT") or numeric value (2)
+func (r *DriveModeRange) UnmarshalJSON(data []byte) error {
+	var v driveModeRangeJson
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	mode, err := parseDriveMode(string(v.Mode))
+	if err != nil {
+		return err
+	}
+	*r = DriveModeRange{Min: v.Min, Max: v.Max, Mode: mode}
+	return nil
+}
+
+func (r DriveModeRange) MarshalJSON() ([]byte, error) {
+	mode, _ := json.Marshal(r.Mode.String())
+	return json.Marshal(driveModeRangeJson{Min: r.Min, Max: r.Max, Mode: mode})
+}
+
+func parseDriveMode(s string) (events.DriveMode, error) {
+	s = strings.Trim(strings.TrimSpace(s), `"`)
+	if v, ok := events.DriveMode_value[strings.ToUpper(s)]; ok {
+		return events.DriveMode(v), nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return events.DriveMode_INVALID, fmt.Errorf("unknown drive mode '%v'", s)
+	}
+	if _, ok := events.DriveMode_name[int32(v)]; !ok {
+		return events.DriveMode_INVALID, fmt.Errorf("unknown drive mode %d", v)
+	}
+	return events.DriveMode(v), nil
+}
+
+// DriveModeTable lists drive mode switch positions, sorted by pwm values. Values under first range select first
+// position, values over last range select last position.
+type DriveModeTable []DriveModeRange
+
+// DefaultDriveModeTable is the 3 positions switch: USER up to 1200, COPILOT up to 1800, then PILOT
+var DefaultDriveModeTable = NewDriveModeTable(1201, 1801)
+
+// NewDriveModeTable creates a 3 positions table with USER, COPILOT and PILOT modes
+func NewDriveModeTable(copilotMin, pilotMin int) DriveModeTable {
+	return DriveModeTable{
+		{Min: 0, Max: copilotMin - 1, Mode: events.DriveMode_USER},
+		{Min: copilotMin, Max: pilotMin - 1, Mode: events.DriveMode_COPILOT},
+		{Min: pilotMin, Max: 3000, Mode: events.DriveMode_PILOT},
+	}
+}
+
+func NewDriveModeTableFromJson(fileName string) (DriveModeTable, error) {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read content from %s file: %w", fileName, err)
+	}
+	var t DriveModeTable
+	err = json.Unmarshal(content, &t)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unmarshal json content from %s file: %w", fileName, err)
+	}
+	if err := t.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid drive mode table in %s file: %w", fileName, err)
+	}
+	return t, nil
+}
+
+// Validate checks ranges are sorted and contiguous, without overlap nor gap
+func (t DriveModeTable) Validate() error {
+	if len(t) == 0 {
+		return fmt.Errorf("drive mode table is empty")
+	}
+	for i, r := range t {
+		if r.Min > r.Max {
+			return fmt.Errorf("invalid range [%d, %d] for mode %v", r.Min, r.Max, r.Mode)
+		}
+		if _, ok := events.DriveMode_name[int32(r.Mode)]; !ok {
+			return fmt.Errorf("unknown drive mode %d for range [%d, %d]", r.Mode, r.Min, r.Max)
+		}
+		if i == 0 {
+			continue
+		}
+		prev := t[i-1]
+		switch {
+		case r.Min <= prev.Max:
+			return fmt.Errorf("range [%d, %d] for mode %v overlaps range [%d, %d] for mode %v", r.Min, r.Max, r.Mode, prev.Min, prev.Max, prev.Mode)
+		case r.Min > prev.Max+1:
+			return fmt.Errorf("gap between range [%d, %d] for mode %v and range [%d, %d] for mode %v", prev.Min, prev.Max, prev.Mode, r.Min, r.Max, r.Mode)
+		}
+	}
+	return nil
+}
+
+// thresholds returns min pwm values of positions 1..n
+func (t DriveModeTable) thresholds() []int {
+	if len(t) == 0 {
+		return nil
+	}
+	thresholds := make([]int, 0, len(t)-1)
+	for _, r := range t[1:] {
+		thresholds = append(thresholds, r.Min)
+	}
+	return thresholds
+}
@@ -0,0 +1,85 @@
+package arduino
+
+import (
+	"encoding/json"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"reflect"
+	"testing"
+)
+
+func TestNewDriveModeTableFromJson(t *testing.T) {
+	got, err := NewDriveModeTableFromJson("test_data/drive_modes.json")
+	if err != nil {
+		t.Fatalf("NewDriveModeTableFromJson() error = %v", err)
+	}
+	want := DriveModeTable{
+		{Min: 900, Max: 1299, Mode: events.DriveMode_USER},
+		{Min: 1300, Max: 1699, Mode: events.DriveMode_COPILOT},
+		{Min: 1700, Max: 2100, Mode: events.DriveMode_PILOT},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NewDriveModeTableFromJson() got = %v, want %v", got, want)
+	}
+}
+
+func TestDriveModeTable_json(t *testing.T) {
+	content, err := json.Marshal(DefaultDriveModeTable)
+	if err != nil {
+		t.Fatalf("unable to marshal drive mode table: %v", err)
+	}
+	want := `[{"min":0,"max":1200,"mode":"USER"},{"min":1201,"max":1800,"mode":"COPILOT"},{"min":1801,"max":3000,"mode":"PILOT"}]`
+	if string(content) != want {
+		t.Errorf("bad json content, expected: %v, actual: %v", want, string(content))
+	}
+
+	var got DriveModeTable
+	if err := json.Unmarshal(content, &got); err != nil {
+		t.Fatalf("unable to unmarshal drive mode table: %v", err)
+	}
+	if !reflect.DeepEqual(got, DefaultDriveModeTable) {
+		t.Errorf("bad unmarshalled table, expected: %v, actual: %v", DefaultDriveModeTable, got)
+	}
+
+	for _, content := range []string{`[{"min":0,"max":1200,"mode":"TURBO"}]`, `[{"min":0,"max":1200,"mode":42}]`} {
+		if err := json.Unmarshal([]byte(content), &got); err == nil {
+			t.Errorf("unknown drive mode in %v should be rejected", content)
+		}
+	}
+}
+
+func TestDriveModeTable_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		table   DriveModeTable
+		wantErr bool
+	}{
+		{name: "default", table: DefaultDriveModeTable},
+		{name: "2 positions", table: DriveModeTable{{Min: 1000, Max: 1499, Mode: events.DriveMode_USER}, {Min: 1500, Max: 2000, Mode: events.DriveMode_PILOT}}},
+		{name: "single position", table: DriveModeTable{{Min: 1000, Max: 2000, Mode: events.DriveMode_USER}}},
+		{name: "empty", table: DriveModeTable{}, wantErr: true},
+		{name: "inverted range", table: DriveModeTable{{Min: 2000, Max: 1000, Mode: events.DriveMode_USER}}, wantErr: true},
+		{name: "unknown mode", table: DriveModeTable{{Min: 1000, Max: 2000, Mode: events.DriveMode(42)}}, wantErr: true},
+		{
+			name:    "overlap",
+			table:   DriveModeTable{{Min: 1000, Max: 1500, Mode: events.DriveMode_USER}, {Min: 1500, Max: 2000, Mode: events.DriveMode_PILOT}},
+			wantErr: true,
+		},
+		{
+			name:    "gap",
+			table:   DriveModeTable{{Min: 1000, Max: 1400, Mode: events.DriveMode_USER}, {Min: 1500, Max: 2000, Mode: events.DriveMode_PILOT}},
+			wantErr: true,
+		},
+		{
+			name:    "unsorted",
+			table:   DriveModeTable{{Min: 1500, Max: 2000, Mode: events.DriveMode_PILOT}, {Min: 1000, Max: 1499, Mode: events.DriveMode_USER}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.table.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package arduino
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/cyrilix/robocar-arduino/pkg/frame"
+	"go.uber.org/zap"
+	"sort"
+	"time"
+)
+
+// FailsafeConfig describes when radio inputs must be considered as lost. Zero values disable the matching check.
+type FailsafeConfig struct {
+	// LineTimeout is the max delay without any valid serial line
+	LineTimeout time.Duration
+	// ChannelTimeouts is the max delay without valid pulse (value > 0) for each channel
+	ChannelTimeouts map[int]time.Duration
+	// ReceiverPWM is the pwm value sent by RC receiver on each channel when it enters in failsafe mode
+	ReceiverPWM map[int]int
+	// ReceiverTolerance is the max difference with ReceiverPWM values to detect receiver failsafe
+	ReceiverTolerance int
+	// ReceiverDelay is the min duration channels must stay at ReceiverPWM values before to engage failsafe
+	ReceiverDelay time.Duration
+	// MinFrequency is the min RC signal frame rate reported by arduino, under this value radio link is degraded
+	MinFrequency int
+	// FrequencyDelay is the min duration reported frequency must stay under MinFrequency before to engage failsafe
+	FrequencyDelay time.Duration
+}
+
+type failsafeMessage struct {
+	Active bool   `json:"active"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func WithFailsafe(config *FailsafeConfig) Option {
+	return func(p *Part) {
+		p.failsafeConfig = config
+	}
+}
+
+func WithFailsafeTopic(topic string) Option {
+	return func(p *Part) {
+		p.failsafeTopic = topic
+	}
+}
+
+func (a *Part) Failsafe() bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.failsafe
+}
+
+// recordChannels updates last valid time of each channel watched by failsafe config, mutex should be locked
+func (a *Part) recordChannels(f *frame.Frame, now time.Time) {
+	a.lastLine = now
+	c := a.failsafeConfig
+	if c == nil {
+		return
+	}
+
+	if c.MinFrequency <= 0 || f.Frequency >= c.MinFrequency {
+		a.lowFrequencySince = time.Time{}
+	} else if a.lowFrequencySince.IsZero() {
+		zap.S().Warnf("reported RC signal frequency %d Hz below %d Hz, radio link is degraded", f.Frequency, c.MinFrequency)
+		a.lowFrequencySince = now
+	}
+
+	if a.lastChannelValues == nil {
+		a.lastChannelValues = make(map[int]time.Time, len(c.ChannelTimeouts))
+	}
+	for ch := range c.ChannelTimeouts {
+		if value, ok := f.Channel(ch); ok && value > 0 {
+			a.lastChannelValues[ch] = now
+		}
+	}
+
+	if len(c.ReceiverPWM) == 0 {
+		return
+	}
+	frozen := true
+	for ch, pwm := range c.ReceiverPWM {
+		value, ok := f.Channel(ch)
+		if !ok || value < pwm-c.ReceiverTolerance || value > pwm+c.ReceiverTolerance {
+			frozen = false
+			break
+		}
+	}
+	if !frozen {
+		a.receiverFailsafeSince = time.Time{}
+	} else if a.receiverFailsafeSince.IsZero() {
+		a.receiverFailsafeSince = now
+	}
+}
+
+// failsafeReason returns why failsafe should be engaged or empty string if inputs are valid, mutex should be locked
+func (a *Part) failsafeReason(now time.Time) string {
+	c := a.failsafeConfig
+	if c == nil {
+		return ""
+	}
+	if c.LineTimeout > 0 && now.Sub(a.lastLine) > c.LineTimeout {
+		return fmt.Sprintf("no valid serial line since %v", now.Sub(a.lastLine).Round(time.Millisecond))
+	}
+
+	channels := make([]int, 0, len(c.ChannelTimeouts))
+	for ch := range c.ChannelTimeouts {
+		channels = append(channels, ch)
+	}
+	sort.Ints(channels)
+	for _, ch := range channels {
+		timeout := c.ChannelTimeouts[ch]
+		last, ok := a.lastChannelValues[ch]
+		if !ok {
+			last = a.lastLine
+		}
+		if timeout > 0 && now.Sub(last) > timeout {
+			return fmt.Sprintf("no valid value on channel %d since %v", ch, now.Sub(last).Round(time.Millisecond))
+		}
+	}
+
+	if !a.receiverFailsafeSince.IsZero() && now.Sub(a.receiverFailsafeSince) >= c.ReceiverDelay {
+		return "receiver failsafe values detected"
+	}
+
+	if !a.lowFrequencySince.IsZero() && now.Sub(a.lowFrequencySince) >= c.FrequencyDelay {
+		return fmt.Sprintf("reported frequency %d Hz below %d Hz", a.frequency, c.MinFrequency)
+	}
+	return ""
+}
+
+func (a *Part) updateFailsafe(now time.Time) {
+	a.mutex.Lock()
+	reason := a.failsafeReason(now)
+	active := reason != ""
+	if active == a.failsafe {
+		a.mutex.Unlock()
+		return
+	}
+	a.failsafe = active
+	a.neutralCommandPending = active
+	if active {
+		a.resetFilters()
+	}
+	a.mutex.Unlock()
+
+	if active {
+		zap.S().Warnf("failsafe engaged: %v", reason)
+	} else {
+		zap.S().Info("failsafe released, radio inputs are valid")
+	}
+	a.publishFailsafe(&failsafeMessage{Active: active, Reason: reason})
+}
+
+func (a *Part) publishFailsafe(msg *failsafeMessage) {
+	if a.failsafeTopic == "" {
+		return
+	}
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		zap.S().Errorf("unable to marshal failsafe message: %v", err)
+		return
+	}
+	a.publishMessage(a.failsafeTopic, payload)
+}
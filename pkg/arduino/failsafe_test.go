@@ -0,0 +1,186 @@
+package arduino
+
+import (
+	"encoding/json"
+	"github.com/cyrilix/robocar-arduino/pkg/frame"
+	"github.com/cyrilix/robocar-arduino/pkg/tools"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPart_failsafeReason(t *testing.T) {
+	now := time.Now()
+	line := mustParseLine(t, "12345,1500,1500,1500,1500,1500,1500,0,0,0,50")
+	receiverFailsafeLine := mustParseLine(t, "12345,1496,1002,1500,1500,1500,1500,0,0,0,50")
+	noPulseLine := mustParseLine(t, "12345,1500,0,1500,1500,1500,1500,0,0,0,50")
+	lowFrequencyLine := mustParseLine(t, "12345,1500,1500,1500,1500,1500,1500,0,0,0,20")
+
+	tests := []struct {
+		name       string
+		config     *FailsafeConfig
+		lines      []*frame.Frame
+		lineAge    time.Duration
+		elapsed    time.Duration
+		wantActive bool
+	}{
+		{
+			name:   "no config",
+			config: nil, lines: []*frame.Frame{line}, elapsed: time.Hour,
+			wantActive: false,
+		},
+		{
+			name:   "fresh line",
+			config: &FailsafeConfig{LineTimeout: 100 * time.Millisecond}, lines: []*frame.Frame{line}, elapsed: 50 * time.Millisecond,
+			wantActive: false,
+		},
+		{
+			name:   "stale line",
+			config: &FailsafeConfig{LineTimeout: 100 * time.Millisecond}, lines: []*frame.Frame{line}, elapsed: 150 * time.Millisecond,
+			wantActive: true,
+		},
+		{
+			name:   "channel with pulses",
+			config: &FailsafeConfig{ChannelTimeouts: map[int]time.Duration{2: 100 * time.Millisecond}}, lines: []*frame.Frame{line, line}, lineAge: 150 * time.Millisecond,
+			wantActive: false,
+		},
+		{
+			name:   "channel without pulse",
+			config: &FailsafeConfig{ChannelTimeouts: map[int]time.Duration{2: 100 * time.Millisecond}}, lines: []*frame.Frame{line, noPulseLine}, lineAge: 150 * time.Millisecond,
+			wantActive: true,
+		},
+		{
+			name: "receiver failsafe values",
+			config: &FailsafeConfig{
+				ReceiverPWM:       map[int]int{1: 1500, 2: 1000},
+				ReceiverTolerance: 5,
+				ReceiverDelay:     100 * time.Millisecond,
+			},
+			lines: []*frame.Frame{receiverFailsafeLine, receiverFailsafeLine}, lineAge: 150 * time.Millisecond,
+			wantActive: true,
+		},
+		{
+			name: "receiver failsafe values not stable",
+			config: &FailsafeConfig{
+				ReceiverPWM:       map[int]int{1: 1500, 2: 1000},
+				ReceiverTolerance: 5,
+				ReceiverDelay:     100 * time.Millisecond,
+			},
+			lines: []*frame.Frame{receiverFailsafeLine, line, receiverFailsafeLine}, lineAge: 150 * time.Millisecond,
+			wantActive: false,
+		},
+		{
+			name: "receiver failsafe out of tolerance",
+			config: &FailsafeConfig{
+				ReceiverPWM:       map[int]int{1: 1500, 2: 1000},
+				ReceiverTolerance: 1,
+				ReceiverDelay:     100 * time.Millisecond,
+			},
+			lines: []*frame.Frame{receiverFailsafeLine, receiverFailsafeLine}, lineAge: 150 * time.Millisecond,
+			wantActive: false,
+		},
+		{
+			name:   "reported frequency above min",
+			config: &FailsafeConfig{MinFrequency: 40, FrequencyDelay: 100 * time.Millisecond}, lines: []*frame.Frame{line, line}, lineAge: 150 * time.Millisecond,
+			wantActive: false,
+		},
+		{
+			name:   "reported frequency below min",
+			config: &FailsafeConfig{MinFrequency: 40, FrequencyDelay: 100 * time.Millisecond}, lines: []*frame.Frame{lowFrequencyLine, lowFrequencyLine}, lineAge: 150 * time.Millisecond,
+			wantActive: true,
+		},
+		{
+			name:   "reported frequency below min before delay",
+			config: &FailsafeConfig{MinFrequency: 40, FrequencyDelay: 100 * time.Millisecond}, lines: []*frame.Frame{lowFrequencyLine}, lineAge: 150 * time.Millisecond,
+			wantActive: false,
+		},
+		{
+			name:   "reported frequency back above min",
+			config: &FailsafeConfig{MinFrequency: 40, FrequencyDelay: 100 * time.Millisecond}, lines: []*frame.Frame{lowFrequencyLine, lowFrequencyLine, line}, lineAge: 150 * time.Millisecond,
+			wantActive: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := Part{failsafeConfig: tt.config}
+			// Lines are received every lineAge, the last one at now
+			for i, l := range tt.lines {
+				a.recordChannels(l, now.Add(-time.Duration(len(tt.lines)-1-i)*tt.lineAge))
+			}
+			got := a.failsafeReason(now.Add(tt.elapsed))
+			if (got != "") != tt.wantActive {
+				t.Errorf("failsafeReason() = '%v', want active: %v", got, tt.wantActive)
+			}
+		})
+	}
+}
+
+func TestPart_Failsafe(t *testing.T) {
+	oldPublish := publish
+	defer func() { publish = oldPublish }()
+
+	var muFailsafeEvents sync.Mutex
+	var failsafeEvents []failsafeMessage
+	publish = func(client mqtt.Client, topic string, qos byte, retain bool, payload []byte) mqtt.Token {
+		if topic != "car/part/arduino/failsafe" {
+			return nil
+		}
+		var msg failsafeMessage
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			t.Errorf("unable to unmarshal failsafe message: %v", err)
+		}
+		muFailsafeEvents.Lock()
+		defer muFailsafeEvents.Unlock()
+		failsafeEvents = append(failsafeEvents, msg)
+		return nil
+	}
+
+	server, client := net.Pipe()
+	defer client.Close()
+	a := Part{
+		serial:                     server,
+		pubFrequency:               200,
+		cancel:                     make(chan interface{}),
+		pwmSteeringConfig:          NewAsymetricPWMConfig(MinPwmAngle, MaxPwmAngle, MiddlePwmAngle),
+		pwmThrottleConfig:          &DefaultPwmThrottle,
+		pwmMaxThrottleCtrlConfig:   &DefaultPwmThrottle,
+		pwmSecondarySteeringConfig: &DefaultPwmThrottle,
+		pwmSecondaryThrottleConfig: &DefaultPwmThrottle,
+		throttleFeedbackThresholds: tools.NewThresholdConfig(),
+		failsafeConfig:             &FailsafeConfig{LineTimeout: 50 * time.Millisecond},
+		failsafeTopic:              "car/part/arduino/failsafe",
+	}
+	go func() {
+		if err := a.Start(); err != nil {
+			t.Errorf("unable to start part: %v", err)
+		}
+	}()
+	defer a.Stop()
+
+	if _, err := client.Write([]byte("12345,1985,1954,1500,1500,1500,1900,0,0,0,50\n")); err != nil {
+		t.Fatalf("unable to write line: %v", err)
+	}
+	waitFor(t, "valid inputs", func() bool { return a.Throttle() == 1. && a.Steering() == 1. })
+	if a.DriveMode() != events.DriveMode_PILOT {
+		t.Errorf("bad drive mode, expected: %v, actual: %v", events.DriveMode_PILOT, a.DriveMode())
+	}
+
+	waitFor(t, "failsafe engaged", a.Failsafe)
+	if a.Throttle() != 0. || a.Steering() != 0. || a.DriveMode() != events.DriveMode_INVALID {
+		t.Errorf("bad failsafe values, throttle: %v, steering: %v, drive mode: %v", a.Throttle(), a.Steering(), a.DriveMode())
+	}
+
+	if _, err := client.Write([]byte("12350,1985,1954,1500,1500,1500,1900,0,0,0,50\n")); err != nil {
+		t.Fatalf("unable to write line: %v", err)
+	}
+	waitFor(t, "failsafe released", func() bool { return !a.Failsafe() })
+
+	muFailsafeEvents.Lock()
+	defer muFailsafeEvents.Unlock()
+	if len(failsafeEvents) < 2 || !failsafeEvents[0].Active || failsafeEvents[0].Reason == "" || failsafeEvents[1].Active {
+		t.Errorf("bad failsafe events published: %v", failsafeEvents)
+	}
+}
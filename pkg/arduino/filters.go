@@ -0,0 +1,57 @@
+package arduino
+
+import (
+	"fmt"
+	"github.com/cyrilix/robocar-arduino/pkg/filter"
+)
+
+// WithFilter smooths raw pwm values of role channel before conversion
+func WithFilter(role ChannelRole, f filter.Filter) Option {
+	return func(p *Part) {
+		if p.filters == nil {
+			p.filters = make(map[ChannelRole]filter.Filter)
+		}
+		p.filters[role] = f
+	}
+}
+
+// WithFilters smooths raw pwm values by role, filters are stateful and can't be shared between parts
+func WithFilters(filters map[ChannelRole]filter.Filter) Option {
+	return func(p *Part) {
+		p.filters = filters
+	}
+}
+
+// NewFilters builds filters from their description by role name (ex: "steering": "median:5,ema:0.3")
+func NewFilters(specs map[string]string) (map[ChannelRole]filter.Filter, error) {
+	filters := make(map[ChannelRole]filter.Filter, len(specs))
+	for name, spec := range specs {
+		role := ChannelRole(name)
+		if _, ok := channelRoles[role]; !ok {
+			return nil, fmt.Errorf("unknown role '%v' for filter '%v'", name, spec)
+		}
+		f, err := filter.New(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter for role '%v': %w", name, err)
+		}
+		filters[role] = f
+	}
+	return filters, nil
+}
+
+// applyFilter filters raw pwm value of role, part mutex is locked by caller. Invalid values (no pulse) are returned
+// as is and don't update filter state.
+func (a *Part) applyFilter(role ChannelRole, value int) int {
+	f, ok := a.filters[role]
+	if !ok || value <= 0 {
+		return value
+	}
+	return f.Apply(value)
+}
+
+// resetFilters forgets values filtered before serial link or radio inputs were lost, part mutex is locked by caller
+func (a *Part) resetFilters() {
+	for _, f := range a.filters {
+		f.Reset()
+	}
+}
@@ -0,0 +1,78 @@
+package arduino
+
+import (
+	"github.com/cyrilix/robocar-arduino/pkg/filter"
+	"testing"
+)
+
+func TestNewFilters(t *testing.T) {
+	filters, err := NewFilters(map[string]string{
+		"steering":   "median:5,ema:0.3",
+		"drive-mode": "median:3",
+	})
+	if err != nil {
+		t.Fatalf("NewFilters() error = %v", err)
+	}
+	if len(filters) != 2 || filters[RoleSteering] == nil || filters[RoleDriveMode] == nil {
+		t.Errorf("bad filters: %v", filters)
+	}
+
+	if _, err := NewFilters(map[string]string{"turbo": "median:3"}); err == nil {
+		t.Errorf("NewFilters() should reject unknown role")
+	}
+	if _, err := NewFilters(map[string]string{"steering": "median:0"}); err == nil {
+		t.Errorf("NewFilters() should reject invalid filter")
+	}
+}
+
+func TestPart_applyFilter(t *testing.T) {
+	a := Part{
+		pwmSteeringConfig: NewPWMConfig(1000, 2000),
+		pwmThrottleConfig: NewPWMConfig(1000, 2000),
+		channelMapping:    ChannelMapping{1: RoleSteering, 2: RoleThrottle},
+	}
+	WithFilter(RoleSteering, filter.NewMedian(3))(&a)
+
+	tests := []struct {
+		line                       string
+		wantSteering, wantThrottle float32
+	}{
+		{line: "12345,1500,1500,1500,1500,1500,1500,0,0,0,50", wantSteering: 0, wantThrottle: 0},
+		{line: "12345,1500,1500,1500,1500,1500,1500,0,0,0,50", wantSteering: 0, wantThrottle: 0},
+		// Spike on steering is rejected, throttle is unfiltered
+		{line: "12345,2000,2000,1500,1500,1500,1500,0,0,0,50", wantSteering: 0, wantThrottle: 1},
+		{line: "12345,1500,1500,1500,1500,1500,1500,0,0,0,50", wantSteering: 0, wantThrottle: 0},
+		{line: "12345,1750,1750,1500,1500,1500,1500,0,0,0,50", wantSteering: 0.5, wantThrottle: 0.5},
+		{line: "12345,1750,1750,1500,1500,1500,1500,0,0,0,50", wantSteering: 0.5, wantThrottle: 0.5},
+	}
+	for _, tt := range tests {
+		a.updateValues(mustParseLine(t, tt.line))
+		if a.Steering() != tt.wantSteering {
+			t.Errorf("bad steering for line %v, expected: %v, actual: %v", tt.line, tt.wantSteering, a.Steering())
+		}
+		if a.Throttle() != tt.wantThrottle {
+			t.Errorf("bad throttle for line %v, expected: %v, actual: %v", tt.line, tt.wantThrottle, a.Throttle())
+		}
+	}
+}
+
+func TestPart_applyFilter_invalidAndReset(t *testing.T) {
+	a := Part{}
+	WithFilter(RoleSteering, filter.NewEMA(0.5))(&a)
+
+	if got := a.applyFilter(RoleSteering, 1500); got != 1500 {
+		t.Errorf("applyFilter(1500) = %v, want %v", got, 1500)
+	}
+	// No pulse is returned as is and doesn't pull filtered value
+	if got := a.applyFilter(RoleSteering, 0); got != 0 {
+		t.Errorf("applyFilter(0) = %v, want %v", got, 0)
+	}
+	if got := a.applyFilter(RoleSteering, 1500); got != 1500 {
+		t.Errorf("applyFilter(1500) after no pulse = %v, want %v", got, 1500)
+	}
+
+	a.resetFilters()
+	if got := a.applyFilter(RoleSteering, 1900); got != 1900 {
+		t.Errorf("applyFilter(1900) after reset = %v, want %v", got, 1900)
+	}
+}
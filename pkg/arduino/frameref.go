@@ -0,0 +1,55 @@
+package arduino
+
+import (
+	"github.com/cyrilix/robocar-arduino/pkg/frame"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"strconv"
+	"time"
+)
+
+// FrameRefName is the name used in FrameRef of published messages
+const FrameRefName = "arduino"
+
+// updateFrameRef increments sequence id and computes capture time of frame on host clock. Must be called with
+// a.mutex locked.
+func (a *Part) updateFrameRef(f *frame.Frame, now time.Time) {
+	a.frameSeq++
+	a.frameReceivedAt = now
+	a.frameCreatedAt = a.updateClock(f, now)
+}
+
+// frameRef returns reference to last decoded frame, nil if no frame has been decoded yet, mutex should be locked
+func (a *Part) frameRef() *events.FrameRef {
+	if a.frameSeq == 0 {
+		return nil
+	}
+	return &events.FrameRef{
+		Name:      FrameRefName,
+		Id:        strconv.FormatUint(a.frameSeq, 10),
+		CreatedAt: timestamppb.New(a.frameCreatedAt),
+	}
+}
+
+// valueWithFrameRef reads value and reference to the frame it has been decoded from under the same lock, value
+// func is called with mutex locked
+func (a *Part) valueWithFrameRef(value func() float32) (float32, *events.FrameRef) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return value(), a.frameRef()
+}
+
+// FrameSeq returns sequence id of last decoded frame
+func (a *Part) FrameSeq() uint64 {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.frameSeq
+}
+
+// FrameTimes returns capture time of last decoded frame, estimated from arduino timestamp, and its receive time on
+// host clock
+func (a *Part) FrameTimes() (createdAt, receivedAt time.Time) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.frameCreatedAt, a.frameReceivedAt
+}
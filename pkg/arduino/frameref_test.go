@@ -0,0 +1,96 @@
+package arduino
+
+import (
+	"github.com/cyrilix/robocar-arduino/pkg/frame"
+	"github.com/cyrilix/robocar-arduino/pkg/tools"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPart_frameRef(t *testing.T) {
+	oldPublish := publish
+	defer func() { publish = oldPublish }()
+	published := make(map[string][]byte)
+	publish = func(client mqtt.Client, topic string, qos byte, retain bool, payload []byte) mqtt.Token {
+		published[topic] = payload
+		return nil
+	}
+
+	a := Part{
+		pwmSteeringConfig:          &DefaultPwmThrottle,
+		pwmThrottleConfig:          &DefaultPwmThrottle,
+		pwmMaxThrottleCtrlConfig:   &DefaultPwmThrottle,
+		pwmSecondarySteeringConfig: &DefaultPwmThrottle,
+		pwmSecondaryThrottleConfig: &DefaultPwmThrottle,
+		throttleFeedbackThresholds: tools.NewThresholdConfig(),
+		steeringTopic:              "steering",
+		throttleTopic:              "throttle",
+	}
+
+	a.publishSteering()
+	var msg events.SteeringMessage
+	unmarshalMsg(t, published["steering"], &msg)
+	if msg.FrameRef != nil {
+		t.Errorf("no FrameRef expected before first frame: %v", msg.FrameRef)
+	}
+
+	before := time.Now()
+	content := "12345,1500,1500,1500,1500,1500,1500,0,0,0,50\n12365,1500,1500,1500,1500,1500,1500,0,0,0,50\n"
+	_ = a.readFrames(strings.NewReader(content))
+	after := time.Now()
+
+	a.publishSteering()
+	a.publishThrottle()
+	var steering events.SteeringMessage
+	unmarshalMsg(t, published["steering"], &steering)
+	var throttle events.ThrottleMessage
+	unmarshalMsg(t, published["throttle"], &throttle)
+	for topic, ref := range map[string]*events.FrameRef{"steering": steering.FrameRef, "throttle": throttle.FrameRef} {
+		if ref == nil {
+			t.Fatalf("FrameRef expected on topic %v", topic)
+		}
+		if ref.Name != FrameRefName || ref.Id != "2" {
+			t.Errorf("bad FrameRef on topic %v: %v", topic, ref)
+		}
+		if createdAt := ref.CreatedAt.AsTime(); createdAt.Before(before) || createdAt.After(after) {
+			t.Errorf("bad FrameRef creation time on topic %v: %v, should be between %v and %v", topic, createdAt, before, after)
+		}
+	}
+}
+
+func TestPart_updateFrameRef(t *testing.T) {
+	a := Part{}
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		// Constant 2ms latency, except on some frames
+		latency := 2 * time.Millisecond
+		if i%10 == 5 {
+			latency = 10 * time.Millisecond
+		}
+		ts := uint32(10000 + i*20)
+		a.updateFrameRef(&frame.Frame{Timestamp: ts}, start.Add(time.Duration(i)*20*time.Millisecond+latency))
+	}
+	createdAt, receivedAt := a.FrameTimes()
+	if a.FrameSeq() != 100 {
+		t.Errorf("bad sequence id, expected: %v, actual: %v", 100, a.FrameSeq())
+	}
+	if want := start.Add(99*20*time.Millisecond + 2*time.Millisecond); !createdAt.Equal(want) {
+		t.Errorf("bad capture time, expected: %v, actual: %v", want, createdAt)
+	}
+	if want := start.Add(99*20*time.Millisecond + 2*time.Millisecond); !receivedAt.Equal(want) {
+		t.Errorf("bad receive time, expected: %v, actual: %v", want, receivedAt)
+	}
+
+	// Arduino restarted, clock estimation is reset
+	now := start.Add(time.Minute)
+	a.updateFrameRef(&frame.Frame{Timestamp: 15}, now)
+	if createdAt, _ := a.FrameTimes(); !createdAt.Equal(now) {
+		t.Errorf("bad capture time after arduino reset, expected: %v, actual: %v", now, createdAt)
+	}
+	if a.FrameSeq() != 101 {
+		t.Errorf("sequence id should keep growing after arduino reset, actual: %v", a.FrameSeq())
+	}
+}
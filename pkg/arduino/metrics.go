@@ -0,0 +1,227 @@
+package arduino
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cyrilix/robocar-arduino/pkg/frame"
+	"github.com/cyrilix/robocar-arduino/pkg/metrics"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	DefaultHealthTimeout = time.Second
+
+	frameRateWindow = time.Second
+)
+
+// WithHTTPListener exposes Prometheus metrics on /metrics and health status on /healthz, empty address disables
+// http listener
+func WithHTTPListener(addr string) Option {
+	return func(p *Part) {
+		p.httpAddr = addr
+	}
+}
+
+// WithHealthTimeout sets max delay without serial data before to report part as unhealthy
+func WithHealthTimeout(timeout time.Duration) Option {
+	return func(p *Part) {
+		p.healthTimeout = timeout
+	}
+}
+
+// Frequency returns RC signal frame rate reported by arduino
+func (a *Part) Frequency() int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.frequency
+}
+
+// updateStats updates serial statistics on new frame, mutex should be locked
+func (a *Part) updateStats(f *frame.Frame, now time.Time) {
+	a.framesTotal.Inc()
+	a.frequency = f.Frequency
+
+	if a.frameRateStart.IsZero() {
+		a.frameRateStart = now
+		return
+	}
+	a.frameRateCount += 1
+	if elapsed := now.Sub(a.frameRateStart); elapsed >= frameRateWindow {
+		a.frameRate = float64(a.frameRateCount) / elapsed.Seconds()
+		a.frameRateStart = now
+		a.frameRateCount = 0
+	}
+}
+
+// FrameRate returns number of frames received per second
+func (a *Part) FrameRate() float64 {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if time.Since(a.lastLine) > 2*frameRateWindow {
+		return 0.
+	}
+	return a.frameRate
+}
+
+// RegisterMetrics adds part metrics to registry
+func (a *Part) RegisterMetrics(r *metrics.Registry) {
+	r.Counter("rc_arduino_serial_frames_total", "Number of valid lines or binary frames read from arduino", &a.framesTotal)
+	r.Counter("rc_arduino_serial_invalid_lines_total", "Number of csv lines that don't match expected format", &a.invalidLines)
+	r.CounterFunc("rc_arduino_serial_crc_errors_total", "Number of binary frames with bad crc", func() float64 {
+		return float64(a.frameCounters.Snapshot().CRCErrors)
+	})
+	r.CounterFunc("rc_arduino_serial_invalid_frames_total", "Number of binary frames with invalid length or version", func() float64 {
+		return float64(a.frameCounters.Snapshot().InvalidFrames)
+	})
+	r.CounterFunc("rc_arduino_serial_skipped_bytes_total", "Number of bytes skipped to synchronize on binary frames", func() float64 {
+		return float64(a.frameCounters.Snapshot().SkippedBytes)
+	})
+	r.GaugeFunc("rc_arduino_serial_frame_rate", "Number of frames received per second", a.FrameRate)
+	r.GaugeFunc("rc_arduino_serial_last_frame_age_seconds", "Delay since last valid frame", func() float64 {
+		a.mutex.Lock()
+		defer a.mutex.Unlock()
+		return time.Since(a.lastLine).Seconds()
+	})
+	r.GaugeFunc("rc_arduino_reported_frequency", "RC signal frame rate reported by arduino", func() float64 {
+		return float64(a.Frequency())
+	})
+	r.GaugeFunc("rc_arduino_signal_degraded", "1 if reported frequency is below failsafe min frequency", func() float64 {
+		return boolToFloat(a.SignalDegraded())
+	})
+	r.GaugeFunc("rc_arduino_serial_link_up", "1 if serial link is open", func() float64 {
+		return boolToFloat(a.LinkState() == LinkUp)
+	})
+	r.CounterFunc("rc_arduino_serial_reconnections_total", "Number of serial link reconnections", func() float64 {
+		a.mutex.Lock()
+		defer a.mutex.Unlock()
+		return float64(a.reconnections)
+	})
+	r.GaugeFunc("rc_arduino_clock_drift_ppm", "Drift of arduino clock relative to host clock", func() float64 {
+		return a.ClockStats().Drift
+	})
+	r.GaugeFunc("rc_arduino_clock_jitter_seconds", "Standard deviation of delays between two frames on arduino clock", func() float64 {
+		return a.ClockStats().Jitter.Seconds()
+	})
+	r.CounterFunc("rc_arduino_clock_dropped_frames_total", "Number of frames missing between arduino timestamps", func() float64 {
+		return float64(a.ClockStats().DroppedFrames)
+	})
+	r.CounterFunc("rc_arduino_clock_resets_total", "Number of arduino restarts detected from timestamps", func() float64 {
+		return float64(a.ClockStats().Resets)
+	})
+	r.GaugeFunc("rc_arduino_failsafe", "1 if failsafe is engaged", func() float64 {
+		return boolToFloat(a.Failsafe())
+	})
+	r.GaugeFunc("rc_arduino_mqtt_connected", "1 if mqtt client is connected", func() float64 {
+		return boolToFloat(a.client != nil && a.client.IsConnected())
+	})
+	r.Counter("rc_arduino_mqtt_publish_errors_total", "Number of failed mqtt publish", &a.publishErrors)
+	r.Histogram("rc_arduino_mqtt_publish_duration_seconds", "Duration from publish of a qos > 0 message to its acknowledgment by broker", &a.publishDuration)
+	r.Histogram("rc_arduino_mqtt_publish_call_duration_seconds", "Duration of mqtt publish call, whatever the qos", &a.publishCallDuration)
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1.
+	}
+	return 0.
+}
+
+type healthMessage struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// health checks serial data are fresh and mqtt client is connected
+func (a *Part) health(now time.Time) (bool, map[string]string) {
+	timeout := a.healthTimeout
+	if timeout <= 0 {
+		timeout = DefaultHealthTimeout
+	}
+	healthy := true
+	checks := make(map[string]string, 2)
+
+	a.mutex.Lock()
+	age := now.Sub(a.lastLine)
+	a.mutex.Unlock()
+	if age > timeout {
+		healthy = false
+		checks["serial"] = fmt.Sprintf("no serial data since %v", age.Round(time.Millisecond))
+	} else {
+		checks["serial"] = "ok"
+	}
+
+	if a.client != nil {
+		if a.client.IsConnected() {
+			checks["mqtt"] = "ok"
+		} else {
+			healthy = false
+			checks["mqtt"] = "disconnected"
+		}
+	}
+	return healthy, checks
+}
+
+func (a *Part) serveHealth(w http.ResponseWriter, _ *http.Request) {
+	healthy, checks := a.health(time.Now())
+	msg := healthMessage{Status: "ok", Checks: checks}
+	w.Header().Set("Content-Type", "application/json")
+	if !healthy {
+		msg.Status = "unhealthy"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(&msg); err != nil {
+		zap.S().Warnf("unable to write health status: %v", err)
+	}
+}
+
+func (a *Part) httpHandler() http.Handler {
+	registry := metrics.NewRegistry()
+	a.RegisterMetrics(registry)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry)
+	mux.HandleFunc("/healthz", a.serveHealth)
+	return mux
+}
+
+// startHTTP starts http listener if configured
+func (a *Part) startHTTP() error {
+	if a.httpAddr == "" {
+		return nil
+	}
+	ln, err := net.Listen("tcp", a.httpAddr)
+	if err != nil {
+		return fmt.Errorf("unable to listen on %v: %w", a.httpAddr, err)
+	}
+	zap.S().Infof("serve metrics and health status on %v", ln.Addr())
+
+	srv := &http.Server{Handler: a.httpHandler(), ReadHeaderTimeout: 5 * time.Second}
+	a.mutex.Lock()
+	a.httpServer = srv
+	a.mutex.Unlock()
+
+	a.loopsWG.Add(1)
+	go func() {
+		defer a.loopsWG.Done()
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			zap.S().Errorf("http listener stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+func (a *Part) stopHTTP() {
+	a.mutex.Lock()
+	srv := a.httpServer
+	a.mutex.Unlock()
+	if srv == nil {
+		return
+	}
+	if err := srv.Close(); err != nil {
+		zap.S().Warnf("unable to close http listener: %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package arduino
+
+import (
+	"encoding/json"
+	"github.com/cyrilix/robocar-arduino/pkg/tools"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClient is a mqtt client with connection status only
+type fakeClient struct {
+	mqtt.Client
+	connected bool
+}
+
+func (f *fakeClient) IsConnected() bool {
+	return f.connected
+}
+
+func TestPart_health(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name        string
+		client      mqtt.Client
+		lastLine    time.Time
+		wantHealthy bool
+		wantChecks  map[string]string
+	}{
+		{
+			name:        "no mqtt client",
+			lastLine:    now.Add(-100 * time.Millisecond),
+			wantHealthy: true,
+			wantChecks:  map[string]string{"serial": "ok"},
+		},
+		{
+			name:        "healthy",
+			client:      &fakeClient{connected: true},
+			lastLine:    now.Add(-100 * time.Millisecond),
+			wantHealthy: true,
+			wantChecks:  map[string]string{"serial": "ok", "mqtt": "ok"},
+		},
+		{
+			name:        "stale serial",
+			client:      &fakeClient{connected: true},
+			lastLine:    now.Add(-1500 * time.Millisecond),
+			wantHealthy: false,
+			wantChecks:  map[string]string{"serial": "no serial data since 1.5s", "mqtt": "ok"},
+		},
+		{
+			name:        "mqtt disconnected",
+			client:      &fakeClient{connected: false},
+			lastLine:    now,
+			wantHealthy: false,
+			wantChecks:  map[string]string{"serial": "ok", "mqtt": "disconnected"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := Part{client: tt.client, lastLine: tt.lastLine}
+			healthy, checks := a.health(now)
+			if healthy != tt.wantHealthy {
+				t.Errorf("health() healthy = %v, want %v", healthy, tt.wantHealthy)
+			}
+			if len(checks) != len(tt.wantChecks) {
+				t.Errorf("health() checks = %v, want %v", checks, tt.wantChecks)
+			}
+			for k, v := range tt.wantChecks {
+				if checks[k] != v {
+					t.Errorf("health() check %v = %v, want %v", k, checks[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestPart_httpHandler(t *testing.T) {
+	oldPublish := publish
+	defer func() { publish = oldPublish }()
+	publish = func(client mqtt.Client, topic string, qos byte, retain bool, payload []byte) mqtt.Token {
+		return newFakeToken(mqtt.ErrNotConnected)
+	}
+
+	a := Part{
+		client:                     &fakeClient{connected: false},
+		pwmSteeringConfig:          &DefaultPwmThrottle,
+		pwmThrottleConfig:          &DefaultPwmThrottle,
+		pwmMaxThrottleCtrlConfig:   &DefaultPwmThrottle,
+		pwmSecondarySteeringConfig: &DefaultPwmThrottle,
+		pwmSecondaryThrottleConfig: &DefaultPwmThrottle,
+		throttleFeedbackThresholds: tools.NewThresholdConfig(),
+		steeringTopic:              "car/part/arduino/steering",
+	}
+	content := "12345,1500,1500,1500,1500,1500,1500,0,0,0,50\ninvalid line\n12350,1500,1500,1500,1500,1500,1500,0,0,0,48\n"
+	if err := a.readFrames(strings.NewReader(content)); err == nil {
+		t.Errorf("readFrames() should return io.EOF")
+	}
+	a.publishSteering()
+	h := a.httpHandler()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, m := range []string{
+		"\nrc_arduino_serial_frames_total 2\n",
+		"\nrc_arduino_serial_invalid_lines_total 1\n",
+		"\nrc_arduino_reported_frequency 48\n",
+		"\nrc_arduino_mqtt_connected 0\n",
+		"\nrc_arduino_mqtt_publish_errors_total 1\n",
+		"\nrc_arduino_mqtt_publish_duration_seconds_count 0\n",
+		"\nrc_arduino_mqtt_publish_call_duration_seconds_count 1\n",
+	} {
+		if !strings.Contains(rec.Body.String(), m) {
+			t.Errorf("metric '%v' not found in:\n%v", strings.TrimSpace(m), rec.Body.String())
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("bad health status code, expected: %v, actual: %v", http.StatusServiceUnavailable, rec.Code)
+	}
+	var msg healthMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &msg); err != nil {
+		t.Fatalf("unable to unmarshal health message: %v", err)
+	}
+	if msg.Status != "unhealthy" || msg.Checks["mqtt"] != "disconnected" || msg.Checks["serial"] != "ok" {
+		t.Errorf("bad health message: %+v", msg)
+	}
+}
+
+func TestPart_updateStats(t *testing.T) {
+	a := Part{}
+	start := time.Now()
+	for i := 0; i <= 50; i++ {
+		a.updateStats(mustParseLine(t, "12345,1500,1500,1500,1500,1500,1500,0,0,0,50\n"), start.Add(time.Duration(i)*20*time.Millisecond))
+	}
+	if a.frameRate != 50. {
+		t.Errorf("bad frame rate, expected: %v, actual: %v", 50., a.frameRate)
+	}
+	if a.framesTotal.Value() != 51 {
+		t.Errorf("bad frames counter, expected: %v, actual: %v", 51, a.framesTotal.Value())
+	}
+}
+
+func TestPart_startHTTP(t *testing.T) {
+	a := Part{httpAddr: "127.0.0.1:0"}
+	if err := a.startHTTP(); err != nil {
+		t.Fatalf("startHTTP() error = %v", err)
+	}
+	a.stopHTTP()
+	a.loopsWG.Wait()
+
+	a = Part{httpAddr: "invalid address"}
+	if err := a.startHTTP(); err == nil {
+		t.Errorf("startHTTP() should fail on invalid address")
+	}
+}
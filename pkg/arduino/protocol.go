@@ -0,0 +1,180 @@
+package arduino
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/cyrilix/robocar-arduino/pkg/frame"
+	"github.com/cyrilix/robocar-arduino/pkg/metrics"
+	"go.uber.org/zap"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Protocol is the wire format used by arduino to send channel values
+type Protocol string
+
+const (
+	// ProtocolAuto detects protocol from first bytes received after each connection
+	ProtocolAuto Protocol = "auto"
+	// ProtocolCSV reads 'timestamp,ch1..ch9,frequency' ascii lines
+	ProtocolCSV Protocol = "csv"
+	// ProtocolBinary reads frames described in frame package
+	ProtocolBinary Protocol = "binary"
+)
+
+func ParseProtocol(v string) (Protocol, error) {
+	switch p := Protocol(v); p {
+	case ProtocolAuto, ProtocolCSV, ProtocolBinary:
+		return p, nil
+	case "":
+		return ProtocolAuto, nil
+	default:
+		return ProtocolAuto, fmt.Errorf("invalid protocol '%v', should be one of %v, %v or %v", v, ProtocolAuto, ProtocolCSV, ProtocolBinary)
+	}
+}
+
+func WithProtocol(protocol Protocol) Option {
+	return func(p *Part) {
+		p.protocol = protocol
+	}
+}
+
+// FrameReader reads frames sent by arduino
+type FrameReader interface {
+	ReadFrame() (*frame.Frame, error)
+}
+
+// NewFrameReader reads frames from serial stream, protocol is detected from first bytes with ProtocolAuto
+func NewFrameReader(r io.Reader, protocol Protocol) (FrameReader, error) {
+	fr, _, err := newFrameReader(r, protocol, nil, nil)
+	return fr, err
+}
+
+// newFrameReader returns reader of protocol, or of protocol detected from first bytes with ProtocolAuto, and the
+// protocol used. Binary frame errors are counted in counters and invalid csv lines in invalidLines, both are optional.
+func newFrameReader(r io.Reader, protocol Protocol, counters *frame.Counters, invalidLines *metrics.Counter) (FrameReader, Protocol, error) {
+	br := bufio.NewReader(r)
+	if protocol == "" || protocol == ProtocolAuto {
+		var err error
+		protocol, err = detectProtocol(br)
+		if err != nil {
+			return nil, protocol, err
+		}
+		zap.S().Infof("serial protocol detected: %v", protocol)
+	}
+	if protocol == ProtocolBinary {
+		return &binaryReader{decoder: frame.NewDecoder(br, counters)}, protocol, nil
+	}
+	return &csvReader{r: br, invalidLines: invalidLines}, protocol, nil
+}
+
+// detectSize is the max number of bytes read to detect protocol, enough for noise, a partial line and a complete csv
+// line
+const detectSize = 256
+
+// detectProtocol looks for binary frame sync bytes (they can't be found in csv lines) or a complete valid csv line.
+// Noise bytes are usual before first line, when port is opened or arduino resets, so they don't prevent csv detection.
+func detectProtocol(r *bufio.Reader) (Protocol, error) {
+	for n := 1; ; n++ {
+		if r.Buffered() > n {
+			n = r.Buffered()
+		}
+		buf, err := r.Peek(n)
+		if err != nil {
+			return ProtocolAuto, err
+		}
+		if bytes.Contains(buf, []byte{frame.Sync1, frame.Sync2}) {
+			return ProtocolBinary, nil
+		}
+		last := bytes.LastIndexByte(buf, '\n')
+		if last >= 0 {
+			for _, line := range bytes.Split(buf[:last], []byte{'\n'}) {
+				if _, err := parseLine(string(line)); err == nil {
+					return ProtocolCSV, nil
+				}
+			}
+		}
+		if n >= detectSize {
+			// Only bytes after last line are checked, previous lines may have been corrupted by noise
+			if isASCII(buf[last+1:]) {
+				return ProtocolCSV, nil
+			}
+			return ProtocolBinary, nil
+		}
+	}
+}
+
+func isASCII(buf []byte) bool {
+	for _, b := range buf {
+		if b > 0x7F {
+			return false
+		}
+	}
+	return true
+}
+
+type binaryReader struct {
+	decoder *frame.Decoder
+}
+
+func (b *binaryReader) ReadFrame() (*frame.Frame, error) {
+	return b.decoder.Decode()
+}
+
+type csvReader struct {
+	r            *bufio.Reader
+	invalidLines *metrics.Counter
+}
+
+func (c *csvReader) ReadFrame() (*frame.Frame, error) {
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		zap.L().Debug("raw line: %s", zap.String("raw", line))
+		f, err := parseLine(line)
+		if err != nil {
+			zap.S().Errorf("invalid line: '%v': %v", line, err)
+			if c.invalidLines != nil {
+				c.invalidLines.Inc()
+			}
+			continue
+		}
+		return f, nil
+	}
+}
+
+func parseLine(line string) (*frame.Frame, error) {
+	if !serialLineRegex.MatchString(line) {
+		return nil, fmt.Errorf("line doesn't match expected format")
+	}
+	values := strings.Split(strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), ",")
+	if len(values) != frame.ChannelCount+2 {
+		return nil, fmt.Errorf("invalid number of fields: %d", len(values))
+	}
+
+	timestamp, err := strconv.ParseUint(values[0], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp: %w", err)
+	}
+	f := frame.Frame{Timestamp: uint32(timestamp)}
+	for i := range f.Channels {
+		f.Channels[i], err = strconv.Atoi(values[i+1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for channel %d: %w", i+1, err)
+		}
+	}
+	f.Frequency, err = strconv.Atoi(values[frame.ChannelCount+1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid frequency: %w", err)
+	}
+	return &f, nil
+}
+
+func (a *Part) FrameStats() frame.Stats {
+	return a.frameCounters.Snapshot()
+}
@@ -0,0 +1,158 @@
+package arduino
+
+import (
+	"bufio"
+	"github.com/cyrilix/robocar-arduino/pkg/frame"
+	"github.com/cyrilix/robocar-arduino/pkg/tools"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func mustParseLine(t *testing.T, line string) *frame.Frame {
+	t.Helper()
+	f, err := parseLine(line)
+	if err != nil {
+		t.Fatalf("unable to parse line '%v': %v", line, err)
+	}
+	return f
+}
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    *frame.Frame
+		wantErr bool
+	}{
+		{
+			name: "valid line",
+			line: "12345,1004,1986,1500,8700,1900,-1,0,2008,1500,50\r\n",
+			want: &frame.Frame{
+				Timestamp: 12345,
+				Channels:  [frame.ChannelCount]int{1004, 1986, 1500, 8700, 1900, -1, 0, 2008, 1500},
+				Frequency: 50,
+			},
+		},
+		{name: "invalid line", line: "12350,invalid line\n", wantErr: true},
+		{name: "missing channel", line: "12345,1004,1986,1500,8700,1900,-1,0,2008,50\n", wantErr: true},
+		{name: "too many fields", line: "12345,1004,1986,1500,8700,1900,-1,0,2008,1500,50,42\n", wantErr: true},
+		{name: "timestamp overflow", line: "99999999999,1004,1986,1500,8700,1900,-1,0,2008,1500,50\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLine(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseLine() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseLine() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectProtocol(t *testing.T) {
+	f := frame.Frame{Version: frame.Version1, Timestamp: 12345}
+	tests := []struct {
+		name    string
+		content string
+		want    Protocol
+	}{
+		{name: "csv", content: "12345,1004,1986,1500,8700,1900,-1,0,2008,1500,50\n", want: ProtocolCSV},
+		{name: "binary", content: string(frame.Marshal(&f)), want: ProtocolBinary},
+		{name: "csv after noise", content: "\xff\x80\x12\xfe5,1004\n12345,1004,1986,1500,8700,1900,-1,0,2008,1500,50\n12365,1004,1986,1500,8700,1900,-1,0,2008,1500,50\n", want: ProtocolCSV},
+		{name: "binary after partial frame", content: string(frame.Marshal(&f)[10:]) + string(frame.Marshal(&f)), want: ProtocolBinary},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(tt.content))
+			got, err := detectProtocol(r)
+			if err != nil {
+				t.Fatalf("detectProtocol() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("detectProtocol() = %v, want %v", got, tt.want)
+			}
+			if r.Buffered() != len(tt.content) {
+				t.Errorf("detectProtocol() should not consume content")
+			}
+		})
+	}
+}
+
+func TestNewFrameReader(t *testing.T) {
+	f := frame.Frame{Version: frame.Version1, Timestamp: 12345}
+	tests := []struct {
+		name     string
+		content  string
+		protocol Protocol
+	}{
+		{name: "detected csv", content: "12345,1004,1986,1500,8700,1900,-1,0,2008,1500,50\n", protocol: ProtocolAuto},
+		{name: "detected binary", content: string(frame.Marshal(&f)), protocol: ProtocolAuto},
+		{name: "csv", content: "12345,1004,1986,1500,8700,1900,-1,0,2008,1500,50\n", protocol: ProtocolCSV},
+		{name: "binary", content: string(frame.Marshal(&f)), protocol: ProtocolBinary},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fr, err := NewFrameReader(strings.NewReader(tt.content), tt.protocol)
+			if err != nil {
+				t.Fatalf("NewFrameReader() error = %v", err)
+			}
+			got, err := fr.ReadFrame()
+			if err != nil {
+				t.Fatalf("ReadFrame() error = %v", err)
+			}
+			if got.Timestamp != 12345 {
+				t.Errorf("bad frame timestamp %v, want %v", got.Timestamp, 12345)
+			}
+		})
+	}
+}
+
+func TestPart_BinaryProtocol(t *testing.T) {
+	oldPublish := publish
+	defer func() { publish = oldPublish }()
+	publish = func(client mqtt.Client, topic string, qos byte, retain bool, payload []byte) mqtt.Token { return nil }
+
+	server, client := net.Pipe()
+	defer client.Close()
+	a := Part{
+		serial:                     server,
+		pubFrequency:               100,
+		cancel:                     make(chan interface{}),
+		pwmSteeringConfig:          NewAsymetricPWMConfig(MinPwmAngle, MaxPwmAngle, MiddlePwmAngle),
+		pwmThrottleConfig:          &DefaultPwmThrottle,
+		pwmMaxThrottleCtrlConfig:   &DefaultPwmThrottle,
+		pwmSecondarySteeringConfig: &DefaultPwmThrottle,
+		pwmSecondaryThrottleConfig: &DefaultPwmThrottle,
+		throttleFeedbackThresholds: tools.NewThresholdConfig(),
+	}
+	go func() {
+		if err := a.Start(); err != nil {
+			t.Errorf("unable to start part: %v", err)
+		}
+	}()
+	defer a.Stop()
+
+	corrupted := frame.Marshal(&frame.Frame{Timestamp: 12340, Channels: [frame.ChannelCount]int{MinPwmAngle, 1954, 1500, 1500, 1500, 1900}})
+	corrupted[8] ^= 0xFF
+	valid := frame.Marshal(&frame.Frame{Timestamp: 12345, Channels: [frame.ChannelCount]int{MaxPwmAngle, 972, 1500, 1500, 1500, 1900}, Frequency: 50})
+	if _, err := client.Write(append(corrupted, valid...)); err != nil {
+		t.Fatalf("unable to write frames: %v", err)
+	}
+
+	waitFor(t, "frame decoded", func() bool { return a.DriveMode() == events.DriveMode_PILOT })
+	if a.Steering() != 1. || a.Throttle() != -1. {
+		t.Errorf("bad values, steering: %v, throttle: %v", a.Steering(), a.Throttle())
+	}
+	if a.FrameStats().Frames != 1 {
+		t.Errorf("bad frames counter, expected: %d, actual: %d", 1, a.FrameStats().Frames)
+	}
+	if a.FrameStats().CRCErrors != 1 {
+		t.Errorf("bad crc errors counter, expected: %d, actual: %d", 1, a.FrameStats().CRCErrors)
+	}
+}
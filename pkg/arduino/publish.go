@@ -0,0 +1,245 @@
+package arduino
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type PublishMode string
+
+const (
+	// PublishTicker publishes all values at fixed frequency
+	PublishTicker PublishMode = "ticker"
+	// PublishEvent publishes values as soon as they change
+	PublishEvent PublishMode = "event"
+
+	DefaultPublishEpsilon     = 0.01
+	DefaultPublishMinInterval = 20 * time.Millisecond
+	DefaultPublishHeartbeat   = time.Second
+
+	DefaultPublishTimeout = time.Second
+)
+
+// PublishPolicy describes how messages are published on a mqtt topic
+type PublishPolicy struct {
+	Qos    byte `json:"qos"`
+	Retain bool `json:"retain"`
+}
+
+func (p PublishPolicy) Validate() error {
+	if p.Qos > 2 {
+		return fmt.Errorf("invalid qos %d, should be 0, 1 or 2", p.Qos)
+	}
+	return nil
+}
+
+// ParseTopicPolicies parses list of 'topic:qos[:retain]' separated by ','
+// (ex: 'car/part/arduino/drive_mode:1:retain,car/part/arduino/throttle/target:0')
+func ParseTopicPolicies(s string) (map[string]PublishPolicy, error) {
+	result := make(map[string]PublishPolicy)
+	if strings.TrimSpace(s) == "" {
+		return result, nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		fields := strings.Split(strings.TrimSpace(item), ":")
+		if len(fields) < 2 || len(fields) > 3 || fields[0] == "" {
+			return nil, fmt.Errorf("invalid item '%v', should be 'topic:qos[:retain]'", item)
+		}
+		qos, err := strconv.ParseUint(fields[1], 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid qos in item '%v': %w", item, err)
+		}
+		p := PublishPolicy{Qos: byte(qos)}
+		if len(fields) == 3 {
+			if fields[2] != "retain" {
+				return nil, fmt.Errorf("invalid flag '%v' in item '%v', only 'retain' is supported", fields[2], item)
+			}
+			p.Retain = true
+		}
+		if err := p.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid item '%v': %w", item, err)
+		}
+		result[fields[0]] = p
+	}
+	return result, nil
+}
+
+// WithPublishPolicy sets qos and retain flag used to publish messages
+func WithPublishPolicy(policy PublishPolicy) Option {
+	return func(p *Part) {
+		p.publishPolicy = policy
+	}
+}
+
+// WithTopicPolicies overrides publish policy for some topics
+func WithTopicPolicies(policies map[string]PublishPolicy) Option {
+	return func(p *Part) {
+		p.topicPolicies = policies
+	}
+}
+
+// WithPublishTimeout sets max delay to wait for publish acknowledgment before to consider publish as failed
+func WithPublishTimeout(timeout time.Duration) Option {
+	return func(p *Part) {
+		p.publishTimeout = timeout
+	}
+}
+
+func (a *Part) topicPolicy(topic string) PublishPolicy {
+	if p, ok := a.topicPolicies[topic]; ok {
+		return p
+	}
+	return a.publishPolicy
+}
+
+// publishMessage publishes payload with topic policy. Result of qos 0 publish is only checked if already known, no
+// acknowledgment is expected from broker. Acknowledgment of qos > 0 publish is awaited without blocking caller.
+// Duration of publish call is observed whatever the qos.
+func (a *Part) publishMessage(topic string, payload []byte) {
+	p := a.topicPolicy(topic)
+	start := time.Now()
+	token := publish(a.client, topic, p.Qos, p.Retain, payload)
+	a.publishCallDuration.Observe(time.Since(start).Seconds())
+	if token == nil {
+		return
+	}
+	select {
+	case <-token.Done():
+		if p.Qos > 0 {
+			a.publishDuration.Observe(time.Since(start).Seconds())
+		}
+		a.checkPublish(topic, token.Error())
+		return
+	default:
+	}
+	if p.Qos == 0 {
+		return
+	}
+	go func() {
+		timeout := a.publishTimeout
+		if timeout <= 0 {
+			timeout = DefaultPublishTimeout
+		}
+		if !token.WaitTimeout(timeout) {
+			a.checkPublish(topic, fmt.Errorf("no acknowledgment after %v", timeout))
+			return
+		}
+		a.publishDuration.Observe(time.Since(start).Seconds())
+		a.checkPublish(topic, token.Error())
+	}()
+}
+
+// checkPublish counts publish failures, a warning is logged when publish starts to fail, then failures are only
+// logged at debug level until a publish succeeds
+func (a *Part) checkPublish(topic string, err error) {
+	if err == nil {
+		if a.publishFailing.CompareAndSwap(true, false) {
+			zap.S().Infof("mqtt publish succeeded again on topic %v", topic)
+		}
+		return
+	}
+	a.publishErrors.Inc()
+	if a.publishFailing.CompareAndSwap(false, true) {
+		zap.S().Warnf("unable to publish on topic %v: %v", topic, err)
+		return
+	}
+	zap.S().Debugf("unable to publish on topic %v: %v", topic, err)
+}
+
+func ParsePublishMode(s string) (PublishMode, error) {
+	switch m := PublishMode(strings.ToLower(strings.TrimSpace(s))); m {
+	case "", PublishTicker:
+		return PublishTicker, nil
+	case PublishEvent:
+		return m, nil
+	default:
+		return PublishTicker, fmt.Errorf("unknown publish mode '%v', should be %v or %v", s, PublishTicker, PublishEvent)
+	}
+}
+
+// EventPublishConfig configures event publish mode
+type EventPublishConfig struct {
+	// Epsilon is the min change of percent values to publish a new message, discrete values (drive mode, switch
+	// record) are published on any change
+	Epsilon float32
+	// MinInterval is the min delay between two messages on same topic, a change that occurs before is published as
+	// soon as delay is elapsed
+	MinInterval time.Duration
+	// MinIntervals overrides MinInterval for some roles
+	MinIntervals map[ChannelRole]time.Duration
+	// Heartbeat is the max delay without message on a topic, last value is published again when elapsed
+	Heartbeat time.Duration
+}
+
+func (c *EventPublishConfig) minInterval(role ChannelRole) time.Duration {
+	if d, ok := c.MinIntervals[role]; ok {
+		return d
+	}
+	return c.MinInterval
+}
+
+// WithEventPublish enables event publish mode, values are then published on change instead of at pubFrequency.
+// pubFrequency remains the frequency at which delayed changes and heartbeats are checked.
+func WithEventPublish(config *EventPublishConfig) Option {
+	return func(p *Part) {
+		p.eventPublish = config
+	}
+}
+
+type publishedValue struct {
+	value float32
+	at    time.Time
+}
+
+// eventPublisher keeps last published values for each role
+type eventPublisher struct {
+	mutex     sync.Mutex
+	published map[ChannelRole]publishedValue
+}
+
+// publishChanged publishes values that changed more than epsilon since last message, or not published since heartbeat
+func (a *Part) publishChanged(now time.Time) {
+	c := a.eventPublish
+	a.eventPublisher.mutex.Lock()
+	defer a.eventPublisher.mutex.Unlock()
+	if a.eventPublisher.published == nil {
+		a.eventPublisher.published = make(map[ChannelRole]publishedValue, len(publishOrder))
+	}
+
+	m := a.mapping()
+	for _, r := range publishOrder {
+		if _, ok := m.Channel(r); !ok {
+			continue
+		}
+		role := channelRoles[r]
+		value := role.value(a)
+		last, ok := a.eventPublisher.published[r]
+		if ok && !c.shouldPublish(r, role.discrete, value, last, now) {
+			continue
+		}
+		role.publish(a)
+		a.eventPublisher.published[r] = publishedValue{value: value, at: now}
+	}
+}
+
+func (c *EventPublishConfig) shouldPublish(r ChannelRole, discrete bool, value float32, last publishedValue, now time.Time) bool {
+	elapsed := now.Sub(last.at)
+	if c.Heartbeat > 0 && elapsed >= c.Heartbeat {
+		return true
+	}
+	changed := value != last.value
+	if !discrete {
+		diff := value - last.value
+		if diff < 0 {
+			diff = -diff
+		}
+		// Always publish when stick comes back to neutral or reaches its bounds
+		bound := value == 0. || value == 1. || value == -1.
+		changed = diff > c.Epsilon || (bound && diff > 0)
+	}
+	return changed && elapsed >= c.minInterval(r)
+}
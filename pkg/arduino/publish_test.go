@@ -0,0 +1,280 @@
+package arduino
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeToken is a mqtt token completed on creation, or on complete call if created with newPendingToken
+type fakeToken struct {
+	done chan struct{}
+	once sync.Once
+	err  error
+}
+
+func newFakeToken(err error) *fakeToken {
+	t := newPendingToken()
+	t.complete(err)
+	return t
+}
+
+func newPendingToken() *fakeToken {
+	return &fakeToken{done: make(chan struct{})}
+}
+
+func (f *fakeToken) complete(err error) {
+	f.once.Do(func() {
+		f.err = err
+		close(f.done)
+	})
+}
+
+func (f *fakeToken) Wait() bool {
+	<-f.done
+	return true
+}
+
+func (f *fakeToken) WaitTimeout(d time.Duration) bool {
+	select {
+	case <-f.done:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+func (f *fakeToken) Done() <-chan struct{} {
+	return f.done
+}
+
+func (f *fakeToken) Error() error {
+	<-f.done
+	return f.err
+}
+
+func TestParsePublishMode(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    PublishMode
+		wantErr bool
+	}{
+		{value: "", want: PublishTicker},
+		{value: "ticker", want: PublishTicker},
+		{value: "Event", want: PublishEvent},
+		{value: "sometimes", want: PublishTicker, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParsePublishMode(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParsePublishMode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParsePublishMode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEventPublishConfig_shouldPublish(t *testing.T) {
+	c := EventPublishConfig{
+		Epsilon:      0.05,
+		MinInterval:  20 * time.Millisecond,
+		MinIntervals: map[ChannelRole]time.Duration{RoleDriveMode: 100 * time.Millisecond},
+		Heartbeat:    time.Second,
+	}
+	now := time.Now()
+	tests := []struct {
+		name     string
+		role     ChannelRole
+		discrete bool
+		value    float32
+		last     publishedValue
+		want     bool
+	}{
+		{name: "unchanged", role: RoleSteering, value: 0.5, last: publishedValue{0.5, now.Add(-100 * time.Millisecond)}, want: false},
+		{name: "change under epsilon", role: RoleSteering, value: 0.54, last: publishedValue{0.5, now.Add(-100 * time.Millisecond)}, want: false},
+		{name: "change over epsilon", role: RoleSteering, value: 0.44, last: publishedValue{0.5, now.Add(-100 * time.Millisecond)}, want: true},
+		{name: "change before min interval", role: RoleSteering, value: 0.8, last: publishedValue{0.5, now.Add(-10 * time.Millisecond)}, want: false},
+		{name: "back to neutral", role: RoleSteering, value: 0., last: publishedValue{0.02, now.Add(-100 * time.Millisecond)}, want: true},
+		{name: "reach bound", role: RoleThrottle, value: 1., last: publishedValue{0.98, now.Add(-100 * time.Millisecond)}, want: true},
+		{name: "discrete change", role: RoleDriveMode, discrete: true, value: 2, last: publishedValue{1, now.Add(-150 * time.Millisecond)}, want: true},
+		{name: "discrete change before role min interval", role: RoleDriveMode, discrete: true, value: 2, last: publishedValue{1, now.Add(-50 * time.Millisecond)}, want: false},
+		{name: "heartbeat", role: RoleSteering, value: 0.5, last: publishedValue{0.5, now.Add(-time.Second)}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.shouldPublish(tt.role, tt.discrete, tt.value, tt.last, now); got != tt.want {
+				t.Errorf("shouldPublish() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPart_publishChanged(t *testing.T) {
+	oldPublish := publish
+	defer func() { publish = oldPublish }()
+	var published []string
+	publish = func(client mqtt.Client, topic string, qos byte, retain bool, payload []byte) mqtt.Token {
+		published = append(published, topic)
+		return nil
+	}
+
+	a := Part{
+		steeringTopic:  "steering",
+		driveModeTopic: "drive_mode",
+		channelMapping: ChannelMapping{1: RoleSteering, 6: RoleDriveMode},
+		eventPublish: &EventPublishConfig{
+			Epsilon:     0.05,
+			MinInterval: 20 * time.Millisecond,
+			Heartbeat:   time.Second,
+		},
+	}
+	start := time.Now()
+	steps := []struct {
+		at        time.Duration
+		steering  float32
+		driveMode events.DriveMode
+		want      []string
+	}{
+		{at: 0, steering: 0., driveMode: events.DriveMode_USER, want: []string{"steering", "drive_mode"}},
+		{at: 5 * time.Millisecond, steering: 0.01, driveMode: events.DriveMode_USER, want: nil},
+		{at: 30 * time.Millisecond, steering: 0.5, driveMode: events.DriveMode_USER, want: []string{"steering"}},
+		{at: 35 * time.Millisecond, steering: 0.8, driveMode: events.DriveMode_PILOT, want: []string{"drive_mode"}},
+		{at: 50 * time.Millisecond, steering: 0.8, driveMode: events.DriveMode_PILOT, want: []string{"steering"}},
+		{at: 500 * time.Millisecond, steering: 0.8, driveMode: events.DriveMode_PILOT, want: nil},
+		{at: 1035 * time.Millisecond, steering: 0.8, driveMode: events.DriveMode_PILOT, want: []string{"drive_mode"}},
+		{at: 1050 * time.Millisecond, steering: 0.8, driveMode: events.DriveMode_PILOT, want: []string{"steering"}},
+	}
+	for _, s := range steps {
+		published = nil
+		a.mutex.Lock()
+		a.steering = s.steering
+		a.driveMode = s.driveMode
+		a.mutex.Unlock()
+		a.publishChanged(start.Add(s.at))
+		if fmt.Sprintf("%v", published) != fmt.Sprintf("%v", s.want) {
+			t.Errorf("at %v, bad published topics, expected: %v, actual: %v", s.at, s.want, published)
+		}
+	}
+}
+
+func TestParseTopicPolicies(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    map[string]PublishPolicy
+		wantErr bool
+	}{
+		{value: "", want: map[string]PublishPolicy{}},
+		{
+			value: "car/part/arduino/drive_mode:1:retain, car/part/arduino/throttle/target:0",
+			want: map[string]PublishPolicy{
+				"car/part/arduino/drive_mode":      {Qos: 1, Retain: true},
+				"car/part/arduino/throttle/target": {Qos: 0},
+			},
+		},
+		{value: "car/part/arduino/drive_mode", wantErr: true},
+		{value: "car/part/arduino/drive_mode:3", wantErr: true},
+		{value: "car/part/arduino/drive_mode:1:persist", wantErr: true},
+		{value: ":1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseTopicPolicies(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseTopicPolicies() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseTopicPolicies() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPart_publishMessage(t *testing.T) {
+	oldPublish := publish
+	defer func() { publish = oldPublish }()
+
+	type publication struct {
+		topic  string
+		qos    byte
+		retain bool
+	}
+	var muPublished sync.Mutex
+	var published []publication
+	tokens := make(map[string]*fakeToken)
+	publish = func(client mqtt.Client, topic string, qos byte, retain bool, payload []byte) mqtt.Token {
+		muPublished.Lock()
+		defer muPublished.Unlock()
+		published = append(published, publication{topic, qos, retain})
+		if token, ok := tokens[topic]; ok {
+			return token
+		}
+		return nil
+	}
+
+	a := Part{
+		publishPolicy:  PublishPolicy{Qos: 0},
+		topicPolicies:  map[string]PublishPolicy{"drive_mode": {Qos: 1, Retain: true}},
+		publishTimeout: 20 * time.Millisecond,
+	}
+
+	tokens["throttle"] = newFakeToken(nil)
+	a.publishMessage("throttle", []byte("1"))
+	if a.publishErrors.Value() != 0 {
+		t.Errorf("successful publish should not be counted as error")
+	}
+
+	tokens["throttle"] = newFakeToken(errors.New("not connected"))
+	a.publishMessage("throttle", []byte("1"))
+	if a.publishErrors.Value() != 1 {
+		t.Errorf("failed publish should be counted, errors: %v", a.publishErrors.Value())
+	}
+
+	// Qos 0 publish not completed yet isn't awaited
+	pending := newPendingToken()
+	tokens["throttle"] = pending
+	a.publishMessage("throttle", []byte("1"))
+	pending.complete(errors.New("not connected"))
+	time.Sleep(2 * a.publishTimeout)
+	if a.publishErrors.Value() != 1 {
+		t.Errorf("qos 0 publish should not be awaited, errors: %v", a.publishErrors.Value())
+	}
+
+	// Qos 1 publish is acknowledged later
+	pending = newPendingToken()
+	tokens["drive_mode"] = pending
+	a.publishMessage("drive_mode", []byte("1"))
+	pending.complete(errors.New("rejected"))
+	waitFor(t, "acknowledgment error", func() bool { return a.publishErrors.Value() == 2 })
+
+	// Qos 1 publish never acknowledged
+	tokens["drive_mode"] = newPendingToken()
+	a.publishMessage("drive_mode", []byte("1"))
+	waitFor(t, "acknowledgment timeout", func() bool { return a.publishErrors.Value() == 3 })
+
+	// nil token, nothing to check
+	a.publishMessage("steering", []byte("1"))
+
+	// Duration is observed for acknowledged qos 1 publish only
+	if n := a.publishDuration.Snapshot().Count; n != 1 {
+		t.Errorf("bad number of publish durations %v, want %v", n, 1)
+	}
+	// Duration of publish call is observed for every publish
+	if n := a.publishCallDuration.Snapshot().Count; n != 6 {
+		t.Errorf("bad number of publish call durations %v, want %v", n, 6)
+	}
+
+	muPublished.Lock()
+	defer muPublished.Unlock()
+	want := []publication{{"throttle", 0, false}, {"throttle", 0, false}, {"throttle", 0, false}, {"drive_mode", 1, true}, {"drive_mode", 1, true}, {"steering", 0, false}}
+	if !reflect.DeepEqual(published, want) {
+		t.Errorf("bad publications, expected: %v, actual: %v", want, published)
+	}
+}
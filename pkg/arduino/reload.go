@@ -0,0 +1,166 @@
+package arduino
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/cyrilix/robocar-arduino/pkg/tools"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"go.uber.org/zap"
+)
+
+// RuntimeConfig holds configs that can be replaced while part is running, nil configs are left unchanged
+type RuntimeConfig struct {
+	// Version identifies config in status messages, next version is used if 0
+	Version           int64                  `json:"version,omitempty"`
+	Steering          *PWMConfig             `json:"steering,omitempty"`
+	Throttle          *PWMConfig             `json:"throttle,omitempty"`
+	MaxThrottleCtrl   *PWMConfig             `json:"max_throttle_ctrl,omitempty"`
+	SecondarySteering *PWMConfig             `json:"secondary_steering,omitempty"`
+	SecondaryThrottle *PWMConfig             `json:"secondary_throttle,omitempty"`
+	ThrottleFeedback  *tools.ThresholdConfig `json:"throttle_feedback,omitempty"`
+}
+
+func (c *RuntimeConfig) Validate() error {
+	if c.Version < 0 {
+		return fmt.Errorf("invalid version %d", c.Version)
+	}
+	for _, pwm := range []struct {
+		name   string
+		config *PWMConfig
+	}{
+		{"steering", c.Steering},
+		{"throttle", c.Throttle},
+		{"max_throttle_ctrl", c.MaxThrottleCtrl},
+		{"secondary_steering", c.SecondarySteering},
+		{"secondary_throttle", c.SecondaryThrottle},
+	} {
+		if pwm.config == nil {
+			continue
+		}
+		if err := pwm.config.Validate(); err != nil {
+			return fmt.Errorf("invalid %v pwm config: %w", pwm.name, err)
+		}
+	}
+	if c.ThrottleFeedback != nil {
+		if err := c.ThrottleFeedback.Validate(); err != nil {
+			return fmt.Errorf("invalid throttle feedback thresholds: %w", err)
+		}
+	}
+	return nil
+}
+
+// configStatusMessage is published on config status topic after each reload
+type configStatusMessage struct {
+	Version int64  `json:"version"`
+	Applied bool   `json:"applied"`
+	Error   string `json:"error,omitempty"`
+}
+
+// WithConfigTopics sets mqtt topic where runtime configs are received, and topic where reload status is published.
+// Messages on config topic are json RuntimeConfig applied over current configs, so only changed values are required.
+func WithConfigTopics(configTopic, statusTopic string) Option {
+	return func(p *Part) {
+		p.configTopic = configTopic
+		p.configStatusTopic = statusTopic
+	}
+}
+
+// ConfigVersion returns version of last applied runtime config, 0 if none was applied
+func (a *Part) ConfigVersion() int64 {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.configVersion
+}
+
+// RuntimeConfig returns a copy of current runtime configs
+func (a *Part) RuntimeConfig() *RuntimeConfig {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	c := RuntimeConfig{
+		Version:           a.configVersion,
+		Steering:          copyPWMConfig(a.pwmSteeringConfig),
+		Throttle:          copyPWMConfig(a.pwmThrottleConfig),
+		MaxThrottleCtrl:   copyPWMConfig(a.pwmMaxThrottleCtrlConfig),
+		SecondarySteering: copyPWMConfig(a.pwmSecondarySteeringConfig),
+		SecondaryThrottle: copyPWMConfig(a.pwmSecondaryThrottleConfig),
+	}
+	if a.throttleFeedbackThresholds != nil {
+		c.ThrottleFeedback = a.throttleFeedbackThresholds.Copy()
+	}
+	return &c
+}
+
+// Reload validates c, then replaces current configs with configs of c. Nothing is applied if a config is invalid.
+// Result is published on config status topic.
+func (a *Part) Reload(c *RuntimeConfig) error {
+	a.reloadMutex.Lock()
+	defer a.reloadMutex.Unlock()
+
+	if err := c.Validate(); err != nil {
+		a.publishConfigStatus(configStatusMessage{Version: c.Version, Error: err.Error()})
+		return err
+	}
+
+	a.mutex.Lock()
+	version := c.Version
+	if version == 0 {
+		version = a.configVersion + 1
+	}
+	if c.Steering != nil {
+		a.pwmSteeringConfig = copyPWMConfig(c.Steering)
+	}
+	if c.Throttle != nil {
+		a.pwmThrottleConfig = copyPWMConfig(c.Throttle)
+	}
+	if c.MaxThrottleCtrl != nil {
+		a.pwmMaxThrottleCtrlConfig = copyPWMConfig(c.MaxThrottleCtrl)
+	}
+	if c.SecondarySteering != nil {
+		a.pwmSecondarySteeringConfig = copyPWMConfig(c.SecondarySteering)
+	}
+	if c.SecondaryThrottle != nil {
+		a.pwmSecondaryThrottleConfig = copyPWMConfig(c.SecondaryThrottle)
+	}
+	if c.ThrottleFeedback != nil {
+		a.throttleFeedbackThresholds = c.ThrottleFeedback
+	}
+	a.configVersion = version
+	a.mutex.Unlock()
+
+	zap.S().Infof("runtime config version %d applied", version)
+	a.publishConfigStatus(configStatusMessage{Version: version, Applied: true})
+	return nil
+}
+
+func (a *Part) onConfig(_ mqtt.Client, message mqtt.Message) {
+	c := a.RuntimeConfig()
+	c.Version = 0
+	if err := json.Unmarshal(message.Payload(), c); err != nil {
+		zap.S().Errorf("unable to unmarshal runtime config: %v", err)
+		a.publishConfigStatus(configStatusMessage{Error: fmt.Sprintf("invalid json: %v", err)})
+		return
+	}
+	if err := a.Reload(c); err != nil {
+		zap.S().Errorf("runtime config rejected: %v", err)
+	}
+}
+
+func (a *Part) publishConfigStatus(msg configStatusMessage) {
+	if a.configStatusTopic == "" {
+		return
+	}
+	payload, err := json.Marshal(&msg)
+	if err != nil {
+		zap.S().Errorf("unable to marshal config status message: %v", err)
+		return
+	}
+	a.publishMessage(a.configStatusTopic, payload)
+}
+
+func copyPWMConfig(c *PWMConfig) *PWMConfig {
+	if c == nil {
+		return nil
+	}
+	cp := *c
+	return &cp
+}
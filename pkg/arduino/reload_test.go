@@ -0,0 +1,175 @@
+package arduino
+
+import (
+	"encoding/json"
+	"github.com/cyrilix/robocar-arduino/pkg/tools"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newReloadPart(published map[string][]byte) *Part {
+	publish = func(client mqtt.Client, topic string, qos byte, retain bool, payload []byte) mqtt.Token {
+		published[topic] = payload
+		return nil
+	}
+	return &Part{
+		configTopic:                "config",
+		configStatusTopic:          "config/status",
+		pwmSteeringConfig:          NewAsymetricPWMConfig(1004, 1986, 1495),
+		pwmThrottleConfig:          NewAsymetricPWMConfig(972, 1954, 1260),
+		pwmMaxThrottleCtrlConfig:   &DefaultPwmThrottle,
+		pwmSecondarySteeringConfig: &DefaultPwmThrottle,
+		pwmSecondaryThrottleConfig: &DefaultPwmThrottle,
+		throttleFeedbackThresholds: tools.NewThresholdConfig(),
+	}
+}
+
+func statusOf(t *testing.T, published map[string][]byte) configStatusMessage {
+	t.Helper()
+	var msg configStatusMessage
+	if err := json.Unmarshal(published["config/status"], &msg); err != nil {
+		t.Fatalf("unable to unmarshal config status message: %v", err)
+	}
+	return msg
+}
+
+func TestPart_Reload(t *testing.T) {
+	oldPublish := publish
+	defer func() { publish = oldPublish }()
+	published := make(map[string][]byte)
+	a := newReloadPart(published)
+
+	steering := NewAsymetricPWMConfig(1100, 1900, 1500)
+	thresholds := &tools.ThresholdConfig{ThresholdSteps: []float64{0, 0.5, 1}, MinValid: 500, Data: []int{3000, 2000, 1000}}
+	if err := a.Reload(&RuntimeConfig{Steering: steering, ThrottleFeedback: thresholds}); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if got := statusOf(t, published); got != (configStatusMessage{Version: 1, Applied: true}) {
+		t.Errorf("bad status %+v", got)
+	}
+	c := a.RuntimeConfig()
+	if *c.Steering != *steering {
+		t.Errorf("steering config = %v, want %v", *c.Steering, *steering)
+	}
+	if *c.Throttle != *NewAsymetricPWMConfig(972, 1954, 1260) {
+		t.Errorf("throttle config should be unchanged: %v", *c.Throttle)
+	}
+	if !reflect.DeepEqual(c.ThrottleFeedback, thresholds) {
+		t.Errorf("throttle feedback = %v, want %v", c.ThrottleFeedback, thresholds)
+	}
+	a.processSteering(1900)
+	if s := a.Steering(); s != 1. {
+		t.Errorf("Steering() = %v after reload, want %v", s, 1.)
+	}
+
+	// Invalid config is rejected and nothing is applied
+	err := a.Reload(&RuntimeConfig{
+		Version:  5,
+		Steering: NewAsymetricPWMConfig(1000, 2000, 1500),
+		Throttle: NewAsymetricPWMConfig(2000, 1000, 1500),
+	})
+	if err == nil {
+		t.Errorf("Reload() should fail with invalid throttle config")
+	}
+	if got := statusOf(t, published); got.Applied || got.Version != 5 || got.Error == "" {
+		t.Errorf("bad status for rejected config %+v", got)
+	}
+	if c := a.RuntimeConfig(); *c.Steering != *steering || c.Version != 1 {
+		t.Errorf("rejected config should not be applied: %+v", c)
+	}
+
+	if err := a.Reload(&RuntimeConfig{Version: 42}); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if got := statusOf(t, published); got != (configStatusMessage{Version: 42, Applied: true}) {
+		t.Errorf("bad status %+v", got)
+	}
+	if v := a.ConfigVersion(); v != 42 {
+		t.Errorf("ConfigVersion() = %v, want %v", v, 42)
+	}
+}
+
+func TestPart_onConfig(t *testing.T) {
+	oldPublish := publish
+	defer func() { publish = oldPublish }()
+	published := make(map[string][]byte)
+	a := newReloadPart(published)
+
+	tests := []struct {
+		name         string
+		payload      string
+		want         configStatusMessage
+		wantThrottle PWMConfig
+	}{
+		{
+			name:         "partial update",
+			payload:      `{"throttle": {"middle": 1300, "deadband": 10}}`,
+			want:         configStatusMessage{Version: 1, Applied: true},
+			wantThrottle: PWMConfig{Min: 972, Max: 1954, Middle: 1300, Deadband: 10},
+		},
+		{
+			name:         "explicit version",
+			payload:      `{"version": 7, "throttle": {"reverse": true}}`,
+			want:         configStatusMessage{Version: 7, Applied: true},
+			wantThrottle: PWMConfig{Min: 972, Max: 1954, Middle: 1300, Deadband: 10, Reverse: true},
+		},
+		{
+			name:         "invalid value",
+			payload:      `{"throttle": {"middle": 2500}}`,
+			want:         configStatusMessage{Error: "invalid throttle pwm config"},
+			wantThrottle: PWMConfig{Min: 972, Max: 1954, Middle: 1300, Deadband: 10, Reverse: true},
+		},
+		{
+			name:         "invalid json",
+			payload:      `{"throttle": `,
+			want:         configStatusMessage{Error: "invalid json"},
+			wantThrottle: PWMConfig{Min: 972, Max: 1954, Middle: 1300, Deadband: 10, Reverse: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a.onConfig(nil, &fakeMessage{topic: "config", payload: []byte(tt.payload)})
+			got := statusOf(t, published)
+			if got.Applied != tt.want.Applied || got.Version != tt.want.Version {
+				t.Errorf("bad status %+v, want %+v", got, tt.want)
+			}
+			if tt.want.Error != "" && !strings.HasPrefix(got.Error, tt.want.Error) {
+				t.Errorf("bad status error '%v', want prefix '%v'", got.Error, tt.want.Error)
+			}
+			if c := a.RuntimeConfig(); *c.Throttle != tt.wantThrottle {
+				t.Errorf("throttle config = %+v, want %+v", *c.Throttle, tt.wantThrottle)
+			}
+		})
+	}
+}
+
+func TestPart_Reload_maxThrottleCtrl(t *testing.T) {
+	oldPublish := publish
+	defer func() { publish = oldPublish }()
+	published := make(map[string][]byte)
+	a := newReloadPart(published)
+	a.maxThrottleCtrlTopic = "throttle/max"
+
+	publishedMax := func() float32 {
+		t.Helper()
+		a.processMaxThrottleCtrl(1800)
+		a.publishMaxThrottleCtrl()
+		var msg events.ThrottleMessage
+		unmarshalMsg(t, published["throttle/max"], &msg)
+		return msg.GetThrottle()
+	}
+
+	if got := publishedMax(); math.Abs(float64(got)-0.84) > 0.01 {
+		t.Errorf("published max throttle ctrl = %v before reload, want %v", got, 0.84)
+	}
+	if err := a.Reload(&RuntimeConfig{MaxThrottleCtrl: &PWMConfig{Min: 1000, Max: 2000, Middle: 1500, Reverse: true}}); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if got := publishedMax(); math.Abs(float64(got)-0.2) > 0.01 {
+		t.Errorf("published max throttle ctrl = %v after reload, want %v", got, 0.2)
+	}
+}
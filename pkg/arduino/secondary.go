@@ -0,0 +1,176 @@
+package arduino
+
+import (
+	"fmt"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+	"math"
+)
+
+const DefaultOverrideThreshold = 0.1
+
+// OverridePriority defines which transmitter drives the car when both primary (channels 1/2) and
+// secondary (channels 7/8) controls are available
+type OverridePriority string
+
+const (
+	// OverrideDisabled publishes primary controls only, secondary controls are published on their own topics
+	OverrideDisabled OverridePriority = "none"
+	// OverridePrimary uses primary controls as soon as they leave neutral position, secondary controls otherwise
+	OverridePrimary OverridePriority = "primary"
+	// OverrideSecondary uses secondary controls as soon as they leave neutral position, primary controls otherwise
+	OverrideSecondary OverridePriority = "secondary"
+)
+
+func ParseOverridePriority(v string) (OverridePriority, error) {
+	switch p := OverridePriority(v); p {
+	case OverrideDisabled, OverridePrimary, OverrideSecondary:
+		return p, nil
+	case "":
+		return OverrideDisabled, nil
+	default:
+		return OverrideDisabled, fmt.Errorf("invalid override priority '%v', should be one of %v, %v or %v", v, OverrideDisabled, OverridePrimary, OverrideSecondary)
+	}
+}
+
+func WithSecondarySteeringConfig(steeringConfig *PWMConfig) Option {
+	return func(p *Part) {
+		p.pwmSecondarySteeringConfig = steeringConfig
+	}
+}
+
+func WithSecondaryThrottleConfig(throttleConfig *PWMConfig) Option {
+	return func(p *Part) {
+		p.pwmSecondaryThrottleConfig = throttleConfig
+	}
+}
+
+func WithSecondaryTopics(steeringTopic, throttleTopic string) Option {
+	return func(p *Part) {
+		p.secondarySteeringTopic = steeringTopic
+		p.secondaryThrottleTopic = throttleTopic
+	}
+}
+
+// WithOverride configures which controls are published on steering and throttle topics, threshold is the
+// percent value under which controls are considered in neutral position
+func WithOverride(priority OverridePriority, threshold float32) Option {
+	return func(p *Part) {
+		p.overridePriority = priority
+		p.overrideThreshold = threshold
+	}
+}
+
+// processSecondarySteering decodes channel value, value is neutral when there is no pulse (second transmitter off)
+func (a *Part) processSecondarySteering(value int) {
+	zap.L().Debug("process new value for secondary steering", zap.Int("value", value))
+	a.secondarySteeringValid = value > 0
+	if !a.secondarySteeringValid {
+		a.secondarySteering = 0.
+		return
+	}
+	a.secondarySteering = a.applyCurve(RoleSecondarySteering, convertPwmToPercent(value, a.pwmSecondarySteeringConfig))
+}
+
+// processSecondaryThrottle decodes channel value, value is neutral when there is no pulse (second transmitter off)
+func (a *Part) processSecondaryThrottle(value int) {
+	zap.L().Debug("process new value for secondary throttle", zap.Int("value", value))
+	a.secondaryThrottleValid = value > 0
+	if !a.secondaryThrottleValid {
+		a.secondaryThrottle = 0.
+		return
+	}
+	a.secondaryThrottle = a.applyCurve(RoleSecondaryThrottle, convertPwmToPercent(value, a.pwmSecondaryThrottleConfig))
+}
+
+// SecondarySteering returns secondary steering, or neutral value when failsafe is engaged
+func (a *Part) SecondarySteering() float32 {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.secondarySteeringValue()
+}
+
+// secondarySteeringValue returns secondary steering to publish, or neutral value when failsafe is engaged, mutex should be
+// locked
+func (a *Part) secondarySteeringValue() float32 {
+	if a.failsafe {
+		return 0.
+	}
+	return a.secondarySteering
+}
+
+// SecondaryThrottle returns secondary throttle, or neutral value when failsafe is engaged
+func (a *Part) SecondaryThrottle() float32 {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.secondaryThrottleValue()
+}
+
+// secondaryThrottleValue returns secondary throttle to publish, or neutral value when failsafe is engaged, mutex should be
+// locked
+func (a *Part) secondaryThrottleValue() float32 {
+	if a.failsafe {
+		return 0.
+	}
+	return a.secondaryThrottle
+}
+
+// controls returns steering and throttle to use according to override priority, mutex should be locked
+func (a *Part) controls() (steering, throttle float32) {
+	primaryActive := a.outOfNeutral(a.steering, true) || a.outOfNeutral(a.throttle, true)
+	secondaryActive := a.outOfNeutral(a.secondarySteering, a.secondarySteeringValid) ||
+		a.outOfNeutral(a.secondaryThrottle, a.secondaryThrottleValid)
+
+	useSecondary := false
+	switch a.overridePriority {
+	case OverridePrimary:
+		useSecondary = !primaryActive && secondaryActive
+	case OverrideSecondary:
+		useSecondary = secondaryActive
+	}
+	if useSecondary {
+		return a.secondarySteering, a.secondaryThrottle
+	}
+	return a.steering, a.throttle
+}
+
+func (a *Part) outOfNeutral(value float32, valid bool) bool {
+	return valid && math.Abs(float64(value)) > float64(a.overrideThreshold)
+}
+
+func (a *Part) publishSecondarySteering() {
+	if a.secondarySteeringTopic == "" {
+		return
+	}
+	value, ref := a.valueWithFrameRef(a.secondarySteeringValue)
+	steering := events.SteeringMessage{
+		Steering:   value,
+		FrameRef:   ref,
+		Confidence: 1.0,
+	}
+	steeringMessage, err := proto.Marshal(&steering)
+	if err != nil {
+		zap.S().Errorf("unable to marshal protobuf secondary steering message: %v", err)
+		return
+	}
+	a.publishMessage(a.secondarySteeringTopic, steeringMessage)
+}
+
+func (a *Part) publishSecondaryThrottle() {
+	if a.secondaryThrottleTopic == "" {
+		return
+	}
+	value, ref := a.valueWithFrameRef(a.secondaryThrottleValue)
+	throttle := events.ThrottleMessage{
+		Throttle:   value,
+		FrameRef:   ref,
+		Confidence: 1.0,
+	}
+	throttleMessage, err := proto.Marshal(&throttle)
+	if err != nil {
+		zap.S().Errorf("unable to marshal protobuf secondary throttle message: %v", err)
+		return
+	}
+	a.publishMessage(a.secondaryThrottleTopic, throttleMessage)
+}
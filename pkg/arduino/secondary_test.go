@@ -0,0 +1,94 @@
+package arduino
+
+import (
+	"testing"
+)
+
+func TestPart_controls(t *testing.T) {
+	centered := "12345,1492,1463,1500,1500,1500,1500,1492,1463,0,50"
+	tests := []struct {
+		name                                         string
+		priority                                     OverridePriority
+		line                                         string
+		wantSteering, wantThrottle                   float32
+		wantSecondarySteering, wantSecondaryThrottle float32
+	}{
+		{name: "disabled, secondary active", priority: OverrideDisabled,
+			line:         "12345,1492,1463,1500,1500,1500,1500,1985,1954,0,50",
+			wantSteering: 0., wantThrottle: 0., wantSecondarySteering: 1., wantSecondaryThrottle: 1.},
+		{name: "primary priority, primary centered", priority: OverridePrimary,
+			line:         "12345,1492,1463,1500,1500,1500,1500,1985,1954,0,50",
+			wantSteering: 1., wantThrottle: 1., wantSecondarySteering: 1., wantSecondaryThrottle: 1.},
+		{name: "primary priority, primary active", priority: OverridePrimary,
+			line:         "12345,999,1463,1500,1500,1500,1500,1985,1954,0,50",
+			wantSteering: -1., wantThrottle: 0., wantSecondarySteering: 1., wantSecondaryThrottle: 1.},
+		{name: "secondary priority, secondary centered", priority: OverrideSecondary,
+			line:         "12345,999,1954,1500,1500,1500,1500,1492,1463,0,50",
+			wantSteering: -1., wantThrottle: 1., wantSecondarySteering: 0., wantSecondaryThrottle: 0.},
+		{name: "secondary priority, secondary active", priority: OverrideSecondary,
+			line:         "12345,999,1954,1500,1500,1500,1500,1492,972,0,50",
+			wantSteering: 0., wantThrottle: -1., wantSecondarySteering: 0., wantSecondaryThrottle: -1.},
+		{name: "secondary priority, secondary without pulse", priority: OverrideSecondary,
+			line:         "12345,999,1954,1500,1500,1500,1500,0,0,0,50",
+			wantSteering: -1., wantThrottle: 1., wantSecondarySteering: 0., wantSecondaryThrottle: 0.},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := Part{
+				pwmSteeringConfig:          NewAsymetricPWMConfig(MinPwmAngle, MaxPwmAngle, MiddlePwmAngle),
+				pwmThrottleConfig:          &DefaultPwmThrottle,
+				pwmSecondarySteeringConfig: NewAsymetricPWMConfig(MinPwmAngle, MaxPwmAngle, MiddlePwmAngle),
+				pwmSecondaryThrottleConfig: &DefaultPwmThrottle,
+				overridePriority:           tt.priority,
+				overrideThreshold:          DefaultOverrideThreshold,
+				channelMapping: ChannelMapping{1: RoleSteering, 2: RoleThrottle,
+					7: RoleSecondarySteering, 8: RoleSecondaryThrottle},
+			}
+			a.updateValues(mustParseLine(t, centered))
+			a.updateValues(mustParseLine(t, tt.line))
+
+			if a.Steering() != tt.wantSteering {
+				t.Errorf("bad steering, expected: %v, actual: %v", tt.wantSteering, a.Steering())
+			}
+			if a.Throttle() != tt.wantThrottle {
+				t.Errorf("bad throttle, expected: %v, actual: %v", tt.wantThrottle, a.Throttle())
+			}
+			if a.SecondarySteering() != tt.wantSecondarySteering {
+				t.Errorf("bad secondary steering, expected: %v, actual: %v", tt.wantSecondarySteering, a.SecondarySteering())
+			}
+			if a.SecondaryThrottle() != tt.wantSecondaryThrottle {
+				t.Errorf("bad secondary throttle, expected: %v, actual: %v", tt.wantSecondaryThrottle, a.SecondaryThrottle())
+			}
+		})
+	}
+}
+
+func TestPart_Secondary_failsafe(t *testing.T) {
+	a := Part{
+		pwmSecondarySteeringConfig: NewAsymetricPWMConfig(MinPwmAngle, MaxPwmAngle, MiddlePwmAngle),
+		pwmSecondaryThrottleConfig: &DefaultPwmThrottle,
+	}
+	a.processSecondarySteering(1985)
+	a.processSecondaryThrottle(1954)
+	if a.SecondarySteering() != 1. || a.SecondaryThrottle() != 1. {
+		t.Errorf("bad secondary values, steering: %v, throttle: %v", a.SecondarySteering(), a.SecondaryThrottle())
+	}
+
+	a.mutex.Lock()
+	a.failsafe = true
+	a.mutex.Unlock()
+	if a.SecondarySteering() != 0. || a.SecondaryThrottle() != 0. {
+		t.Errorf("secondary values should be neutral in failsafe, steering: %v, throttle: %v", a.SecondarySteering(), a.SecondaryThrottle())
+	}
+}
+
+func TestParseOverridePriority(t *testing.T) {
+	for _, v := range []string{"", "none", "primary", "secondary"} {
+		if _, err := ParseOverridePriority(v); err != nil {
+			t.Errorf("ParseOverridePriority(%v) error = %v", v, err)
+		}
+	}
+	if _, err := ParseOverridePriority("instructor"); err == nil {
+		t.Errorf("ParseOverridePriority(instructor) should fail")
+	}
+}
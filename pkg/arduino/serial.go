@@ -0,0 +1,212 @@
+package arduino
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cyrilix/robocar-arduino/pkg/record"
+	"github.com/tarm/serial"
+	"go.uber.org/zap"
+	"io"
+	"time"
+)
+
+const (
+	DefaultReconnectMinBackoff = 200 * time.Millisecond
+	DefaultReconnectMaxBackoff = 5 * time.Second
+)
+
+var errPartStopped = errors.New("part stopped")
+
+// SerialOpener opens the stream where arduino lines are read
+type SerialOpener func() (io.Reader, error)
+
+func NewSerialOpener(name string, baud int) SerialOpener {
+	return func() (io.Reader, error) {
+		s, err := serial.OpenPort(&serial.Config{Name: name, Baud: baud})
+		if err != nil {
+			return nil, fmt.Errorf("unable to open serial port %v: %w", name, err)
+		}
+		return s, nil
+	}
+}
+
+type LinkState int
+
+const (
+	LinkDown LinkState = iota
+	LinkUp
+)
+
+func (s LinkState) String() string {
+	switch s {
+	case LinkUp:
+		return "up"
+	default:
+		return "down"
+	}
+}
+
+func (s LinkState) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+type linkStateMessage struct {
+	State         LinkState `json:"state"`
+	Reconnections int       `json:"reconnections"`
+	Error         string    `json:"error,omitempty"`
+}
+
+func WithSerialOpener(opener SerialOpener) Option {
+	return func(p *Part) {
+		p.serialOpener = opener
+	}
+}
+
+// WithSerial reads arduino lines from r instead of serial port, part stops at end of stream
+func WithSerial(r io.Reader) Option {
+	return func(p *Part) {
+		p.serial = r
+		p.serialOpener = nil
+	}
+}
+
+// WithRecorder writes raw serial stream with host timestamps to w, see record package for format
+func WithRecorder(w io.Writer) Option {
+	return func(p *Part) {
+		p.recorder = w
+	}
+}
+
+func WithReconnectBackoff(min, max time.Duration) Option {
+	return func(p *Part) {
+		p.reconnectMinBackoff = min
+		p.reconnectMaxBackoff = max
+	}
+}
+
+func WithLinkStateTopic(topic string) Option {
+	return func(p *Part) {
+		p.linkStateTopic = topic
+	}
+}
+
+// connect opens serial stream, retrying with exponential backoff until success or part stopped
+func (a *Part) connect() (io.Reader, error) {
+	a.serialMutex.Lock()
+	s := a.serial
+	a.serialMutex.Unlock()
+	if s != nil {
+		a.setLinkState(LinkUp, nil)
+		return s, nil
+	}
+	if a.serialOpener == nil {
+		return nil, fmt.Errorf("no serial opener configured")
+	}
+
+	backoff := a.reconnectMinBackoff
+	for {
+		s, err := a.serialOpener()
+		if err == nil {
+			if a.recorder != nil {
+				s = record.NewRecorder(s, a.recorder)
+			}
+			a.serialMutex.Lock()
+			// Stop may have closed previous serial link while port was opening, it won't close this one
+			if a.stopped() {
+				a.serialMutex.Unlock()
+				if c, ok := s.(io.Closer); ok {
+					if err := c.Close(); err != nil {
+						zap.S().Warnf("unable to close serial link: %v", err)
+					}
+				}
+				return nil, errPartStopped
+			}
+			a.serial = s
+			a.serialMutex.Unlock()
+			a.setLinkState(LinkUp, nil)
+			return s, nil
+		}
+		zap.S().Warnf("unable to open serial link, retry in %v: %v", backoff, err)
+		a.setLinkState(LinkDown, err)
+
+		select {
+		case <-a.cancel:
+			return nil, errPartStopped
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > a.reconnectMaxBackoff {
+			backoff = a.reconnectMaxBackoff
+		}
+	}
+}
+
+// disconnect closes current serial stream, a new one will be opened on next connect
+func (a *Part) disconnect(cause error) {
+	a.serialMutex.Lock()
+	s := a.serial
+	a.serial = nil
+	a.serialMutex.Unlock()
+
+	a.mutex.Lock()
+	a.resetFilters()
+	a.mutex.Unlock()
+
+	a.setLinkState(LinkDown, cause)
+	if c, ok := s.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			zap.S().Warnf("unable to close serial link: %v", err)
+		}
+	}
+}
+
+func (a *Part) stopped() bool {
+	select {
+	case <-a.cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+func (a *Part) LinkState() LinkState {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.linkState
+}
+
+func (a *Part) setLinkState(state LinkState, cause error) {
+	a.mutex.Lock()
+	if a.linkState == state {
+		a.mutex.Unlock()
+		return
+	}
+	a.linkState = state
+	if state == LinkUp && a.linkConnected {
+		a.reconnections += 1
+	}
+	if state == LinkUp {
+		a.linkConnected = true
+	}
+	msg := linkStateMessage{State: state, Reconnections: a.reconnections}
+	a.mutex.Unlock()
+
+	if cause != nil {
+		msg.Error = cause.Error()
+	}
+	zap.S().Infof("serial link %v", state)
+	a.publishLinkState(&msg)
+}
+
+func (a *Part) publishLinkState(msg *linkStateMessage) {
+	if a.linkStateTopic == "" {
+		return
+	}
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		zap.S().Errorf("unable to marshal link state message: %v", err)
+		return
+	}
+	a.publishMessage(a.linkStateTopic, payload)
+}
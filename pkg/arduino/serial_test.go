@@ -0,0 +1,200 @@
+package arduino
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cyrilix/robocar-arduino/pkg/record"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func waitFor(t *testing.T, desc string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %v", desc)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPart_Reconnect(t *testing.T) {
+	oldPublish := publish
+	defer func() { publish = oldPublish }()
+
+	var muLinkStates sync.Mutex
+	var linkStates []string
+	publish = func(client mqtt.Client, topic string, qos byte, retain bool, payload []byte) mqtt.Token {
+		if topic != "car/part/arduino/link" {
+			return nil
+		}
+		var msg struct {
+			State         string `json:"state"`
+			Reconnections int    `json:"reconnections"`
+		}
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			t.Errorf("unable to unmarshal link state: %v", err)
+		}
+		muLinkStates.Lock()
+		defer muLinkStates.Unlock()
+		linkStates = append(linkStates, fmt.Sprintf("%v/%d", msg.State, msg.Reconnections))
+		return nil
+	}
+
+	conns := make(chan net.Conn, 2)
+	openings := 0
+	opener := func() (io.Reader, error) {
+		openings += 1
+		if openings == 1 {
+			return nil, errors.New("no such device")
+		}
+		server, client := net.Pipe()
+		conns <- client
+		return server, nil
+	}
+
+	a := NewPart(nil, "/dev/null", 115200, "", "", "", "", "", "", 100,
+		WithSerialOpener(opener),
+		WithReconnectBackoff(time.Millisecond, 5*time.Millisecond),
+		WithLinkStateTopic("car/part/arduino/link"),
+	)
+	a.pwmSteeringConfig = NewAsymetricPWMConfig(MinPwmAngle, MaxPwmAngle, MiddlePwmAngle)
+	go func() {
+		if err := a.Start(); err != nil {
+			t.Errorf("unable to start part: %v", err)
+		}
+	}()
+	defer a.Stop()
+
+	conn := <-conns
+	if _, err := conn.Write([]byte("12345,1985,1500,1500,1500,1500,1500,0,0,0,50\n")); err != nil {
+		t.Fatalf("unable to write line: %v", err)
+	}
+	waitFor(t, "steering from first link", func() bool { return a.Steering() == 1. })
+
+	if err := conn.Close(); err != nil {
+		t.Errorf("unable to close first link: %v", err)
+	}
+
+	conn = <-conns
+	defer conn.Close()
+	if _, err := conn.Write([]byte("12350,999,1500,1500,1500,1500,1500,0,0,0,50\n")); err != nil {
+		t.Fatalf("unable to write line: %v", err)
+	}
+	waitFor(t, "steering from second link", func() bool { return a.Steering() == -1. })
+
+	if a.LinkState() != LinkUp {
+		t.Errorf("bad link state, expected: %v, actual: %v", LinkUp, a.LinkState())
+	}
+	muLinkStates.Lock()
+	defer muLinkStates.Unlock()
+	expected := fmt.Sprintf("%v", []string{"up/0", "down/0", "up/1"})
+	if fmt.Sprintf("%v", linkStates) != expected {
+		t.Errorf("bad link states published, expected: %v, actual: %v", expected, linkStates)
+	}
+}
+
+func TestPart_StopWhileOpening(t *testing.T) {
+	oldPublish := publish
+	defer func() { publish = oldPublish }()
+	publish = func(client mqtt.Client, topic string, qos byte, retain bool, payload []byte) mqtt.Token { return nil }
+
+	opening := make(chan struct{})
+	release := make(chan struct{})
+	server, conn := net.Pipe()
+	defer conn.Close()
+	a := NewPart(nil, "/dev/null", 115200, "", "", "", "", "", "", 100,
+		WithSerialOpener(func() (io.Reader, error) {
+			close(opening)
+			<-release
+			return server, nil
+		}),
+	)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := a.Start(); err != nil {
+			t.Errorf("unable to start part: %v", err)
+		}
+	}()
+
+	<-opening
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		a.Stop()
+	}()
+	waitFor(t, "part stopped", a.stopped)
+	close(release)
+
+	for desc, c := range map[string]chan struct{}{"start": done, "stop": stopped} {
+		select {
+		case <-c:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%v should return when part is stopped while port is opening", desc)
+		}
+	}
+	if _, err := conn.Write([]byte("12345,1500,1500,1500,1500,1500,1500,0,0,0,50\n")); err == nil {
+		t.Errorf("serial port opened after stop should be closed")
+	}
+	if a.LinkState() != LinkDown {
+		t.Errorf("bad link state, expected: %v, actual: %v", LinkDown, a.LinkState())
+	}
+}
+
+func TestPart_RecordReplay(t *testing.T) {
+	oldPublish := publish
+	defer func() { publish = oldPublish }()
+	publish = func(client mqtt.Client, topic string, qos byte, retain bool, payload []byte) mqtt.Token { return nil }
+
+	var session bytes.Buffer
+	server, conn := net.Pipe()
+	a := NewPart(nil, "/dev/null", 115200, "", "", "", "", "", "", 100,
+		WithSerialOpener(func() (io.Reader, error) { return server, nil }),
+		WithRecorder(&session),
+	)
+	a.pwmSteeringConfig = NewAsymetricPWMConfig(MinPwmAngle, MaxPwmAngle, MiddlePwmAngle)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := a.Start(); err != nil {
+			t.Errorf("unable to start part: %v", err)
+		}
+	}()
+
+	for _, line := range []string{
+		"12345,1500,1500,1500,1500,1500,1500,0,0,0,50\n",
+		"12350,1985,1500,1500,1500,1500,1900,0,0,0,50\n",
+	} {
+		if _, err := conn.Write([]byte(line)); err != nil {
+			t.Fatalf("unable to write line: %v", err)
+		}
+	}
+	waitFor(t, "recorded steering", func() bool { return a.Steering() == 1. })
+	a.Stop()
+	<-done
+
+	replay := NewPart(nil, "", 0, "", "", "", "", "", "", 100,
+		WithSerial(record.NewReplayer(&session, 0)),
+	)
+	replay.pwmSteeringConfig = NewAsymetricPWMConfig(MinPwmAngle, MaxPwmAngle, MiddlePwmAngle)
+	if err := replay.Start(); err != nil {
+		t.Errorf("unable to replay session: %v", err)
+	}
+	defer replay.Stop()
+
+	if replay.Steering() != 1. {
+		t.Errorf("bad replayed steering, expected: %v, actual: %v", 1., replay.Steering())
+	}
+	if replay.DriveMode() != events.DriveMode_PILOT {
+		t.Errorf("bad replayed drive mode, expected: %v, actual: %v", events.DriveMode_PILOT, replay.DriveMode())
+	}
+}
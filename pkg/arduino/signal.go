@@ -0,0 +1,74 @@
+package arduino
+
+import (
+	"encoding/json"
+	"go.uber.org/zap"
+	"time"
+)
+
+const DefaultSignalInterval = time.Second
+
+type signalMessage struct {
+	Frequency    int     `json:"frequency"`
+	MinFrequency int     `json:"min_frequency,omitempty"`
+	FrameRate    float64 `json:"frame_rate"`
+	Degraded     bool    `json:"degraded"`
+}
+
+// WithSignalTopic sets mqtt topic where RC signal quality is published
+func WithSignalTopic(topic string) Option {
+	return func(p *Part) {
+		p.signalTopic = topic
+	}
+}
+
+// WithSignalInterval sets delay between two RC signal quality messages
+func WithSignalInterval(interval time.Duration) Option {
+	return func(p *Part) {
+		p.signalInterval = interval
+	}
+}
+
+// SignalDegraded returns true if RC signal frame rate reported by arduino is below failsafe min frequency
+func (a *Part) SignalDegraded() bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return !a.lowFrequencySince.IsZero()
+}
+
+func (a *Part) signalLoop() {
+	interval := a.signalInterval
+	if interval <= 0 {
+		interval = DefaultSignalInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.publishSignal()
+		case <-a.cancel:
+			return
+		}
+	}
+}
+
+func (a *Part) publishSignal() {
+	if a.signalTopic == "" {
+		return
+	}
+	msg := signalMessage{
+		Frequency: a.Frequency(),
+		FrameRate: a.FrameRate(),
+		Degraded:  a.SignalDegraded(),
+	}
+	if a.failsafeConfig != nil {
+		msg.MinFrequency = a.failsafeConfig.MinFrequency
+	}
+	payload, err := json.Marshal(&msg)
+	if err != nil {
+		zap.S().Errorf("unable to marshal signal message: %v", err)
+		return
+	}
+	a.publishMessage(a.signalTopic, payload)
+}
@@ -0,0 +1,53 @@
+package arduino
+
+import (
+	"encoding/json"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"testing"
+	"time"
+)
+
+func TestPart_publishSignal(t *testing.T) {
+	oldPublish := publish
+	defer func() { publish = oldPublish }()
+	published := make(map[string][]byte)
+	publish = func(client mqtt.Client, topic string, qos byte, retain bool, payload []byte) mqtt.Token {
+		published[topic] = payload
+		return nil
+	}
+
+	a := Part{
+		signalTopic:    "signal",
+		failsafeConfig: &FailsafeConfig{MinFrequency: 40},
+	}
+	tests := []struct {
+		line string
+		want signalMessage
+	}{
+		{line: "12345,1500,1500,1500,1500,1500,1500,0,0,0,50\n", want: signalMessage{Frequency: 50, MinFrequency: 40}},
+		{line: "12365,1500,1500,1500,1500,1500,1500,0,0,0,12\n", want: signalMessage{Frequency: 12, MinFrequency: 40, Degraded: true}},
+		{line: "12385,1500,1500,1500,1500,1500,1500,0,0,0,49\n", want: signalMessage{Frequency: 49, MinFrequency: 40}},
+	}
+	for _, tt := range tests {
+		f := mustParseLine(t, tt.line)
+		now := time.Now()
+		a.mutex.Lock()
+		a.recordChannels(f, now)
+		a.updateStats(f, now)
+		a.mutex.Unlock()
+
+		a.publishSignal()
+		var msg signalMessage
+		if err := json.Unmarshal(published["signal"], &msg); err != nil {
+			t.Fatalf("unable to unmarshal signal message: %v", err)
+		}
+		// Frame rate depends on time, ignore it
+		msg.FrameRate = 0
+		if msg != tt.want {
+			t.Errorf("bad signal message for line %v, expected: %+v, actual: %+v", tt.line, tt.want, msg)
+		}
+		if a.Frequency() != tt.want.Frequency {
+			t.Errorf("Frequency() = %v, want %v", a.Frequency(), tt.want.Frequency)
+		}
+	}
+}
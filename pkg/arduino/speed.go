@@ -0,0 +1,69 @@
+package arduino
+
+import (
+	"encoding/json"
+	"github.com/cyrilix/robocar-arduino/pkg/speed"
+	"go.uber.org/zap"
+)
+
+// WithSpeedEstimator converts throttle feedback values to motor RPM and wheel speed, and integrates travelled distance
+func WithSpeedEstimator(config *speed.Config) Option {
+	return func(p *Part) {
+		if config == nil {
+			p.speedEstimator = nil
+			return
+		}
+		p.speedEstimator = speed.NewEstimator(*config)
+	}
+}
+
+// WithSpeedTopic sets mqtt topic where speed and travelled distance are published, as json speed.Measure
+func WithSpeedTopic(topic string) Option {
+	return func(p *Part) {
+		p.speedTopic = topic
+	}
+}
+
+// updateSpeed estimates speed from throttle feedback value, part mutex is locked by caller
+func (a *Part) updateSpeed(period int) {
+	if a.speedEstimator == nil {
+		return
+	}
+	a.speedEstimator.Update(period, a.decodeTime)
+}
+
+// Speed returns last speed estimation, zero measure if no speed estimator is configured
+func (a *Part) Speed() speed.Measure {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.speedEstimator == nil {
+		return speed.Measure{}
+	}
+	return a.speedEstimator.Measure()
+}
+
+// Odometry returns distance in meters travelled since start or last call to ResetOdometry
+func (a *Part) Odometry() float64 {
+	return a.Speed().Distance
+}
+
+func (a *Part) ResetOdometry() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.speedEstimator != nil {
+		a.speedEstimator.ResetDistance()
+	}
+}
+
+func (a *Part) publishSpeed() {
+	if a.speedTopic == "" || a.speedEstimator == nil {
+		return
+	}
+	m := a.Speed()
+	payload, err := json.Marshal(&m)
+	if err != nil {
+		zap.S().Errorf("unable to marshal speed message: %v", err)
+		return
+	}
+	a.publishMessage(a.speedTopic, payload)
+}
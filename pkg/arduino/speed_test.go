@@ -0,0 +1,79 @@
+package arduino
+
+import (
+	"encoding/json"
+	"github.com/cyrilix/robocar-arduino/pkg/frame"
+	"github.com/cyrilix/robocar-arduino/pkg/speed"
+	"github.com/cyrilix/robocar-arduino/pkg/tools"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPart_Speed(t *testing.T) {
+	oldPublish := publish
+	defer func() { publish = oldPublish }()
+	published := make(map[string][]byte)
+	publish = func(client mqtt.Client, topic string, qos byte, retain bool, payload []byte) mqtt.Token {
+		published[topic] = payload
+		return nil
+	}
+
+	a := NewPart(nil, "", 0, "", "", "", "", "", "", 25,
+		WithSpeedEstimator(&speed.Config{PoleCount: 4, GearRatio: 10, WheelDiameter: 0.065}),
+		WithSpeedTopic("speed"),
+		WithThrottleFeedbackThresholds(tools.NewThresholdConfig()),
+	)
+	if m := a.Speed(); m != (speed.Measure{}) {
+		t.Errorf("no speed expected before first frame: %+v", m)
+	}
+
+	// 1s at 30000 motor rpm
+	start := time.Unix(1000, 0)
+	for i := 0; i <= 50; i++ {
+		f := frame.Frame{Channels: [frame.ChannelCount]int{1500, 1500, 1500, 1000, 1000, 1000, 1500, 1500, 50}}
+		a.mutex.Lock()
+		a.decodeChannels(&f, start.Add(time.Duration(i)*20*time.Millisecond))
+		a.mutex.Unlock()
+	}
+	wantSpeed := 50 * math.Pi * 0.065
+	m := a.Speed()
+	if m.MotorRPM != 30000 || m.WheelRPM != 3000 || math.Abs(m.Speed-wantSpeed) > 1e-9 {
+		t.Errorf("bad speed %+v", m)
+	}
+	if math.Abs(a.Odometry()-wantSpeed) > 1e-6 {
+		t.Errorf("Odometry() = %v, want %v", a.Odometry(), wantSpeed)
+	}
+
+	a.publishSpeed()
+	var msg speed.Measure
+	if err := json.Unmarshal(published["speed"], &msg); err != nil {
+		t.Fatalf("unable to unmarshal speed message: %v", err)
+	}
+	if msg != m {
+		t.Errorf("bad speed message %+v, want %+v", msg, m)
+	}
+
+	a.ResetOdometry()
+	if d := a.Odometry(); d != 0 {
+		t.Errorf("Odometry() = %v after reset, want 0", d)
+	}
+}
+
+func TestPart_Speed_disabled(t *testing.T) {
+	oldPublish := publish
+	defer func() { publish = oldPublish }()
+	publish = func(client mqtt.Client, topic string, qos byte, retain bool, payload []byte) mqtt.Token {
+		t.Errorf("unexpected message on topic %v", topic)
+		return nil
+	}
+
+	a := NewPart(nil, "", 0, "", "", "", "", "", "", 25, WithSpeedTopic("speed"))
+	a.processThrottleFeedback(1000)
+	a.ResetOdometry()
+	if m := a.Speed(); m != (speed.Measure{}) {
+		t.Errorf("no speed expected without estimator: %+v", m)
+	}
+	a.publishSpeed()
+}
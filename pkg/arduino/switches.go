@@ -0,0 +1,147 @@
+package arduino
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"time"
+)
+
+// SwitchConfig configures decoding of drive mode (channel 6) and record (channel 5) switches
+type SwitchConfig struct {
+	// DriveModes are the positions of drive mode switch, DefaultDriveModeTable if empty
+	DriveModes DriveModeTable
+	// RecordMin is the min pwm value of record switch to enable record
+	RecordMin int
+	// DriveModeHysteresis and RecordHysteresis are the pwm bands around thresholds where current position is kept
+	DriveModeHysteresis, RecordHysteresis int
+	// DriveModeDebounce and RecordDebounce are the min durations a new position must be stable before to be applied
+	DriveModeDebounce, RecordDebounce time.Duration
+}
+
+// DefaultSwitchConfig switches drive mode at 1200/1800 and record at 1800, without hysteresis nor debounce
+var DefaultSwitchConfig = SwitchConfig{
+	DriveModes: DefaultDriveModeTable,
+	RecordMin:  1800,
+}
+
+func (c *SwitchConfig) Validate() error {
+	table := c.driveModes()
+	if err := table.Validate(); err != nil {
+		return err
+	}
+	if c.DriveModeHysteresis < 0 || c.RecordHysteresis < 0 {
+		return fmt.Errorf("hysteresis can't be negative")
+	}
+	// First and last ranges are unbounded
+	for i := 1; i < len(table)-1; i++ {
+		if r := table[i]; 2*c.DriveModeHysteresis > r.Max-r.Min {
+			return fmt.Errorf("drive mode hysteresis %d is too large for range [%d, %d] of mode %v", c.DriveModeHysteresis, r.Min, r.Max, r.Mode)
+		}
+	}
+	if c.DriveModeDebounce < 0 || c.RecordDebounce < 0 {
+		return fmt.Errorf("debounce can't be negative")
+	}
+	return nil
+}
+
+func (c *SwitchConfig) driveModes() DriveModeTable {
+	if len(c.DriveModes) == 0 {
+		return DefaultDriveModeTable
+	}
+	return c.DriveModes
+}
+
+func WithSwitchConfig(config *SwitchConfig) Option {
+	return func(p *Part) {
+		p.switchConfig = config
+	}
+}
+
+func (a *Part) switches() *SwitchConfig {
+	if a.switchConfig == nil {
+		return &DefaultSwitchConfig
+	}
+	return a.switchConfig
+}
+
+// switchPosition returns the position of a switch, thresholds are the min values of positions 1..n. To leave current
+// position (-1 if unknown), value must cross threshold by more than hysteresis.
+func switchPosition(value int, thresholds []int, current int, hysteresis int) int {
+	position := 0
+	for i, t := range thresholds {
+		if current > i {
+			t -= hysteresis
+		} else if current >= 0 {
+			t += hysteresis
+		}
+		if value >= t {
+			position = i + 1
+		}
+	}
+	return position
+}
+
+// debouncer keeps switch position and delays its changes until new position is stable
+type debouncer struct {
+	initialized bool
+	current     int
+	pending     int
+	since       time.Time
+}
+
+// position returns current position, -1 if unknown
+func (d *debouncer) position() int {
+	if !d.initialized {
+		return -1
+	}
+	return d.current
+}
+
+// update applies position as current one once stable for delay. First position is applied immediately.
+func (d *debouncer) update(position int, now time.Time, delay time.Duration) {
+	if d.initialized && position == d.current {
+		d.since = time.Time{}
+		return
+	}
+	if d.initialized && delay > 0 {
+		if d.since.IsZero() || position != d.pending {
+			d.pending, d.since = position, now
+		}
+		if now.Sub(d.since) < delay {
+			return
+		}
+	}
+	d.initialized = true
+	d.current = position
+	d.since = time.Time{}
+}
+
+func (a *Part) processSwitchRecord(value int) {
+	zap.L().Debug("process new value for switch record", zap.Int("value", value))
+	c := a.switches()
+
+	position := switchPosition(value, []int{c.RecordMin}, a.recordDebouncer.position(), c.RecordHysteresis)
+	a.recordDebouncer.update(position, a.decodeTime, c.RecordDebounce)
+	ctrlRecord := a.recordDebouncer.position() == 0
+	if ctrlRecord != a.ctrlRecord {
+		zap.S().Infof("Update switch record with value %v, record: %v", ctrlRecord, !ctrlRecord)
+	}
+	a.ctrlRecord = ctrlRecord
+}
+
+func (a *Part) processDriveMode(value int) {
+	zap.L().Debug("process new value for drive-mode", zap.Int("value", value))
+	if value < 0 {
+		// No value, ignore it
+		return
+	}
+	c := a.switches()
+
+	table := c.driveModes()
+	position := switchPosition(value, table.thresholds(), a.driveModeDebouncer.position(), c.DriveModeHysteresis)
+	a.driveModeDebouncer.update(position, a.decodeTime, c.DriveModeDebounce)
+	if mode := table[a.driveModeDebouncer.position()].Mode; a.driveMode != mode {
+		zap.S().Infof("Update 'drive-mode' with value %v, new user_mode: %v", value, mode)
+		a.driveMode = mode
+	}
+}
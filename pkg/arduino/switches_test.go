@@ -0,0 +1,177 @@
+package arduino
+
+import (
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"testing"
+	"time"
+)
+
+func TestSwitchConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  SwitchConfig
+		wantErr bool
+	}{
+		{name: "default", config: DefaultSwitchConfig},
+		{name: "hysteresis", config: SwitchConfig{DriveModes: NewDriveModeTable(1200, 1800), RecordMin: 1800, DriveModeHysteresis: 50, RecordHysteresis: 50}},
+		{name: "inverted thresholds", config: SwitchConfig{DriveModes: NewDriveModeTable(1800, 1200)}, wantErr: true},
+		{name: "too large hysteresis", config: SwitchConfig{DriveModes: NewDriveModeTable(1200, 1800), DriveModeHysteresis: 300}, wantErr: true},
+		{name: "negative hysteresis", config: SwitchConfig{DriveModes: NewDriveModeTable(1200, 1800), RecordHysteresis: -1}, wantErr: true},
+		{name: "negative debounce", config: SwitchConfig{DriveModes: NewDriveModeTable(1200, 1800), DriveModeDebounce: -time.Second}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPart_processDriveMode(t *testing.T) {
+	type step struct {
+		at    time.Duration
+		value int
+		want  events.DriveMode
+	}
+	tests := []struct {
+		name   string
+		config *SwitchConfig
+		steps  []step
+	}{
+		{
+			name: "default thresholds",
+			steps: []step{
+				{value: -1, want: events.DriveMode_INVALID},
+				{value: 1000, want: events.DriveMode_USER},
+				{value: 1201, want: events.DriveMode_COPILOT},
+				{value: 1200, want: events.DriveMode_USER},
+				{value: 1800, want: events.DriveMode_COPILOT},
+				{value: 1801, want: events.DriveMode_PILOT},
+			},
+		},
+		{
+			name:   "hysteresis",
+			config: &SwitchConfig{DriveModeHysteresis: 50},
+			steps: []step{
+				{value: 1230, want: events.DriveMode_COPILOT},
+				{value: 1190, want: events.DriveMode_COPILOT},
+				{value: 1151, want: events.DriveMode_COPILOT},
+				{value: 1150, want: events.DriveMode_USER},
+				{value: 1210, want: events.DriveMode_USER},
+				{value: 1251, want: events.DriveMode_COPILOT},
+				{value: 1830, want: events.DriveMode_COPILOT},
+				{value: 1851, want: events.DriveMode_PILOT},
+				{value: 1760, want: events.DriveMode_PILOT},
+				{value: 1000, want: events.DriveMode_USER},
+			},
+		},
+		{
+			name: "6 positions switch",
+			config: &SwitchConfig{DriveModes: DriveModeTable{
+				{Min: 900, Max: 1099, Mode: events.DriveMode_USER},
+				{Min: 1100, Max: 1299, Mode: events.DriveMode_COPILOT},
+				{Min: 1300, Max: 1499, Mode: events.DriveMode_PILOT},
+				{Min: 1500, Max: 1699, Mode: events.DriveMode_USER},
+				{Min: 1700, Max: 1899, Mode: events.DriveMode_INVALID},
+				{Min: 1900, Max: 2100, Mode: events.DriveMode_PILOT},
+			}, DriveModeHysteresis: 20},
+			steps: []step{
+				{value: 800, want: events.DriveMode_USER},
+				{value: 1200, want: events.DriveMode_COPILOT},
+				{value: 1400, want: events.DriveMode_PILOT},
+				{value: 1600, want: events.DriveMode_USER},
+				{value: 1690, want: events.DriveMode_USER},
+				{value: 1720, want: events.DriveMode_INVALID},
+				{value: 1950, want: events.DriveMode_PILOT},
+				{value: 1600, want: events.DriveMode_USER},
+				{value: 1485, want: events.DriveMode_USER},
+				{value: 1479, want: events.DriveMode_PILOT},
+				{value: 2500, want: events.DriveMode_PILOT},
+			},
+		},
+		{
+			name:   "debounce",
+			config: &SwitchConfig{DriveModeDebounce: 100 * time.Millisecond},
+			steps: []step{
+				{at: 0, value: 1000, want: events.DriveMode_USER},
+				{at: 20 * time.Millisecond, value: 1500, want: events.DriveMode_USER},
+				{at: 40 * time.Millisecond, value: 1000, want: events.DriveMode_USER},
+				{at: 60 * time.Millisecond, value: 1500, want: events.DriveMode_USER},
+				{at: 100 * time.Millisecond, value: 1900, want: events.DriveMode_USER},
+				{at: 180 * time.Millisecond, value: 1900, want: events.DriveMode_USER},
+				{at: 200 * time.Millisecond, value: 1900, want: events.DriveMode_PILOT},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := Part{switchConfig: tt.config}
+			start := time.Now()
+			for _, s := range tt.steps {
+				a.decodeTime = start.Add(s.at)
+				a.processDriveMode(s.value)
+				if a.driveMode != s.want {
+					t.Errorf("at %v, bad drive mode for value %v, expected: %v, actual: %v", s.at, s.value, s.want, a.driveMode)
+				}
+			}
+		})
+	}
+}
+
+func TestPart_processSwitchRecord(t *testing.T) {
+	type step struct {
+		at    time.Duration
+		value int
+		want  bool
+	}
+	tests := []struct {
+		name   string
+		config *SwitchConfig
+		steps  []step
+	}{
+		{
+			name: "default threshold",
+			steps: []step{
+				{value: 1900, want: false},
+				{value: 1799, want: true},
+				{value: 1800, want: false},
+			},
+		},
+		{
+			name:   "hysteresis",
+			config: &SwitchConfig{RecordMin: 1800, RecordHysteresis: 50},
+			steps: []step{
+				{value: 1790, want: true},
+				{value: 1840, want: true},
+				{value: 1850, want: false},
+				{value: 1760, want: false},
+				{value: 1749, want: true},
+			},
+		},
+		{
+			name:   "debounce",
+			config: &SwitchConfig{RecordMin: 1800, RecordDebounce: 50 * time.Millisecond},
+			steps: []step{
+				{at: 0, value: 1000, want: true},
+				{at: 20 * time.Millisecond, value: 1900, want: true},
+				{at: 40 * time.Millisecond, value: 1000, want: true},
+				{at: 60 * time.Millisecond, value: 1900, want: true},
+				{at: 110 * time.Millisecond, value: 1900, want: false},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := Part{switchConfig: tt.config}
+			start := time.Now()
+			for _, s := range tt.steps {
+				a.decodeTime = start.Add(s.at)
+				a.processSwitchRecord(s.value)
+				if a.ctrlRecord != s.want {
+					t.Errorf("at %v, bad switch record for value %v, expected: %v, actual: %v", s.at, s.value, s.want, a.ctrlRecord)
+				}
+			}
+		})
+	}
+}
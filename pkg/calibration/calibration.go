@@ -0,0 +1,351 @@
+// Package calibration measures pwm ranges of RC transmitter channels from frames sent by arduino.
+//
+// A calibration session is a list of steps where user moves sticks and switches while channel values are
+// recorded. Pwm configs and drive mode table are computed from recorded values, with outlier rejection, and
+// merged into rc-arduino json config file.
+//
+// Throttle feedback thresholds are fitted on a recorded session where throttle was held at several constant values.
+package calibration
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cyrilix/robocar-arduino/pkg/arduino"
+	"github.com/cyrilix/robocar-arduino/pkg/frame"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	StepCenter    = "center"
+	StepSteering  = "steering"
+	StepThrottle  = "throttle"
+	StepDriveMode = "drive-mode"
+
+	DefaultStepDuration = 5 * time.Second
+
+	// minValidPWM and maxValidPWM bound pwm values of valid pulses, others are ignored
+	minValidPWM = 500
+	maxValidPWM = 2500
+	// outlierRatio is the ratio of lowest and highest values ignored to compute channel range
+	outlierRatio = 0.02
+	// minPositionGap is the min pwm difference between 2 positions of switch
+	minPositionGap = 100
+	// minPositionRatio is the min ratio of values to detect a switch position
+	minPositionRatio = 0.05
+)
+
+// Step is a calibration step where channels of roles are recorded while user follows instruction
+type Step struct {
+	Name        string
+	Instruction string
+	Roles       []arduino.ChannelRole
+}
+
+// DefaultSteps are the steps required to compute Config
+var DefaultSteps = []Step{
+	{
+		Name:        StepCenter,
+		Instruction: "center sticks and release them",
+		Roles:       []arduino.ChannelRole{arduino.RoleSteering, arduino.RoleThrottle},
+	},
+	{
+		Name:        StepSteering,
+		Instruction: "move steering to full left and full right, several times",
+		Roles:       []arduino.ChannelRole{arduino.RoleSteering},
+	},
+	{
+		Name:        StepThrottle,
+		Instruction: "move throttle to full throttle and full brake, several times",
+		Roles:       []arduino.ChannelRole{arduino.RoleThrottle},
+	},
+	{
+		Name:        StepDriveMode,
+		Instruction: "cycle drive mode switch through all its positions, several times",
+		Roles:       []arduino.ChannelRole{arduino.RoleDriveMode},
+	},
+}
+
+// Config is the result of calibration, merged into rc-arduino config file
+type Config struct {
+	Steering   *arduino.PWMConfig
+	Throttle   *arduino.PWMConfig
+	DriveModes arduino.DriveModeTable
+}
+
+// Merge writes calibrated values into pwm and drive_modes sections of json config file loaded by rc-arduino with
+// --config. Other values of file are kept, file is created if it doesn't exist.
+func (c *Config) Merge(fileName string) error {
+	values := make(map[string]interface{})
+	content, err := os.ReadFile(fileName)
+	switch {
+	case err == nil:
+		dec := json.NewDecoder(bytes.NewReader(content))
+		dec.UseNumber()
+		if err := dec.Decode(&values); err != nil {
+			return fmt.Errorf("unable to unmarshal json content from %s file: %w", fileName, err)
+		}
+	case errors.Is(err, fs.ErrNotExist):
+	default:
+		return fmt.Errorf("unable to read content from %s file: %w", fileName, err)
+	}
+
+	pwm, err := section(values, "pwm")
+	if err != nil {
+		return fmt.Errorf("invalid config in %s file: %w", fileName, err)
+	}
+	for name, pwmConfig := range map[string]*arduino.PWMConfig{"steering": c.Steering, "throttle": c.Throttle} {
+		if pwmConfig == nil {
+			continue
+		}
+		s, err := section(pwm, name)
+		if err != nil {
+			return fmt.Errorf("invalid pwm config in %s file: %w", fileName, err)
+		}
+		s["min"], s["max"], s["middle"] = pwmConfig.Min, pwmConfig.Max, pwmConfig.Middle
+	}
+	if c.DriveModes != nil {
+		values["drive_modes"] = c.DriveModes
+	}
+
+	content, err = json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to marshal config: %w", err)
+	}
+	if err := os.WriteFile(fileName, append(content, '\n'), 0o644); err != nil {
+		return fmt.Errorf("unable to write config to %s file: %w", fileName, err)
+	}
+	return nil
+}
+
+// section returns json object of values at key, object is created if missing
+func section(values map[string]interface{}, key string) (map[string]interface{}, error) {
+	v, ok := values[key]
+	if !ok || v == nil {
+		s := make(map[string]interface{})
+		values[key] = s
+		return s, nil
+	}
+	s, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%v should be a json object", key)
+	}
+	return s, nil
+}
+
+func (c *Config) Validate() error {
+	if c.Steering != nil {
+		if err := c.Steering.Validate(); err != nil {
+			return fmt.Errorf("invalid steering config: %w", err)
+		}
+	}
+	if c.Throttle != nil {
+		if err := c.Throttle.Validate(); err != nil {
+			return fmt.Errorf("invalid throttle config: %w", err)
+		}
+	}
+	if c.DriveModes != nil {
+		if err := c.DriveModes.Validate(); err != nil {
+			return fmt.Errorf("invalid drive mode table: %w", err)
+		}
+	}
+	return nil
+}
+
+// Session records channel values of each step
+type Session struct {
+	mutex   sync.Mutex
+	mapping arduino.ChannelMapping
+	step    *Step
+	samples map[string]map[arduino.ChannelRole][]int
+}
+
+func NewSession(mapping arduino.ChannelMapping) *Session {
+	return &Session{mapping: mapping, samples: make(map[string]map[arduino.ChannelRole][]int)}
+}
+
+// Begin starts recording of step, values of previous recording of this step are dropped
+func (s *Session) Begin(step Step) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.step = &step
+	s.samples[step.Name] = make(map[arduino.ChannelRole][]int, len(step.Roles))
+}
+
+// End stops recording and returns number of values recorded during step
+func (s *Session) End() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.step == nil {
+		return 0
+	}
+	n := 0
+	for _, values := range s.samples[s.step.Name] {
+		n += len(values)
+	}
+	s.step = nil
+	return n
+}
+
+// Add records channel values of current step, frame is ignored if no step is in progress
+func (s *Session) Add(f *frame.Frame) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.step == nil {
+		return
+	}
+	samples := s.samples[s.step.Name]
+	for _, role := range s.step.Roles {
+		ch, ok := s.mapping.Channel(role)
+		if !ok {
+			continue
+		}
+		v, ok := f.Channel(ch)
+		if !ok || v < minValidPWM || v > maxValidPWM {
+			continue
+		}
+		samples[role] = append(samples[role], v)
+	}
+}
+
+// Run prompts user for each step on out and records step values during duration once user pressed enter on in.
+// Frames are added concurrently with Add.
+func (s *Session) Run(steps []Step, in io.Reader, out io.Writer, duration time.Duration) error {
+	r := bufio.NewReader(in)
+	for i, step := range steps {
+		_, _ = fmt.Fprintf(out, "step %d/%d: press enter, then %v during %v\n", i+1, len(steps), step.Instruction, duration)
+		if _, err := r.ReadString('\n'); err != nil {
+			return fmt.Errorf("unable to read user input: %w", err)
+		}
+		s.Begin(step)
+		time.Sleep(duration)
+		n := s.End()
+		_, _ = fmt.Fprintf(out, "%d values recorded\n", n)
+	}
+	return nil
+}
+
+func (s *Session) values(step string, role arduino.ChannelRole) ([]int, error) {
+	values := s.samples[step][role]
+	if len(values) == 0 {
+		return nil, fmt.Errorf("no value recorded for %v channel during %v step", role, step)
+	}
+	return values, nil
+}
+
+// Config computes pwm configs and drive mode table from values recorded by DefaultSteps
+func (s *Session) Config() (*Config, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var c Config
+	var err error
+	c.Steering, err = s.pwmConfig(StepSteering, arduino.RoleSteering)
+	if err != nil {
+		return nil, err
+	}
+	c.Throttle, err = s.pwmConfig(StepThrottle, arduino.RoleThrottle)
+	if err != nil {
+		return nil, err
+	}
+	values, err := s.values(StepDriveMode, arduino.RoleDriveMode)
+	if err != nil {
+		return nil, err
+	}
+	c.DriveModes, err = DriveModeTable(Positions(values))
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (s *Session) pwmConfig(step string, role arduino.ChannelRole) (*arduino.PWMConfig, error) {
+	centerValues, err := s.values(StepCenter, role)
+	if err != nil {
+		return nil, err
+	}
+	values, err := s.values(step, role)
+	if err != nil {
+		return nil, err
+	}
+	center := Median(centerValues)
+	min, max := Range(values)
+	if min >= center || max <= center {
+		return nil, fmt.Errorf("%v range [%d, %d] doesn't contain center %d, was stick moved in both directions?", role, min, max, center)
+	}
+	return arduino.NewAsymetricPWMConfig(min, max, center), nil
+}
+
+// Median returns median of values
+func Median(values []int) int {
+	sorted := sortedCopy(values)
+	return sorted[len(sorted)/2]
+}
+
+// Range returns min and max of values, lowest and highest values are ignored as outliers
+func Range(values []int) (min, max int) {
+	sorted := sortedCopy(values)
+	n := int(float64(len(sorted)) * outlierRatio)
+	return sorted[n], sorted[len(sorted)-1-n]
+}
+
+// Positions returns median values of switch positions, sorted by pwm value. Positions are groups of values
+// separated by a gap, groups with too few values are ignored as outliers.
+func Positions(values []int) []int {
+	sorted := sortedCopy(values)
+	minSize := int(float64(len(sorted)) * minPositionRatio)
+	var positions []int
+	start := 0
+	for i := 1; i <= len(sorted); i++ {
+		if i < len(sorted) && sorted[i]-sorted[i-1] < minPositionGap {
+			continue
+		}
+		if group := sorted[start:i]; len(group) > minSize {
+			positions = append(positions, group[len(group)/2])
+		}
+		start = i
+	}
+	return positions
+}
+
+// DriveModeTable builds table of 2 (USER, PILOT) or 3 (USER, COPILOT, PILOT) positions switch, range bounds are
+// middles between positions
+func DriveModeTable(positions []int) (arduino.DriveModeTable, error) {
+	var modes []events.DriveMode
+	switch len(positions) {
+	case 2:
+		modes = []events.DriveMode{events.DriveMode_USER, events.DriveMode_PILOT}
+	case 3:
+		modes = []events.DriveMode{events.DriveMode_USER, events.DriveMode_COPILOT, events.DriveMode_PILOT}
+	default:
+		return nil, fmt.Errorf("%d drive mode switch positions found (%v), should be 2 or 3", len(positions), positions)
+	}
+	table := make(arduino.DriveModeTable, 0, len(positions))
+	min := 0
+	for i, mode := range modes {
+		max := 3000
+		if i < len(positions)-1 {
+			max = (positions[i]+positions[i+1])/2 - 1
+		}
+		table = append(table, arduino.DriveModeRange{Min: min, Max: max, Mode: mode})
+		min = max + 1
+	}
+	return table, nil
+}
+
+func sortedCopy(values []int) []int {
+	sorted := append([]int(nil), values...)
+	sort.Ints(sorted)
+	return sorted
+}
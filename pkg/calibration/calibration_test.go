@@ -0,0 +1,243 @@
+package calibration
+
+import (
+	"bytes"
+	"github.com/cyrilix/robocar-arduino/pkg/arduino"
+	"github.com/cyrilix/robocar-arduino/pkg/config"
+	"github.com/cyrilix/robocar-arduino/pkg/frame"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// stream generates noisy values of a channel around targets, with spikes and invalid values
+type stream struct {
+	rnd *rand.Rand
+	n   int
+}
+
+func (s *stream) next(target int) int {
+	s.n++
+	switch {
+	case s.n%80 == 0:
+		return 2400
+	case s.n%97 == 0:
+		return 0
+	}
+	return target + s.rnd.Intn(11) - 5
+}
+
+// record adds frames to session, values of channel follow targets, each one is held count times
+func record(s *Session, st *stream, channel int, targets []int, count int) {
+	for i := 0; i < 5; i++ {
+		for _, target := range targets {
+			for j := 0; j < count; j++ {
+				f := frame.Frame{Channels: [frame.ChannelCount]int{1500, 1500, 1500, 1500, 1000, 1000, 1500, 1500, 0}}
+				f.Channels[channel-1] = st.next(target)
+				s.Add(&f)
+			}
+		}
+	}
+}
+
+func within(t *testing.T, name string, got, want, tolerance int) {
+	t.Helper()
+	if got < want-tolerance || got > want+tolerance {
+		t.Errorf("bad %v: %v, want %v ± %v", name, got, want, tolerance)
+	}
+}
+
+func TestSession_Config(t *testing.T) {
+	s := NewSession(arduino.DefaultChannelMapping)
+	st := &stream{rnd: rand.New(rand.NewSource(1))}
+
+	s.Begin(DefaultSteps[0])
+	for i := 0; i < 200; i++ {
+		f := frame.Frame{Channels: [frame.ChannelCount]int{st.next(1495), st.next(1260), 1500, 1500, 1000, 1000, 1500, 1500, 0}}
+		s.Add(&f)
+	}
+	if n := s.End(); n < 390 {
+		t.Errorf("bad number of values recorded during center step: %v", n)
+	}
+	s.Begin(DefaultSteps[1])
+	record(s, st, 1, []int{1004, 1250, 1495, 1750, 1986, 1750, 1495, 1250}, 10)
+	s.End()
+	s.Begin(DefaultSteps[2])
+	record(s, st, 2, []int{972, 1260, 1954, 1260}, 20)
+	s.End()
+	s.Begin(DefaultSteps[3])
+	record(s, st, 6, []int{1000, 1500, 1500, 2000, 2000, 1500}, 10)
+	s.End()
+
+	// Values outside of a step are ignored
+	s.Add(&frame.Frame{Channels: [frame.ChannelCount]int{900, 2100, 1500, 1500, 1000, 1500, 1500, 1500, 0}})
+
+	c, err := s.Config()
+	if err != nil {
+		t.Fatalf("Config() error = %v", err)
+	}
+	within(t, "steering min", c.Steering.Min, 1004, 5)
+	within(t, "steering max", c.Steering.Max, 1986, 5)
+	within(t, "steering middle", c.Steering.Middle, 1495, 5)
+	within(t, "throttle min", c.Throttle.Min, 972, 5)
+	within(t, "throttle max", c.Throttle.Max, 1954, 5)
+	within(t, "throttle middle", c.Throttle.Middle, 1260, 5)
+	if len(c.DriveModes) != 3 {
+		t.Fatalf("bad drive mode table: %v", c.DriveModes)
+	}
+	within(t, "copilot min", c.DriveModes[1].Min, 1250, 5)
+	within(t, "pilot min", c.DriveModes[2].Min, 1750, 5)
+}
+
+func TestSession_Config_missingStep(t *testing.T) {
+	s := NewSession(arduino.DefaultChannelMapping)
+	st := &stream{rnd: rand.New(rand.NewSource(1))}
+	s.Begin(DefaultSteps[1])
+	record(s, st, 1, []int{1004, 1986}, 10)
+	s.End()
+	if _, err := s.Config(); err == nil {
+		t.Errorf("Config() should fail without center step")
+	}
+}
+
+func TestSession_Config_centerOutOfRange(t *testing.T) {
+	s := NewSession(arduino.DefaultChannelMapping)
+	st := &stream{rnd: rand.New(rand.NewSource(1))}
+	s.Begin(DefaultSteps[0])
+	record(s, st, 1, []int{1495}, 10)
+	record(s, st, 2, []int{1260}, 10)
+	s.End()
+	// Steering is only moved to the left
+	s.Begin(DefaultSteps[1])
+	record(s, st, 1, []int{1004, 1250}, 10)
+	s.End()
+	if _, err := s.Config(); err == nil {
+		t.Errorf("Config() should fail when center isn't in range")
+	}
+}
+
+func TestPositions(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []int
+		want   []int
+	}{
+		{name: "2 positions", values: []int{1000, 1002, 998, 1001, 2000, 2003, 1999, 2001}, want: []int{1001, 2001}},
+		{
+			name:   "spike and transition",
+			values: append(repeat(1000, 50), append(repeat(1500, 50), append(repeat(2000, 50), 1250, 2400)...)...),
+			want:   []int{1000, 1500, 2000},
+		},
+		{name: "single position", values: repeat(1500, 10), want: []int{1500}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Positions(tt.values); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Positions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func repeat(v, n int) []int {
+	values := make([]int, n)
+	for i := range values {
+		values[i] = v
+	}
+	return values
+}
+
+func TestDriveModeTable(t *testing.T) {
+	got, err := DriveModeTable([]int{1000, 2000})
+	if err != nil {
+		t.Fatalf("DriveModeTable() error = %v", err)
+	}
+	want := arduino.DriveModeTable{
+		{Min: 0, Max: 1499, Mode: events.DriveMode_USER},
+		{Min: 1500, Max: 3000, Mode: events.DriveMode_PILOT},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DriveModeTable() = %v, want %v", got, want)
+	}
+	for _, positions := range [][]int{nil, {1500}, {1000, 1300, 1600, 1900}} {
+		if _, err := DriveModeTable(positions); err == nil {
+			t.Errorf("DriveModeTable(%v) should fail", positions)
+		}
+	}
+}
+
+func TestConfig_Merge(t *testing.T) {
+	c := Config{
+		Steering:   arduino.NewAsymetricPWMConfig(1010, 1990, 1500),
+		Throttle:   arduino.NewAsymetricPWMConfig(980, 1960, 1270),
+		DriveModes: arduino.DefaultDriveModeTable,
+	}
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "config.json")
+	content := `{
+  "mqtt": {"broker": "tcp://mqtt:1883"},
+  "pwm": {
+    "steering": {"min": 1000, "max": 2000, "middle": 1490, "deadband": 10},
+    "throttle": {"reverse": true}
+  }
+}`
+	if err := os.WriteFile(fileName, []byte(content), 0o644); err != nil {
+		t.Fatalf("unable to write config file: %v", err)
+	}
+	if err := c.Merge(fileName); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+
+	got := config.Default()
+	if err := got.Load(fileName); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	want := config.Default()
+	want.MQTT.Broker = "tcp://mqtt:1883"
+	want.PWM.Steering = arduino.PWMConfig{Min: 1010, Max: 1990, Middle: 1500, Deadband: 10}
+	want.PWM.Throttle = arduino.PWMConfig{Min: 980, Max: 1960, Middle: 1270, Reverse: true}
+	want.DriveModes = arduino.DefaultDriveModeTable
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("merged config = %+v, want %+v", got, want)
+	}
+
+	// Config file is created if missing
+	fileName = filepath.Join(dir, "new.json")
+	if err := c.Merge(fileName); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	got = config.Default()
+	if err := got.Load(fileName); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got.PWM.Steering != *c.Steering || got.PWM.Throttle != *c.Throttle {
+		t.Errorf("bad pwm configs in new config file: %+v", got.PWM)
+	}
+
+	// Invalid sections are not overwritten
+	fileName = filepath.Join(dir, "invalid.json")
+	if err := os.WriteFile(fileName, []byte(`{"pwm": 3}`), 0o644); err != nil {
+		t.Fatalf("unable to write config file: %v", err)
+	}
+	if err := c.Merge(fileName); err == nil {
+		t.Errorf("Merge() should fail when pwm isn't a json object")
+	}
+}
+
+func TestSession_Run(t *testing.T) {
+	s := NewSession(arduino.DefaultChannelMapping)
+	var out bytes.Buffer
+	if err := s.Run(DefaultSteps, strings.NewReader("\n\n\n\n"), &out, 0); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if n := strings.Count(out.String(), "press enter"); n != len(DefaultSteps) {
+		t.Errorf("bad number of prompts %v, want %v: %v", n, len(DefaultSteps), out.String())
+	}
+	if err := s.Run(DefaultSteps, strings.NewReader("\n"), &out, 0); err == nil {
+		t.Errorf("Run() should fail when user input is closed")
+	}
+}
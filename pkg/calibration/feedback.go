@@ -0,0 +1,251 @@
+package calibration
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/cyrilix/robocar-arduino/pkg/tools"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	DefaultSettleDelay       = 500 * time.Millisecond
+	DefaultThrottleTolerance = 0.02
+
+	// minStepSamples is the min number of settled samples to measure a throttle step
+	minStepSamples = 10
+	// thresholdStepDecimals is the number of decimals of fitted threshold steps
+	thresholdStepDecimals = 4
+)
+
+// FeedbackSample is a throttle value and the throttle feedback value read in same frame
+type FeedbackSample struct {
+	Time     time.Time
+	Throttle float64
+	Feedback int
+}
+
+// FitOptions configures FitThresholds
+type FitOptions struct {
+	// SettleDelay is the delay after a throttle change before feedback values are used, motor speed is then stable
+	SettleDelay time.Duration
+	// Tolerance is the max throttle change inside a throttle step
+	Tolerance float64
+	// MinValid, Interpolation and OutOfRange are copied to fitted thresholds, feedback values under MinValid are ignored
+	MinValid      int
+	Interpolation tools.Interpolation
+	OutOfRange    tools.OutOfRange
+}
+
+// FeedbackStep is a throttle step measured in samples
+type FeedbackStep struct {
+	// Throttle is the median throttle value of step
+	Throttle float64 `json:"throttle"`
+	// Feedback is the median of settled feedback values
+	Feedback int `json:"feedback"`
+	// Spread is the interquartile range of settled feedback values
+	Spread  int `json:"spread"`
+	Samples int `json:"samples"`
+	// Adjusted is true when step was pooled with its neighbours to keep feedback values decreasing
+	Adjusted bool `json:"adjusted"`
+}
+
+// FitReport describes quality of fitted thresholds
+type FitReport struct {
+	Steps []FeedbackStep `json:"steps"`
+	// RMSError and MaxError compare throttle of settled samples with value computed by thresholds from their feedback
+	RMSError float64 `json:"rms_error"`
+	MaxError float64 `json:"max_error"`
+	// R2 is the coefficient of determination of thresholds on settled samples
+	R2 float64 `json:"r2"`
+}
+
+// Print writes report as text
+func (r *FitReport) Print(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "%-6s %-9s %-9s %-7s %-8s %s\n", "step", "throttle", "feedback", "spread", "samples", "adjusted")
+	for i, s := range r.Steps {
+		adjusted := ""
+		if s.Adjusted {
+			adjusted = "yes"
+		}
+		_, _ = fmt.Fprintf(w, "%-6d %-9.3f %-9d %-7d %-8d %s\n", i+1, s.Throttle, s.Feedback, s.Spread, s.Samples, adjusted)
+	}
+	_, _ = fmt.Fprintf(w, "rms error: %.4f, max error: %.4f, r2: %.4f\n", r.RMSError, r.MaxError, r.R2)
+}
+
+// FitThresholds builds thresholds from samples recorded while throttle was held at several constant forward values.
+// Each throttle step is measured by median of feedback values once motor speed is settled, steps are then pooled
+// when needed to keep feedback values decreasing.
+func FitThresholds(samples []FeedbackSample, opts FitOptions) (*tools.ThresholdConfig, *FitReport, error) {
+	groups := throttleSteps(samples, opts)
+	if len(groups) < 2 {
+		return nil, nil, fmt.Errorf("%d throttle steps found, at least 2 steps are required", len(groups))
+	}
+
+	report := FitReport{Steps: make([]FeedbackStep, 0, len(groups))}
+	for _, g := range groups {
+		throttles := make([]float64, 0, len(g))
+		feedbacks := make([]int, 0, len(g))
+		for _, s := range g {
+			throttles = append(throttles, s.Throttle)
+			feedbacks = append(feedbacks, s.Feedback)
+		}
+		sort.Float64s(throttles)
+		sorted := sortedCopy(feedbacks)
+		report.Steps = append(report.Steps, FeedbackStep{
+			Throttle: throttles[len(throttles)/2],
+			Feedback: sorted[len(sorted)/2],
+			Spread:   sorted[len(sorted)*3/4] - sorted[len(sorted)/4],
+			Samples:  len(g),
+		})
+	}
+
+	tc := &tools.ThresholdConfig{
+		MinValid:      opts.MinValid,
+		Interpolation: opts.Interpolation,
+		OutOfRange:    opts.OutOfRange,
+	}
+	blocks := decreasingBlocks(report.Steps)
+	if len(blocks) < 2 {
+		return nil, nil, fmt.Errorf("feedback values don't decrease when throttle increases, steps: %v", report.Steps)
+	}
+	for _, b := range blocks {
+		if len(b.steps) > 1 {
+			for _, i := range b.steps {
+				report.Steps[i].Adjusted = true
+			}
+		}
+		tc.ThresholdSteps = append(tc.ThresholdSteps, round(b.throttle(), thresholdStepDecimals))
+		tc.Data = append(tc.Data, b.feedback())
+	}
+	if err := tc.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid fitted thresholds: %w", err)
+	}
+
+	var sumErr, sumThrottle, n float64
+	for _, g := range groups {
+		for _, s := range g {
+			e := math.Abs(tc.ValueOf(s.Feedback) - s.Throttle)
+			sumErr += e * e
+			report.MaxError = math.Max(report.MaxError, e)
+			sumThrottle += s.Throttle
+			n++
+		}
+	}
+	mean := sumThrottle / n
+	var sumVar float64
+	for _, g := range groups {
+		for _, s := range g {
+			sumVar += (s.Throttle - mean) * (s.Throttle - mean)
+		}
+	}
+	report.RMSError = math.Sqrt(sumErr / n)
+	report.R2 = 1. - sumErr/sumVar
+	return tc, &report, nil
+}
+
+// WriteThresholds writes thresholds as json file, loaded by rc-arduino with --throttle-feedback-config
+func WriteThresholds(tc *tools.ThresholdConfig, fileName string) error {
+	content, err := json.MarshalIndent(tc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to marshal thresholds: %w", err)
+	}
+	if err := os.WriteFile(fileName, append(content, '\n'), 0o644); err != nil {
+		return fmt.Errorf("unable to write thresholds to %s file: %w", fileName, err)
+	}
+	return nil
+}
+
+// throttleSteps returns settled samples of forward throttle steps, sorted by throttle. Steps of same throttle found
+// several times in samples are merged.
+func throttleSteps(samples []FeedbackSample, opts FitOptions) [][]FeedbackSample {
+	var steps [][]FeedbackSample
+	var current []FeedbackSample
+	flush := func() {
+		if len(current) >= minStepSamples {
+			steps = append(steps, current)
+		}
+		current = nil
+	}
+
+	var start FeedbackSample
+	for i, s := range samples {
+		if i == 0 || math.Abs(s.Throttle-start.Throttle) > opts.Tolerance {
+			flush()
+			start = s
+		}
+		// Neutral and reverse throttle are ignored
+		if start.Throttle <= opts.Tolerance {
+			continue
+		}
+		if s.Time.Sub(start.Time) < opts.SettleDelay || s.Feedback < opts.MinValid {
+			continue
+		}
+		current = append(current, s)
+	}
+	flush()
+
+	sort.SliceStable(steps, func(i, j int) bool { return medianThrottle(steps[i]) < medianThrottle(steps[j]) })
+	var merged [][]FeedbackSample
+	for _, s := range steps {
+		if last := len(merged) - 1; last >= 0 && medianThrottle(s)-medianThrottle(merged[last]) <= opts.Tolerance {
+			merged[last] = append(merged[last], s...)
+			continue
+		}
+		merged = append(merged, s)
+	}
+	return merged
+}
+
+func medianThrottle(samples []FeedbackSample) float64 {
+	throttles := make([]float64, 0, len(samples))
+	for _, s := range samples {
+		throttles = append(throttles, s.Throttle)
+	}
+	sort.Float64s(throttles)
+	return throttles[len(throttles)/2]
+}
+
+// block is a group of consecutive steps pooled by decreasingBlocks, values are weighted by number of samples
+type block struct {
+	steps                            []int
+	throttleSum, feedbackSum, weight float64
+}
+
+func (b *block) throttle() float64 {
+	return b.throttleSum / b.weight
+}
+
+func (b *block) feedback() int {
+	return int(math.Round(b.feedbackSum / b.weight))
+}
+
+// decreasingBlocks pools adjacent steps until feedback values are strictly decreasing (pool adjacent violators
+// algorithm)
+func decreasingBlocks(steps []FeedbackStep) []*block {
+	var blocks []*block
+	for i, s := range steps {
+		w := float64(s.Samples)
+		blocks = append(blocks, &block{steps: []int{i}, throttleSum: s.Throttle * w, feedbackSum: float64(s.Feedback) * w, weight: w})
+		for len(blocks) > 1 {
+			last, previous := blocks[len(blocks)-1], blocks[len(blocks)-2]
+			if last.feedback() < previous.feedback() {
+				break
+			}
+			previous.steps = append(previous.steps, last.steps...)
+			previous.throttleSum += last.throttleSum
+			previous.feedbackSum += last.feedbackSum
+			previous.weight += last.weight
+			blocks = blocks[:len(blocks)-1]
+		}
+	}
+	return blocks
+}
+
+func round(v float64, decimals int) float64 {
+	p := math.Pow(10, float64(decimals))
+	return math.Round(v*p) / p
+}
@@ -0,0 +1,145 @@
+package calibration
+
+import (
+	"bytes"
+	"github.com/cyrilix/robocar-arduino/pkg/tools"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// motorPeriod is the feedback value of simulated motor at constant throttle
+func motorPeriod(throttle float64) int {
+	return int(500 + 250/throttle)
+}
+
+// run generates samples at 50Hz, throttle is held during duration for each step, then back to neutral during 1s.
+// Feedback value reaches motor period of throttle after 300ms, with noise and missing pulses.
+func run(rnd *rand.Rand, throttles []float64, duration time.Duration, period func(float64) int) []FeedbackSample {
+	var samples []FeedbackSample
+	t := time.Unix(1000, 0)
+	add := func(throttle float64, d time.Duration) {
+		start := t
+		for ; t.Sub(start) < d; t = t.Add(20 * time.Millisecond) {
+			feedback := 0
+			switch {
+			case throttle <= 0:
+			case rnd.Intn(50) == 0:
+				// Missing pulse
+			case t.Sub(start) < 300*time.Millisecond:
+				feedback = 2 * period(throttle)
+			default:
+				feedback = period(throttle) + rnd.Intn(41) - 20
+			}
+			samples = append(samples, FeedbackSample{Time: t, Throttle: throttle + float64(rnd.Intn(5)-2)/1000, Feedback: feedback})
+		}
+	}
+	for _, throttle := range throttles {
+		add(throttle, duration)
+		add(0, time.Second)
+	}
+	return samples
+}
+
+var testFitOptions = FitOptions{
+	SettleDelay:   DefaultSettleDelay,
+	Tolerance:     DefaultThrottleTolerance,
+	MinValid:      500,
+	Interpolation: tools.InterpolationLinear,
+}
+
+func TestFitThresholds(t *testing.T) {
+	throttles := []float64{0.3, 0.1, 0.2, 0.5, 0.7, 1.0, 0.3}
+	samples := run(rand.New(rand.NewSource(1)), throttles, 3*time.Second, motorPeriod)
+
+	tc, report, err := FitThresholds(samples, testFitOptions)
+	if err != nil {
+		t.Fatalf("FitThresholds() error = %v", err)
+	}
+	wantSteps := []float64{0.1, 0.2, 0.3, 0.5, 0.7, 1.0}
+	if !reflect.DeepEqual(tc.ThresholdSteps, wantSteps) {
+		t.Errorf("bad threshold steps %v, want %v", tc.ThresholdSteps, wantSteps)
+	}
+	for i, step := range wantSteps {
+		within(t, "feedback", tc.Data[i], motorPeriod(step), 5)
+	}
+	if tc.MinValid != 500 || tc.Interpolation != tools.InterpolationLinear {
+		t.Errorf("options should be copied to thresholds: %+v", tc)
+	}
+	if len(report.Steps) != len(wantSteps) {
+		t.Fatalf("bad number of steps in report: %+v", report.Steps)
+	}
+	// Step 0.3 is recorded twice
+	if report.Steps[2].Samples < 2*report.Steps[0].Samples-10 {
+		t.Errorf("steps of same throttle should be merged: %+v", report.Steps)
+	}
+	if report.RMSError > 0.05 || report.R2 < 0.95 {
+		t.Errorf("bad fit quality: %+v", report)
+	}
+
+	fileName := filepath.Join(t.TempDir(), "thresholds.json")
+	if err := WriteThresholds(tc, fileName); err != nil {
+		t.Fatalf("WriteThresholds() error = %v", err)
+	}
+	got, err := tools.NewThresholdConfigFromJson(fileName)
+	if err != nil {
+		t.Fatalf("NewThresholdConfigFromJson() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, tc) {
+		t.Errorf("NewThresholdConfigFromJson() = %+v, want %+v", got, tc)
+	}
+
+	var buf bytes.Buffer
+	report.Print(&buf)
+	if n := strings.Count(buf.String(), "\n"); n != len(wantSteps)+2 {
+		t.Errorf("bad report:\n%v", buf.String())
+	}
+}
+
+func TestFitThresholds_saturation(t *testing.T) {
+	// Motor speed decreases over 0.8 because of battery voltage drop
+	saturated := func(throttle float64) int {
+		if throttle > 0.8 {
+			return motorPeriod(0.8) + int((throttle-0.8)*100)
+		}
+		return motorPeriod(throttle)
+	}
+	samples := run(rand.New(rand.NewSource(2)), []float64{0.2, 0.4, 0.8, 0.9, 1.0}, 3*time.Second, saturated)
+
+	tc, report, err := FitThresholds(samples, testFitOptions)
+	if err != nil {
+		t.Fatalf("FitThresholds() error = %v", err)
+	}
+	if err := tc.Validate(); err != nil {
+		t.Errorf("fitted thresholds should be valid: %v", err)
+	}
+	if len(tc.Data) != 3 {
+		t.Errorf("saturated steps should be pooled: %+v", tc)
+	}
+	if !report.Steps[len(report.Steps)-1].Adjusted || report.Steps[0].Adjusted {
+		t.Errorf("bad adjusted steps: %+v", report.Steps)
+	}
+}
+
+func TestFitThresholds_notEnoughSteps(t *testing.T) {
+	tests := []struct {
+		name      string
+		throttles []float64
+		duration  time.Duration
+	}{
+		{name: "single step", throttles: []float64{0.5}, duration: 3 * time.Second},
+		{name: "steps too short", throttles: []float64{0.2, 0.5}, duration: 600 * time.Millisecond},
+		{name: "reverse", throttles: []float64{-0.2, -0.5}, duration: 3 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			samples := run(rand.New(rand.NewSource(3)), tt.throttles, tt.duration, motorPeriod)
+			if _, _, err := FitThresholds(samples, testFitOptions); err == nil {
+				t.Errorf("FitThresholds() should fail")
+			}
+		})
+	}
+}
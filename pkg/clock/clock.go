@@ -0,0 +1,150 @@
+// Package clock maps arduino timestamps (millis since arduino boot) to host time.
+package clock
+
+import (
+	"time"
+)
+
+const (
+	DefaultBucket = time.Second
+	DefaultWindow = 300
+
+	// minBuckets is the number of buckets required before to estimate drift
+	minBuckets = 3
+)
+
+type sample struct {
+	arduino int64 // millis
+	host    time.Time
+}
+
+// faster returns true if sample has been received with a shorter latency than other
+func (s sample) faster(other sample) bool {
+	return s.host.Sub(other.host) < time.Duration(s.arduino-other.arduino)*time.Millisecond
+}
+
+// Estimator estimates offset and drift between arduino clock and host clock from (arduino timestamp, host receive
+// time) samples. Host receive time is arduino time delayed by a variable transmission latency: for each bucket of
+// arduino time, only the sample with the shortest latency is kept. Mapping is a line fitted by least squares on
+// these samples, shifted to the lowest one.
+type Estimator struct {
+	bucket  int64 // millis
+	window  int
+	samples []sample
+	next    int
+
+	current       sample
+	currentBucket int64
+	hasCurrent    bool
+
+	// host = origin + slope * (arduino - arduinoOrigin)
+	slope         float64 // host nanoseconds per arduino millisecond
+	origin        time.Time
+	arduinoOrigin int64
+	ready         bool
+}
+
+// NewEstimator creates an estimator that keeps one sample by bucket of arduino time, for window buckets
+func NewEstimator(bucket time.Duration, window int) *Estimator {
+	if bucket < time.Millisecond {
+		bucket = DefaultBucket
+	}
+	if window < minBuckets {
+		window = minBuckets
+	}
+	return &Estimator{bucket: bucket.Milliseconds(), window: window, samples: make([]sample, 0, window)}
+}
+
+// Reset drops all samples, to use when arduino clock is not continuous anymore
+func (e *Estimator) Reset() {
+	e.samples = e.samples[:0]
+	e.next = 0
+	e.hasCurrent = false
+	e.ready = false
+}
+
+// Add registers arduino timestamp (millis) received at host time
+func (e *Estimator) Add(arduino int64, host time.Time) {
+	s := sample{arduino: arduino, host: host}
+	b := arduino / e.bucket
+	switch {
+	case !e.hasCurrent:
+		e.current, e.currentBucket, e.hasCurrent = s, b, true
+	case b != e.currentBucket:
+		e.push(e.current)
+		e.current, e.currentBucket = s, b
+	case s.faster(e.current):
+		e.current = s
+	}
+	e.fit()
+}
+
+func (e *Estimator) push(s sample) {
+	if len(e.samples) < e.window {
+		e.samples = append(e.samples, s)
+		return
+	}
+	e.samples[e.next] = s
+	e.next = (e.next + 1) % e.window
+}
+
+func (e *Estimator) fit() {
+	samples := append(e.samples, e.current)
+	ref := samples[0]
+	n := float64(len(samples))
+
+	e.slope = float64(time.Millisecond)
+	if len(samples) >= minBuckets {
+		var sumX, sumY float64
+		for _, s := range samples {
+			sumX += float64(s.arduino - ref.arduino)
+			sumY += float64(s.host.Sub(ref.host))
+		}
+		meanX, meanY := sumX/n, sumY/n
+		var cov, variance float64
+		for _, s := range samples {
+			dx := float64(s.arduino-ref.arduino) - meanX
+			dy := float64(s.host.Sub(ref.host)) - meanY
+			cov += dx * dy
+			variance += dx * dx
+		}
+		if variance > 0 {
+			e.slope = cov / variance
+		}
+	}
+
+	// Shift line on sample with the shortest latency
+	minResidual := time.Duration(0)
+	for i, s := range samples {
+		residual := s.host.Sub(ref.host) - time.Duration(e.slope*float64(s.arduino-ref.arduino))
+		if i == 0 || residual < minResidual {
+			minResidual = residual
+		}
+	}
+	e.origin = ref.host.Add(minResidual)
+	e.arduinoOrigin = ref.arduino
+	e.ready = true
+}
+
+// HostTime returns host time matching arduino timestamp, false if no sample is available
+func (e *Estimator) HostTime(arduino int64) (time.Time, bool) {
+	if !e.ready {
+		return time.Time{}, false
+	}
+	return e.origin.Add(time.Duration(e.slope * float64(arduino-e.arduinoOrigin))), true
+}
+
+// Drift returns arduino clock drift relative to host clock, in parts per million. A positive drift means arduino
+// clock is slower than host clock.
+func (e *Estimator) Drift() float64 {
+	if !e.ready {
+		return 0.
+	}
+	return (e.slope/float64(time.Millisecond) - 1.) * 1e6
+}
+
+// Offset returns host time of arduino timestamp 0, that is arduino boot time on host clock
+func (e *Estimator) Offset() time.Time {
+	t, _ := e.HostTime(0)
+	return t
+}
@@ -0,0 +1,70 @@
+package clock
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestEstimator(t *testing.T) {
+	boot := time.Date(2023, 9, 30, 10, 0, 0, 0, time.UTC)
+	const driftPpm = 50.
+	minLatency := time.Millisecond
+	captureTime := func(arduino int64) time.Time {
+		return boot.Add(time.Duration(float64(arduino) * float64(time.Millisecond) * (1 + driftPpm/1e6)))
+	}
+
+	e := NewEstimator(DefaultBucket, DefaultWindow)
+	if _, ok := e.HostTime(0); ok {
+		t.Errorf("HostTime() should not be available without sample")
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	var arduino int64 = 60000
+	for i := 0; i < 2000; i++ {
+		latency := minLatency + time.Duration(rnd.Int63n(int64(3*time.Millisecond)))
+		e.Add(arduino, captureTime(arduino).Add(latency))
+		arduino += 20
+	}
+
+	if math.Abs(e.Drift()-driftPpm) > 5 {
+		t.Errorf("bad drift estimation, expected: %v ppm, actual: %v ppm", driftPpm, e.Drift())
+	}
+	for _, a := range []int64{arduino - 1000, arduino, arduino + 1000} {
+		got, ok := e.HostTime(a)
+		if !ok {
+			t.Fatalf("HostTime() should be available")
+		}
+		if diff := got.Sub(captureTime(a).Add(minLatency)); diff < -500*time.Microsecond || diff > 500*time.Microsecond {
+			t.Errorf("bad host time for %v, error: %v", a, diff)
+		}
+	}
+	if diff := e.Offset().Sub(boot.Add(minLatency)); diff < -2*time.Millisecond || diff > 2*time.Millisecond {
+		t.Errorf("bad offset, error: %v", diff)
+	}
+}
+
+func TestEstimator_fewSamples(t *testing.T) {
+	host := time.Now()
+	e := NewEstimator(DefaultBucket, DefaultWindow)
+	e.Add(1000, host)
+	e.Add(1020, host.Add(25*time.Millisecond))
+
+	got, ok := e.HostTime(1040)
+	if !ok {
+		t.Fatalf("HostTime() should be available")
+	}
+	// Without drift estimation, clocks run at same rate from sample with shortest latency
+	if want := host.Add(40 * time.Millisecond); !got.Equal(want) {
+		t.Errorf("HostTime() = %v, want %v", got, want)
+	}
+	if e.Drift() != 0 {
+		t.Errorf("Drift() = %v, want 0", e.Drift())
+	}
+
+	e.Reset()
+	if _, ok := e.HostTime(1040); ok {
+		t.Errorf("HostTime() should not be available after Reset()")
+	}
+}
@@ -0,0 +1,191 @@
+package clock
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// DefaultIntervalWindow is the number of inter-frame intervals used to compute jitter
+	DefaultIntervalWindow = 100
+
+	// rolloverPeriod is the period of arduino millis() counter (unsigned long)
+	rolloverPeriod = int64(1) << 32
+	// rolloverTolerance is the max difference between arduino and host elapsed times to accept a rollover
+	rolloverTolerance = time.Second
+)
+
+// Stats describes synchronization between arduino and host clocks
+type Stats struct {
+	// Offset is arduino boot time on host clock
+	Offset time.Time
+	// Drift of arduino clock relative to host clock, in parts per million
+	Drift float64
+	// Frames is the number of timestamps received
+	Frames uint64
+	// DroppedFrames is the number of frames missing between received timestamps
+	DroppedFrames uint64
+	// Rollovers is the number of arduino millis overflows
+	Rollovers int
+	// Resets is the number of arduino restarts detected
+	Resets int
+	// Interval is the median delay between two frames on arduino clock
+	Interval time.Duration
+	// Jitter is the standard deviation of delays between two frames on arduino clock
+	Jitter time.Duration
+	// LatencyJitter is the standard deviation of delays between estimated capture times and host receive times
+	LatencyJitter time.Duration
+}
+
+// Sync tracks arduino timestamps: it unwraps millis rollover, detects arduino resets and computes inter-frame
+// statistics. Mapping to host time is delegated to an Estimator.
+type Sync struct {
+	estimator *Estimator
+
+	hasLast   bool
+	last      uint32
+	lastHost  time.Time
+	epoch     int64 // millis added to arduino timestamps after rollovers
+	frames    uint64
+	dropped   uint64
+	rollovers int
+	resets    int
+	intervals *ring
+	latencies *ring
+}
+
+// NewSync creates a clock synchronization with default estimator
+func NewSync() *Sync {
+	return &Sync{
+		estimator: NewEstimator(DefaultBucket, DefaultWindow),
+		intervals: newRing(DefaultIntervalWindow),
+		latencies: newRing(DefaultIntervalWindow),
+	}
+}
+
+// Update registers arduino timestamp received at host time and returns frame capture time on host clock
+func (s *Sync) Update(timestamp uint32, host time.Time) time.Time {
+	if s.hasLast {
+		delta := int64(timestamp) - int64(s.last)
+		if delta < 0 {
+			// Millis counter overflows after ~49 days, other backward steps are arduino restarts
+			wrapped := delta + rolloverPeriod
+			if time.Duration(wrapped)*time.Millisecond <= host.Sub(s.lastHost)+rolloverTolerance {
+				s.rollovers++
+				s.epoch += rolloverPeriod
+				delta = wrapped
+			} else {
+				s.resets++
+				s.epoch = 0
+				s.estimator.Reset()
+				s.intervals.reset()
+				s.latencies.reset()
+				delta = -1
+			}
+		}
+		if delta >= 0 {
+			s.countDropped(delta)
+			s.intervals.add(float64(delta))
+		}
+	}
+	s.hasLast = true
+	s.last = timestamp
+	s.lastHost = host
+	s.frames++
+
+	arduino := s.epoch + int64(timestamp)
+	s.estimator.Add(arduino, host)
+	capture, _ := s.estimator.HostTime(arduino)
+	s.latencies.add(float64(host.Sub(capture)))
+	return capture
+}
+
+// countDropped counts frames missing in delta millis, relatively to median interval
+func (s *Sync) countDropped(delta int64) {
+	median := s.intervals.median()
+	if median <= 0 {
+		return
+	}
+	if missing := int64(math.Round(float64(delta)/median)) - 1; missing > 0 {
+		s.dropped += uint64(missing)
+	}
+}
+
+// Rollovers returns number of arduino millis overflows
+func (s *Sync) Rollovers() int {
+	return s.rollovers
+}
+
+// Resets returns number of arduino restarts detected
+func (s *Sync) Resets() int {
+	return s.resets
+}
+
+// Stats returns current synchronization statistics
+func (s *Sync) Stats() Stats {
+	return Stats{
+		Offset:        s.estimator.Offset(),
+		Drift:         s.estimator.Drift(),
+		Frames:        s.frames,
+		DroppedFrames: s.dropped,
+		Rollovers:     s.rollovers,
+		Resets:        s.resets,
+		Interval:      time.Duration(s.intervals.median() * float64(time.Millisecond)),
+		Jitter:        time.Duration(s.intervals.stdDev() * float64(time.Millisecond)),
+		LatencyJitter: time.Duration(s.latencies.stdDev()),
+	}
+}
+
+// ring is a fixed size buffer of last values
+type ring struct {
+	values []float64
+	next   int
+}
+
+func newRing(size int) *ring {
+	return &ring{values: make([]float64, 0, size)}
+}
+
+func (r *ring) add(v float64) {
+	if len(r.values) < cap(r.values) {
+		r.values = append(r.values, v)
+		return
+	}
+	r.values[r.next] = v
+	r.next = (r.next + 1) % len(r.values)
+}
+
+func (r *ring) reset() {
+	r.values = r.values[:0]
+	r.next = 0
+}
+
+func (r *ring) median() float64 {
+	if len(r.values) == 0 {
+		return 0.
+	}
+	sorted := append([]float64(nil), r.values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+func (r *ring) stdDev() float64 {
+	if len(r.values) < 2 {
+		return 0.
+	}
+	var sum float64
+	for _, v := range r.values {
+		sum += v
+	}
+	mean := sum / float64(len(r.values))
+	var variance float64
+	for _, v := range r.values {
+		variance += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(variance / float64(len(r.values)-1))
+}
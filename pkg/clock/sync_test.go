@@ -0,0 +1,123 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSync(t *testing.T) {
+	start := time.Date(2023, 9, 30, 10, 0, 0, 0, time.UTC)
+	type step struct {
+		// timestamp is the arduino millis sent
+		timestamp uint32
+		// at is the receive delay on host clock since start
+		at time.Duration
+	}
+	// regular returns n frames every 20ms from arduino timestamp ts, received at host delay at
+	regular := func(n int, ts uint32, at time.Duration) []step {
+		steps := make([]step, 0, n)
+		for i := 0; i < n; i++ {
+			steps = append(steps, step{timestamp: ts + uint32(i*20), at: at + time.Duration(i)*20*time.Millisecond})
+		}
+		return steps
+	}
+	concat := func(steps ...[]step) []step {
+		var result []step
+		for _, s := range steps {
+			result = append(result, s...)
+		}
+		return result
+	}
+
+	tests := []struct {
+		name          string
+		steps         []step
+		wantDropped   uint64
+		wantRollovers int
+		wantResets    int
+		wantLast      time.Duration
+	}{
+		{
+			name:     "regular frames",
+			steps:    regular(50, 1000, 0),
+			wantLast: 49 * 20 * time.Millisecond,
+		},
+		{
+			name:        "dropped frames",
+			steps:       concat(regular(10, 1000, 0), regular(10, 1260, 260*time.Millisecond)),
+			wantDropped: 3,
+			wantLast:    440 * time.Millisecond,
+		},
+		{
+			name:          "millis rollover",
+			steps:         concat(regular(5, 1<<32-100, 0), regular(5, 0, 100*time.Millisecond)),
+			wantRollovers: 1,
+			wantLast:      180 * time.Millisecond,
+		},
+		{
+			name:       "arduino reset",
+			steps:      concat(regular(10, 100000, 0), regular(5, 500, 3*time.Second)),
+			wantResets: 1,
+			wantLast:   3*time.Second + 80*time.Millisecond,
+		},
+		{
+			name:       "arduino reset after long uptime",
+			steps:      concat(regular(10, 1<<31+5000, 0), regular(5, 500, 3*time.Second)),
+			wantResets: 1,
+			wantLast:   3*time.Second + 80*time.Millisecond,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSync()
+			var capture time.Time
+			for _, st := range tt.steps {
+				capture = s.Update(st.timestamp, start.Add(st.at))
+			}
+			if want := start.Add(tt.wantLast); !capture.Equal(want) {
+				t.Errorf("bad capture time, expected: %v, actual: %v", want, capture)
+			}
+			stats := s.Stats()
+			if stats.Frames != uint64(len(tt.steps)) {
+				t.Errorf("bad frames count, expected: %v, actual: %v", len(tt.steps), stats.Frames)
+			}
+			if stats.DroppedFrames != tt.wantDropped {
+				t.Errorf("bad dropped frames, expected: %v, actual: %v", tt.wantDropped, stats.DroppedFrames)
+			}
+			if stats.Rollovers != tt.wantRollovers {
+				t.Errorf("bad rollovers, expected: %v, actual: %v", tt.wantRollovers, stats.Rollovers)
+			}
+			if stats.Resets != tt.wantResets {
+				t.Errorf("bad resets, expected: %v, actual: %v", tt.wantResets, stats.Resets)
+			}
+			if stats.Interval != 20*time.Millisecond {
+				t.Errorf("bad interval, expected: %v, actual: %v", 20*time.Millisecond, stats.Interval)
+			}
+		})
+	}
+}
+
+func TestSync_jitter(t *testing.T) {
+	start := time.Now()
+	s := NewSync()
+	var ts uint32 = 1000
+	for i := 0; i < 100; i++ {
+		// Intervals alternate between 18ms and 22ms
+		if i%2 == 0 {
+			ts += 18
+		} else {
+			ts += 22
+		}
+		s.Update(ts, start.Add(time.Duration(ts)*time.Millisecond))
+	}
+	stats := s.Stats()
+	if stats.Jitter < 1900*time.Microsecond || stats.Jitter > 2100*time.Microsecond {
+		t.Errorf("bad jitter, expected: ~2ms, actual: %v", stats.Jitter)
+	}
+	if stats.LatencyJitter != 0 {
+		t.Errorf("bad latency jitter, expected: 0, actual: %v", stats.LatencyJitter)
+	}
+	if stats.DroppedFrames != 0 {
+		t.Errorf("no dropped frame expected, actual: %v", stats.DroppedFrames)
+	}
+}
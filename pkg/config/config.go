@@ -0,0 +1,456 @@
+// Package config describes rc-arduino settings loaded from a json config file.
+//
+// Values missing in config file keep their default value. rc-arduino applies env variables and then command line
+// args over config file values. Some args replace a whole section of config file:
+//   - drive_modes is replaced by drive-mode-copilot-min-pwm/drive-mode-pilot-min-pwm args, and then by
+//     drive-mode-config file that has precedence over them
+//   - channel_mapping, curves and throttle_feedback are replaced by channel-mapping-config, curves-config and
+//     throttle-feedback-config files
+//   - maps (failsafe channel timeouts and receiver pwm, filters, publish min intervals and topic policies) are
+//     replaced, not merged, by their arg
+//
+// Settings that stay outside config file are only given by env or args: config file itself, log level,
+// print-config, http listener and health timeout, clock and signal intervals, command frequency, and options of
+// replay, calibrate and learn-thresholds commands.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/cyrilix/robocar-arduino/pkg/arduino"
+	"github.com/cyrilix/robocar-arduino/pkg/curve"
+	"github.com/cyrilix/robocar-arduino/pkg/speed"
+	"github.com/cyrilix/robocar-arduino/pkg/tools"
+	"io"
+	"os"
+	"reflect"
+	"time"
+)
+
+const (
+	DefaultDevice       = "/dev/serial0"
+	DefaultBaud         = 115200
+	DefaultBroker       = "tcp://127.0.0.1:1883"
+	DefaultClientId     = "robocar-arduino"
+	DefaultPubFrequency = 25.
+
+	DefaultSteeringLeftPWM  = 1004
+	DefaultSteeringRightPWM = 1986
+	DefaultThrottleZeroPWM  = 1260
+
+	DefaultFailsafeTimeout           = time.Second
+	DefaultFailsafeReceiverTolerance = 10
+	DefaultFailsafeReceiverDelay     = 200 * time.Millisecond
+	DefaultFailsafeFrequencyDelay    = 200 * time.Millisecond
+)
+
+// redacted replaces secrets in printed config
+const redacted = "********"
+
+type Config struct {
+	Serial Serial `json:"serial"`
+	MQTT   MQTT   `json:"mqtt"`
+	PWM    PWM    `json:"pwm"`
+	// ThrottleFeedback maps throttle feedback pwm values to percent values
+	ThrottleFeedback *tools.ThresholdConfig `json:"throttle_feedback"`
+	// DriveModes maps pwm values of drive mode switch to drive modes
+	DriveModes arduino.DriveModeTable `json:"drive_modes"`
+	// Speed describes drivetrain to convert throttle feedback to speed, speed isn't estimated if nil
+	Speed *speed.Config `json:"speed,omitempty"`
+	// ChannelMapping maps arduino channels to their role, arduino.DefaultChannelMapping if empty
+	ChannelMapping arduino.ChannelMapping `json:"channel_mapping,omitempty"`
+	Switches       Switches               `json:"switches"`
+	Failsafe       Failsafe               `json:"failsafe"`
+	// Curves are response curves by role, values are linear for roles without curve
+	Curves map[string]curve.Config `json:"curves,omitempty"`
+	// Filters are filters applied on raw pwm values by role (ex: "steering": "median:5,ema:0.3")
+	Filters  map[string]string `json:"filters,omitempty"`
+	Override Override          `json:"override"`
+	Publish  Publish           `json:"publish"`
+	// RecordFile is the file where run command records raw serial stream, and the session read by replay and
+	// learn-thresholds commands
+	RecordFile string `json:"record_file,omitempty"`
+}
+
+type Serial struct {
+	Device string `json:"device"`
+	Baud   int    `json:"baud"`
+	// Protocol is auto, csv or binary
+	Protocol string `json:"protocol"`
+}
+
+type MQTT struct {
+	Broker   string `json:"broker"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	ClientId string `json:"client_id"`
+	Qos      int    `json:"qos"`
+	Retain   bool   `json:"retain"`
+	// PubFrequency is the number of messages to publish per second
+	PubFrequency float64 `json:"pub_frequency"`
+	Topics       Topics  `json:"topics"`
+	// TopicPolicies override qos and retain flag for some topics
+	TopicPolicies map[string]arduino.PublishPolicy `json:"topic_policies,omitempty"`
+}
+
+// Topics are mqtt topics, empty topics are disabled
+type Topics struct {
+	Throttle          string `json:"throttle"`
+	Steering          string `json:"steering"`
+	DriveMode         string `json:"drive_mode"`
+	SwitchRecord      string `json:"switch_record"`
+	ThrottleFeedback  string `json:"throttle_feedback"`
+	MaxThrottleCtrl   string `json:"max_throttle_ctrl"`
+	LinkState         string `json:"link_state"`
+	Failsafe          string `json:"failsafe"`
+	Clock             string `json:"clock"`
+	Signal            string `json:"signal"`
+	SecondarySteering string `json:"secondary_steering"`
+	SecondaryThrottle string `json:"secondary_throttle"`
+	AutopilotSteering string `json:"autopilot_steering"`
+	AutopilotThrottle string `json:"autopilot_throttle"`
+	// Config receives runtime configs, reload result is published on ConfigStatus
+	Config       string `json:"config"`
+	ConfigStatus string `json:"config_status"`
+	// Speed receives speed and odometry, speed config is required
+	Speed string `json:"speed"`
+}
+
+// PWM are pwm configs by channel
+type PWM struct {
+	Steering          arduino.PWMConfig `json:"steering"`
+	Throttle          arduino.PWMConfig `json:"throttle"`
+	MaxThrottleCtrl   arduino.PWMConfig `json:"max_throttle_ctrl"`
+	SecondarySteering arduino.PWMConfig `json:"secondary_steering"`
+	SecondaryThrottle arduino.PWMConfig `json:"secondary_throttle"`
+}
+
+// Switches configures drive mode and record switches, drive mode positions are given by Config.DriveModes
+type Switches struct {
+	RecordMinPWM        int      `json:"record_min_pwm"`
+	DriveModeHysteresis int      `json:"drive_mode_hysteresis"`
+	RecordHysteresis    int      `json:"record_hysteresis"`
+	DriveModeDebounce   Duration `json:"drive_mode_debounce"`
+	RecordDebounce      Duration `json:"record_debounce"`
+}
+
+// Failsafe describes when radio inputs must be considered as lost, see arduino.FailsafeConfig
+type Failsafe struct {
+	Timeout           Duration         `json:"timeout"`
+	ChannelTimeouts   map[int]Duration `json:"channel_timeouts,omitempty"`
+	ReceiverPWM       map[int]int      `json:"receiver_pwm,omitempty"`
+	ReceiverTolerance int              `json:"receiver_tolerance"`
+	ReceiverDelay     Duration         `json:"receiver_delay"`
+	MinFrequency      int              `json:"min_frequency"`
+	FrequencyDelay    Duration         `json:"frequency_delay"`
+}
+
+// Override selects controls to publish when secondary transmitter is used
+type Override struct {
+	// Priority is none, primary or secondary
+	Priority  string  `json:"priority"`
+	Threshold float64 `json:"threshold"`
+}
+
+// Publish configures how values are published
+type Publish struct {
+	// Mode is ticker or event, other values are only used in event mode
+	Mode         string              `json:"mode"`
+	Epsilon      float64             `json:"epsilon"`
+	MinInterval  Duration            `json:"min_interval"`
+	MinIntervals map[string]Duration `json:"min_intervals,omitempty"`
+	Heartbeat    Duration            `json:"heartbeat"`
+}
+
+// Duration is a time.Duration written as string in json (ex: "500ms")
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration should be a string (ex: \"500ms\"): %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Default returns built-in config
+func Default() *Config {
+	steering := *arduino.NewAsymetricPWMConfig(DefaultSteeringLeftPWM, DefaultSteeringRightPWM,
+		(DefaultSteeringRightPWM-DefaultSteeringLeftPWM)/2+DefaultSteeringLeftPWM)
+	throttle := *arduino.NewAsymetricPWMConfig(arduino.DefaultPwmThrottle.Min, arduino.DefaultPwmThrottle.Max, DefaultThrottleZeroPWM)
+
+	// Copy default thresholds, config file values are unmarshalled over them
+	feedback := tools.NewThresholdConfig().Copy()
+
+	return &Config{
+		Serial: Serial{Device: DefaultDevice, Baud: DefaultBaud, Protocol: string(arduino.ProtocolAuto)},
+		MQTT:   MQTT{Broker: DefaultBroker, ClientId: DefaultClientId, PubFrequency: DefaultPubFrequency},
+		PWM: PWM{
+			Steering:          steering,
+			Throttle:          throttle,
+			MaxThrottleCtrl:   arduino.DefaultPwmThrottle,
+			SecondarySteering: steering,
+			SecondaryThrottle: arduino.DefaultPwmThrottle,
+		},
+		ThrottleFeedback: feedback,
+		DriveModes:       append(arduino.DriveModeTable(nil), arduino.DefaultDriveModeTable...),
+		Switches:         Switches{RecordMinPWM: arduino.DefaultSwitchConfig.RecordMin},
+		Failsafe: Failsafe{
+			Timeout:           Duration(DefaultFailsafeTimeout),
+			ReceiverTolerance: DefaultFailsafeReceiverTolerance,
+			ReceiverDelay:     Duration(DefaultFailsafeReceiverDelay),
+			FrequencyDelay:    Duration(DefaultFailsafeFrequencyDelay),
+		},
+		Override: Override{Priority: string(arduino.OverrideDisabled), Threshold: arduino.DefaultOverrideThreshold},
+		Publish: Publish{
+			Mode:        string(arduino.PublishTicker),
+			Epsilon:     arduino.DefaultPublishEpsilon,
+			MinInterval: Duration(arduino.DefaultPublishMinInterval),
+			Heartbeat:   Duration(arduino.DefaultPublishHeartbeat),
+		},
+	}
+}
+
+// Load reads json config file over current values, unknown fields are rejected
+func (c *Config) Load(fileName string) error {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return fmt.Errorf("unable to read content from %s file: %w", fileName, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("unable to unmarshal json content from %s file: %w", fileName, err)
+	}
+	return nil
+}
+
+// Validate checks all values are consistent
+func (c *Config) Validate() error {
+	if c.Serial.Device == "" {
+		return fmt.Errorf("no serial device")
+	}
+	if c.Serial.Baud <= 0 {
+		return fmt.Errorf("invalid serial baud %d", c.Serial.Baud)
+	}
+	if _, err := arduino.ParseProtocol(c.Serial.Protocol); err != nil {
+		return fmt.Errorf("invalid serial config: %w", err)
+	}
+	if c.MQTT.Broker == "" {
+		return fmt.Errorf("no mqtt broker")
+	}
+	if c.MQTT.Qos < 0 || c.MQTT.Qos > 2 {
+		return fmt.Errorf("invalid mqtt qos %v, should be 0, 1 or 2", c.MQTT.Qos)
+	}
+	if c.MQTT.PubFrequency <= 0 {
+		return fmt.Errorf("invalid mqtt publish frequency %v", c.MQTT.PubFrequency)
+	}
+	for topic, p := range c.MQTT.TopicPolicies {
+		if err := p.Validate(); err != nil {
+			return fmt.Errorf("invalid policy of topic %v: %w", topic, err)
+		}
+	}
+	for name, pwm := range map[string]*arduino.PWMConfig{
+		"steering":           &c.PWM.Steering,
+		"throttle":           &c.PWM.Throttle,
+		"max_throttle_ctrl":  &c.PWM.MaxThrottleCtrl,
+		"secondary_steering": &c.PWM.SecondarySteering,
+		"secondary_throttle": &c.PWM.SecondaryThrottle,
+	} {
+		if err := pwm.Validate(); err != nil {
+			return fmt.Errorf("invalid %v pwm config: %w", name, err)
+		}
+	}
+	if c.ThrottleFeedback == nil {
+		return fmt.Errorf("no throttle feedback thresholds")
+	}
+	if err := c.ThrottleFeedback.Validate(); err != nil {
+		return fmt.Errorf("invalid throttle feedback thresholds: %w", err)
+	}
+	if err := c.DriveModes.Validate(); err != nil {
+		return fmt.Errorf("invalid drive mode table: %w", err)
+	}
+	if err := c.SwitchConfig().Validate(); err != nil {
+		return fmt.Errorf("invalid switches config: %w", err)
+	}
+	if len(c.ChannelMapping) > 0 {
+		if err := c.ChannelMapping.Validate(); err != nil {
+			return fmt.Errorf("invalid channel mapping: %w", err)
+		}
+	}
+	if err := c.Failsafe.Validate(); err != nil {
+		return fmt.Errorf("invalid failsafe config: %w", err)
+	}
+	if _, err := arduino.NewCurves(c.Curves); err != nil {
+		return fmt.Errorf("invalid response curves: %w", err)
+	}
+	if _, err := arduino.NewFilters(c.Filters); err != nil {
+		return fmt.Errorf("invalid filters: %w", err)
+	}
+	if _, err := arduino.ParseOverridePriority(c.Override.Priority); err != nil {
+		return err
+	}
+	if _, err := arduino.ParsePublishMode(c.Publish.Mode); err != nil {
+		return err
+	}
+	if c.Speed != nil {
+		if err := c.Speed.Validate(); err != nil {
+			return fmt.Errorf("invalid speed config: %w", err)
+		}
+	} else if c.MQTT.Topics.Speed != "" {
+		return fmt.Errorf("no speed config to publish speed on %v topic", c.MQTT.Topics.Speed)
+	}
+	return nil
+}
+
+func (f *Failsafe) Validate() error {
+	if f.Timeout < 0 || f.ReceiverDelay < 0 || f.FrequencyDelay < 0 {
+		return fmt.Errorf("durations can't be negative")
+	}
+	for ch, d := range f.ChannelTimeouts {
+		if d < 0 {
+			return fmt.Errorf("timeout of channel %d can't be negative", ch)
+		}
+	}
+	if f.ReceiverTolerance < 0 || f.MinFrequency < 0 {
+		return fmt.Errorf("receiver tolerance and min frequency can't be negative")
+	}
+	return nil
+}
+
+// Mapping returns channel mapping, arduino.DefaultChannelMapping if empty
+func (c *Config) Mapping() arduino.ChannelMapping {
+	if len(c.ChannelMapping) == 0 {
+		return arduino.DefaultChannelMapping
+	}
+	return c.ChannelMapping
+}
+
+// SwitchConfig returns decoding config of drive mode and record switches
+func (c *Config) SwitchConfig() *arduino.SwitchConfig {
+	return &arduino.SwitchConfig{
+		DriveModes:          c.DriveModes,
+		RecordMin:           c.Switches.RecordMinPWM,
+		DriveModeHysteresis: c.Switches.DriveModeHysteresis,
+		RecordHysteresis:    c.Switches.RecordHysteresis,
+		DriveModeDebounce:   time.Duration(c.Switches.DriveModeDebounce),
+		RecordDebounce:      time.Duration(c.Switches.RecordDebounce),
+	}
+}
+
+// FailsafeConfig returns failsafe config of arduino part
+func (c *Config) FailsafeConfig() *arduino.FailsafeConfig {
+	f := c.Failsafe
+	channelTimeouts := make(map[int]time.Duration, len(f.ChannelTimeouts))
+	for ch, d := range f.ChannelTimeouts {
+		channelTimeouts[ch] = time.Duration(d)
+	}
+	return &arduino.FailsafeConfig{
+		LineTimeout:       time.Duration(f.Timeout),
+		ChannelTimeouts:   channelTimeouts,
+		ReceiverPWM:       f.ReceiverPWM,
+		ReceiverTolerance: f.ReceiverTolerance,
+		ReceiverDelay:     time.Duration(f.ReceiverDelay),
+		MinFrequency:      f.MinFrequency,
+		FrequencyDelay:    time.Duration(f.FrequencyDelay),
+	}
+}
+
+// EventPublishConfig returns config of event publish mode, nil in ticker mode
+func (c *Config) EventPublishConfig() *arduino.EventPublishConfig {
+	if mode, _ := arduino.ParsePublishMode(c.Publish.Mode); mode != arduino.PublishEvent {
+		return nil
+	}
+	minIntervals := make(map[arduino.ChannelRole]time.Duration, len(c.Publish.MinIntervals))
+	for role, d := range c.Publish.MinIntervals {
+		minIntervals[arduino.ChannelRole(role)] = time.Duration(d)
+	}
+	return &arduino.EventPublishConfig{
+		Epsilon:      float32(c.Publish.Epsilon),
+		MinInterval:  time.Duration(c.Publish.MinInterval),
+		MinIntervals: minIntervals,
+		Heartbeat:    time.Duration(c.Publish.Heartbeat),
+	}
+}
+
+// Reload returns copy of current config where pwm configs and throttle feedback thresholds are replaced with values
+// of reloaded config. Values of current config that differ from base one were given by env or args, they keep
+// precedence over reloaded values.
+func Reload(base, current, reloaded *Config) *Config {
+	c := *current
+	c.PWM = PWM{
+		Steering:          reloadPWM(base.PWM.Steering, current.PWM.Steering, reloaded.PWM.Steering),
+		Throttle:          reloadPWM(base.PWM.Throttle, current.PWM.Throttle, reloaded.PWM.Throttle),
+		MaxThrottleCtrl:   reloadPWM(base.PWM.MaxThrottleCtrl, current.PWM.MaxThrottleCtrl, reloaded.PWM.MaxThrottleCtrl),
+		SecondarySteering: reloadPWM(base.PWM.SecondarySteering, current.PWM.SecondarySteering, reloaded.PWM.SecondarySteering),
+		SecondaryThrottle: reloadPWM(base.PWM.SecondaryThrottle, current.PWM.SecondaryThrottle, reloaded.PWM.SecondaryThrottle),
+	}
+	if reflect.DeepEqual(base.ThrottleFeedback, current.ThrottleFeedback) {
+		c.ThrottleFeedback = reloaded.ThrottleFeedback
+	}
+	return &c
+}
+
+func reloadPWM(base, current, reloaded arduino.PWMConfig) arduino.PWMConfig {
+	c := reloaded
+	if current.Min != base.Min {
+		c.Min = current.Min
+	}
+	if current.Max != base.Max {
+		c.Max = current.Max
+	}
+	if current.Middle != base.Middle {
+		c.Middle = current.Middle
+	}
+	if current.Deadband != base.Deadband {
+		c.Deadband = current.Deadband
+	}
+	if current.Trim != base.Trim {
+		c.Trim = current.Trim
+	}
+	if current.Reverse != base.Reverse {
+		c.Reverse = current.Reverse
+	}
+	return c
+}
+
+// RuntimeConfig returns configs of c that can be reloaded while arduino part is running
+func (c *Config) RuntimeConfig() *arduino.RuntimeConfig {
+	pwm := c.PWM
+	return &arduino.RuntimeConfig{
+		Steering:          &pwm.Steering,
+		Throttle:          &pwm.Throttle,
+		MaxThrottleCtrl:   &pwm.MaxThrottleCtrl,
+		SecondarySteering: &pwm.SecondarySteering,
+		SecondaryThrottle: &pwm.SecondaryThrottle,
+		ThrottleFeedback:  c.ThrottleFeedback,
+	}
+}
+
+// Print writes config as json, secrets are redacted
+func (c *Config) Print(w io.Writer) error {
+	printed := *c
+	if printed.MQTT.Password != "" {
+		printed.MQTT.Password = redacted
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(&printed); err != nil {
+		return fmt.Errorf("unable to marshal config: %w", err)
+	}
+	return nil
+}
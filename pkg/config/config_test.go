@@ -0,0 +1,214 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/cyrilix/robocar-arduino/pkg/arduino"
+	"github.com/cyrilix/robocar-arduino/pkg/curve"
+	"github.com/cyrilix/robocar-arduino/pkg/speed"
+	"github.com/cyrilix/robocar-arduino/pkg/tools"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefault(t *testing.T) {
+	c := Default()
+	if err := c.Validate(); err != nil {
+		t.Errorf("default config should be valid: %v", err)
+	}
+	if c.PWM.Throttle.Middle != DefaultThrottleZeroPWM {
+		t.Errorf("bad default throttle middle: %v, want %v", c.PWM.Throttle.Middle, DefaultThrottleZeroPWM)
+	}
+}
+
+func TestConfig_Load(t *testing.T) {
+	c := Default()
+	if err := c.Load("test_data/config.json"); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	want := Default()
+	want.Serial.Device = "/dev/ttyUSB0"
+	want.Serial.Protocol = "binary"
+	want.MQTT.Broker = "tcp://mqtt:1883"
+	want.MQTT.Password = "secret"
+	want.MQTT.Topics.Throttle = "car/part/arduino/throttle/target"
+	want.MQTT.Topics.Steering = "car/part/arduino/steering"
+	want.PWM.Steering = arduino.PWMConfig{Min: 1000, Max: 2000, Middle: 1490, Deadband: 10}
+	want.PWM.Throttle.Reverse = true
+	want.PWM.MaxThrottleCtrl = arduino.PWMConfig{Min: 1000, Max: 2000, Middle: 1500, Reverse: true}
+	want.ThrottleFeedback = &tools.ThresholdConfig{ThresholdSteps: []float64{0.1, 0.5, 1.0}, MinValid: 500, Data: []int{3000, 1000, 600}}
+	want.DriveModes = arduino.DriveModeTable{
+		{Min: 0, Max: 1499, Mode: events.DriveMode_USER},
+		{Min: 1500, Max: 3000, Mode: events.DriveMode_PILOT},
+	}
+	want.Speed = &speed.Config{PoleCount: 4, GearRatio: 10.5, WheelDiameter: 0.065}
+	want.MQTT.TopicPolicies = map[string]arduino.PublishPolicy{"car/part/arduino/drive_mode": {Qos: 1, Retain: true}}
+	want.Switches.DriveModeHysteresis = 20
+	want.Switches.DriveModeDebounce = Duration(50 * time.Millisecond)
+	want.Failsafe.Timeout = Duration(500 * time.Millisecond)
+	want.Failsafe.ChannelTimeouts = map[int]Duration{1: Duration(300 * time.Millisecond)}
+	want.Filters = map[string]string{"steering": "median:5"}
+	want.Override.Priority = "secondary"
+	want.Publish.Mode = "event"
+	want.Publish.MinIntervals = map[string]Duration{"throttle": Duration(10 * time.Millisecond)}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("Load() got = %+v, want %+v", c, want)
+	}
+	if err := c.Validate(); err != nil {
+		t.Errorf("loaded config should be valid: %v", err)
+	}
+
+	if f := c.FailsafeConfig(); f.LineTimeout != 500*time.Millisecond || f.ChannelTimeouts[1] != 300*time.Millisecond {
+		t.Errorf("bad failsafe config: %+v", f)
+	}
+	if s := c.SwitchConfig(); s.DriveModeDebounce != 50*time.Millisecond || !reflect.DeepEqual(s.DriveModes, c.DriveModes) {
+		t.Errorf("bad switch config: %+v", s)
+	}
+	if p := c.EventPublishConfig(); p == nil || p.MinIntervals[arduino.RoleThrottle] != 10*time.Millisecond {
+		t.Errorf("bad event publish config: %+v", p)
+	}
+
+	// Default thresholds are not modified by loaded values
+	if !reflect.DeepEqual(tools.NewThresholdConfig().Data, Default().ThrottleFeedback.Data) {
+		t.Errorf("default thresholds have been modified: %v", tools.NewThresholdConfig().Data)
+	}
+}
+
+func TestConfig_Load_unknownField(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(fileName, []byte(`{"serial": {"bauds": 9600}}`), 0o644); err != nil {
+		t.Fatalf("unable to write config: %v", err)
+	}
+	if err := Default().Load(fileName); err == nil {
+		t.Errorf("Load() should reject unknown field")
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name   string
+		update func(c *Config)
+	}{
+		{name: "no device", update: func(c *Config) { c.Serial.Device = "" }},
+		{name: "invalid baud", update: func(c *Config) { c.Serial.Baud = 0 }},
+		{name: "invalid protocol", update: func(c *Config) { c.Serial.Protocol = "xml" }},
+		{name: "no broker", update: func(c *Config) { c.MQTT.Broker = "" }},
+		{name: "invalid qos", update: func(c *Config) { c.MQTT.Qos = 3 }},
+		{name: "invalid publish frequency", update: func(c *Config) { c.MQTT.PubFrequency = 0 }},
+		{name: "steering middle out of range", update: func(c *Config) { c.PWM.Steering.Middle = 900 }},
+		{name: "throttle min greater than max", update: func(c *Config) { c.PWM.Throttle.Min, c.PWM.Throttle.Max = 2000, 1000 }},
+		{name: "secondary throttle deadband too large", update: func(c *Config) { c.PWM.SecondaryThrottle.Deadband = 1000 }},
+		{name: "unsorted thresholds", update: func(c *Config) { c.ThrottleFeedback.ThresholdSteps[0] = 2 }},
+		{name: "no thresholds", update: func(c *Config) { c.ThrottleFeedback = nil }},
+		{name: "drive mode gap", update: func(c *Config) { c.DriveModes[1].Min += 10 }},
+		{name: "speed topic without speed config", update: func(c *Config) { c.MQTT.Topics.Speed = "car/part/arduino/speed" }},
+		{name: "invalid speed config", update: func(c *Config) { c.Speed = &speed.Config{PoleCount: 4, GearRatio: 10} }},
+		{name: "invalid topic policy", update: func(c *Config) { c.MQTT.TopicPolicies = map[string]arduino.PublishPolicy{"t": {Qos: 3}} }},
+		{name: "negative hysteresis", update: func(c *Config) { c.Switches.RecordHysteresis = -1 }},
+		{name: "duplicated channel role", update: func(c *Config) {
+			c.ChannelMapping = arduino.ChannelMapping{1: arduino.RoleSteering, 2: arduino.RoleSteering}
+		}},
+		{name: "negative failsafe timeout", update: func(c *Config) { c.Failsafe.Timeout = -1 }},
+		{name: "unknown curve role", update: func(c *Config) { c.Curves = map[string]curve.Config{"drive-mode": {Type: "linear"}} }},
+		{name: "invalid filter", update: func(c *Config) { c.Filters = map[string]string{"steering": "median:x"} }},
+		{name: "invalid override priority", update: func(c *Config) { c.Override.Priority = "both" }},
+		{name: "invalid publish mode", update: func(c *Config) { c.Publish.Mode = "random" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			tt.update(c)
+			if err := c.Validate(); err == nil {
+				t.Errorf("Validate() should fail")
+			}
+		})
+	}
+}
+
+func TestConfig_Print(t *testing.T) {
+	c := Default()
+	c.MQTT.Password = "secret"
+	var buf bytes.Buffer
+	if err := c.Print(&buf); err != nil {
+		t.Fatalf("Print() error = %v", err)
+	}
+	if strings.Contains(buf.String(), "secret") {
+		t.Errorf("password should be redacted: %v", buf.String())
+	}
+	if c.MQTT.Password != "secret" {
+		t.Errorf("printed config should not be modified")
+	}
+
+	// Printed config can be loaded again
+	var printed Config
+	if err := json.Unmarshal(buf.Bytes(), &printed); err != nil {
+		t.Fatalf("unable to unmarshal printed config: %v", err)
+	}
+	printed.MQTT.Password = "secret"
+	if !reflect.DeepEqual(&printed, c) {
+		t.Errorf("printed config = %+v, want %+v", printed, c)
+	}
+}
+
+func TestReload(t *testing.T) {
+	base := Default()
+	current := Default()
+	// Values given by env or args
+	current.PWM.Steering.Deadband = 20
+	current.PWM.Throttle.Reverse = true
+
+	reloaded := Default()
+	if err := reloaded.Load("test_data/config.json"); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	reloaded.MQTT.Broker = "tcp://other:1883"
+
+	got := Reload(base, current, reloaded)
+	want := arduino.PWMConfig{Min: 1000, Max: 2000, Middle: 1490, Deadband: 20}
+	if got.PWM.Steering != want {
+		t.Errorf("steering config = %+v, want %+v", got.PWM.Steering, want)
+	}
+	if got.PWM.Throttle != current.PWM.Throttle {
+		t.Errorf("throttle config = %+v, want %+v", got.PWM.Throttle, current.PWM.Throttle)
+	}
+	if rc := got.RuntimeConfig(); *rc.MaxThrottleCtrl != reloaded.PWM.MaxThrottleCtrl {
+		t.Errorf("max throttle ctrl config = %+v, want %+v", *rc.MaxThrottleCtrl, reloaded.PWM.MaxThrottleCtrl)
+	}
+	if !reflect.DeepEqual(got.ThrottleFeedback, reloaded.ThrottleFeedback) {
+		t.Errorf("throttle feedback = %+v, want %+v", got.ThrottleFeedback, reloaded.ThrottleFeedback)
+	}
+	if got.MQTT.Broker != current.MQTT.Broker {
+		t.Errorf("only runtime configs should be reloaded, broker = %v", got.MQTT.Broker)
+	}
+
+	// Thresholds given by args keep precedence
+	current.ThrottleFeedback = &tools.ThresholdConfig{ThresholdSteps: []float64{0, 1}, MinValid: 500, Data: []int{2000, 1000}}
+	if got := Reload(base, current, reloaded); got.ThrottleFeedback != current.ThrottleFeedback {
+		t.Errorf("throttle feedback = %+v, want %+v", got.ThrottleFeedback, current.ThrottleFeedback)
+	}
+}
+
+func TestDuration_json(t *testing.T) {
+	var d Duration
+	if err := json.Unmarshal([]byte(`"1m30s"`), &d); err != nil {
+		t.Fatalf("unable to unmarshal duration: %v", err)
+	}
+	if d != Duration(90*time.Second) {
+		t.Errorf("bad duration %v, want %v", d, 90*time.Second)
+	}
+	if err := json.Unmarshal([]byte(`1000`), &d); err == nil {
+		t.Errorf("numeric duration should be rejected")
+	}
+	b, err := json.Marshal(Duration(500 * time.Millisecond))
+	if err != nil {
+		t.Fatalf("unable to marshal duration: %v", err)
+	}
+	if string(b) != `"500ms"` {
+		t.Errorf("bad marshalled duration %v", string(b))
+	}
+}
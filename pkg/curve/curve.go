@@ -0,0 +1,171 @@
+// Package curve provides response curves applied on percent values of sticks, like RC transmitters expo and dual
+// rates.
+package curve
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+const (
+	TypeLinear    = "linear"
+	TypeExpo      = "expo"
+	TypePiecewise = "piecewise"
+)
+
+// Curve maps a percent value in [-1, 1] to a new percent value
+type Curve interface {
+	Apply(x float64) float64
+}
+
+func clamp(x float64) float64 {
+	if x < -1. {
+		return -1.
+	}
+	if x > 1. {
+		return 1.
+	}
+	return x
+}
+
+// Linear returns values unchanged
+type Linear struct{}
+
+func (Linear) Apply(x float64) float64 {
+	return clamp(x)
+}
+
+// Expo softens values near center: y = (1-expo)*x + expo*x³, with expo in [0, 1]
+type Expo struct {
+	Expo float64
+}
+
+func (e Expo) Apply(x float64) float64 {
+	x = clamp(x)
+	return (1-e.Expo)*x + e.Expo*x*x*x
+}
+
+// Point is a point of a piecewise linear curve
+type Point struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// Piecewise interpolates linearly between points sorted by x, from x=-1 to x=1
+type Piecewise struct {
+	Points []Point
+}
+
+func (p Piecewise) Apply(x float64) float64 {
+	x = clamp(x)
+	i := sort.Search(len(p.Points), func(i int) bool { return p.Points[i].X >= x })
+	if i == 0 {
+		return p.Points[0].Y
+	}
+	if i == len(p.Points) {
+		return p.Points[len(p.Points)-1].Y
+	}
+	p0, p1 := p.Points[i-1], p.Points[i]
+	return p0.Y + (x-p0.X)*(p1.Y-p0.Y)/(p1.X-p0.X)
+}
+
+// DualRate scales output of a curve by rate, in ]0, 1]
+type DualRate struct {
+	Rate  float64
+	Curve Curve
+}
+
+func (d DualRate) Apply(x float64) float64 {
+	return d.Rate * d.Curve.Apply(x)
+}
+
+// Config describes a curve in config file
+type Config struct {
+	// Type is the curve type: linear, expo or piecewise
+	Type string `json:"type"`
+	// Expo is the expo rate of expo curve, in [0, 1]
+	Expo float64 `json:"expo,omitempty"`
+	// Points are the points of piecewise curve
+	Points []Point `json:"points,omitempty"`
+	// Rate scales curve output (dual rate), no scale if 0
+	Rate float64 `json:"rate,omitempty"`
+}
+
+// Curve builds and validates curve described by config
+func (c *Config) Curve() (Curve, error) {
+	var curve Curve
+	var err error
+	switch c.Type {
+	case TypeLinear, "":
+		curve = Linear{}
+	case TypeExpo:
+		curve, err = newExpo(c)
+	case TypePiecewise:
+		curve, err = newPiecewise(c)
+	default:
+		return nil, fmt.Errorf("unknown curve type '%v'", c.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %v curve: %w", c.Type, err)
+	}
+	if c.Rate == 0. || c.Rate == 1. {
+		return curve, nil
+	}
+	if c.Rate < 0. || c.Rate > 1. {
+		return nil, fmt.Errorf("invalid rate %v, should be in ]0, 1]", c.Rate)
+	}
+	return DualRate{Rate: c.Rate, Curve: curve}, nil
+}
+
+func newExpo(c *Config) (Curve, error) {
+	if c.Expo < 0. || c.Expo > 1. {
+		return nil, fmt.Errorf("expo %v should be in [0, 1]", c.Expo)
+	}
+	return Expo{Expo: c.Expo}, nil
+}
+
+func newPiecewise(c *Config) (Curve, error) {
+	points := c.Points
+	if len(points) < 2 {
+		return nil, fmt.Errorf("at least 2 points are required")
+	}
+	if points[0].X != -1. || points[len(points)-1].X != 1. {
+		return nil, fmt.Errorf("points should start at x=-1 and end at x=1")
+	}
+	for i, p := range points {
+		if p.Y < -1. || p.Y > 1. {
+			return nil, fmt.Errorf("y of point %d (%v, %v) should be in [-1, 1]", i, p.X, p.Y)
+		}
+		if i == 0 {
+			continue
+		}
+		if p.X <= points[i-1].X {
+			return nil, fmt.Errorf("points should be sorted by strictly increasing x, point %d (%v, %v)", i, p.X, p.Y)
+		}
+		if p.Y < points[i-1].Y {
+			return nil, fmt.Errorf("curve should be monotonic, point %d (%v, %v) is lower than previous one", i, p.X, p.Y)
+		}
+	}
+	return Piecewise{Points: append([]Point(nil), points...)}, nil
+}
+
+// NewConfigsFromJson reads curve configs by name (ex: channel role)
+func NewConfigsFromJson(fileName string) (map[string]Config, error) {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read content from %s file: %w", fileName, err)
+	}
+	var configs map[string]Config
+	err = json.Unmarshal(content, &configs)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unmarshal json content from %s file: %w", fileName, err)
+	}
+	for name, c := range configs {
+		if _, err := c.Curve(); err != nil {
+			return nil, fmt.Errorf("invalid curve '%v' in %s file: %w", name, fileName, err)
+		}
+	}
+	return configs, nil
+}
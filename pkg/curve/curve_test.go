@@ -0,0 +1,125 @@
+package curve
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func mustCurve(t *testing.T, c Config) Curve {
+	t.Helper()
+	curve, err := c.Curve()
+	if err != nil {
+		t.Fatalf("unable to build curve %+v: %v", c, err)
+	}
+	return curve
+}
+
+func TestCurves(t *testing.T) {
+	threePoints := []Point{{-1, -1}, {0, 0}, {1, 1}}
+	softCenter := []Point{{-1, -1}, {-0.5, -0.2}, {0, 0}, {0.5, 0.2}, {1, 1}}
+	tests := []struct {
+		name   string
+		config Config
+		// want are expected values at x=-1, x=0 and x=1
+		want [3]float64
+	}{
+		{name: "default", config: Config{}, want: [3]float64{-1, 0, 1}},
+		{name: "linear", config: Config{Type: TypeLinear}, want: [3]float64{-1, 0, 1}},
+		{name: "expo 0", config: Config{Type: TypeExpo, Expo: 0}, want: [3]float64{-1, 0, 1}},
+		{name: "expo 0.5", config: Config{Type: TypeExpo, Expo: 0.5}, want: [3]float64{-1, 0, 1}},
+		{name: "expo 1", config: Config{Type: TypeExpo, Expo: 1}, want: [3]float64{-1, 0, 1}},
+		{name: "piecewise", config: Config{Type: TypePiecewise, Points: threePoints}, want: [3]float64{-1, 0, 1}},
+		{name: "piecewise soft center", config: Config{Type: TypePiecewise, Points: softCenter}, want: [3]float64{-1, 0, 1}},
+		{name: "dual rate", config: Config{Type: TypeLinear, Rate: 0.6}, want: [3]float64{-0.6, 0, 0.6}},
+		{name: "expo with dual rate", config: Config{Type: TypeExpo, Expo: 0.4, Rate: 0.5}, want: [3]float64{-0.5, 0, 0.5}},
+		{name: "piecewise with dual rate", config: Config{Type: TypePiecewise, Points: softCenter, Rate: 0.5}, want: [3]float64{-0.5, 0, 0.5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := mustCurve(t, tt.config)
+			for i, x := range []float64{-1, 0, 1} {
+				if got := c.Apply(x); math.Abs(got-tt.want[i]) > 1e-9 {
+					t.Errorf("Apply(%v) = %v, want %v", x, got, tt.want[i])
+				}
+			}
+			// Out of range values are clamped
+			if got := c.Apply(-1.5); got != c.Apply(-1) {
+				t.Errorf("Apply(-1.5) = %v, want %v", got, c.Apply(-1))
+			}
+			if got := c.Apply(1.5); got != c.Apply(1) {
+				t.Errorf("Apply(1.5) = %v, want %v", got, c.Apply(1))
+			}
+			// Monotonicity
+			previous := c.Apply(-1)
+			for x := -1.; x <= 1.; x += 0.001 {
+				y := c.Apply(x)
+				if y < previous {
+					t.Fatalf("curve not monotonic at x=%v: %v < %v", x, y, previous)
+				}
+				if y < -1 || y > 1 {
+					t.Fatalf("Apply(%v) = %v, out of [-1, 1]", x, y)
+				}
+				previous = y
+			}
+		})
+	}
+}
+
+func TestExpo_softCenter(t *testing.T) {
+	c := mustCurve(t, Config{Type: TypeExpo, Expo: 0.5})
+	if got := c.Apply(0.5); got != 0.3125 {
+		t.Errorf("Apply(0.5) = %v, want %v", got, 0.3125)
+	}
+	if got := c.Apply(-0.5); got != -0.3125 {
+		t.Errorf("Apply(-0.5) = %v, want %v", got, -0.3125)
+	}
+}
+
+func TestPiecewise_Apply(t *testing.T) {
+	c := mustCurve(t, Config{Type: TypePiecewise, Points: []Point{{-1, -1}, {0, 0}, {0.5, 0.3}, {1, 1}}})
+	for x, want := range map[float64]float64{-0.5: -0.5, 0.25: 0.15, 0.5: 0.3, 0.75: 0.65} {
+		if got := c.Apply(x); math.Abs(got-want) > 1e-9 {
+			t.Errorf("Apply(%v) = %v, want %v", x, got, want)
+		}
+	}
+}
+
+func TestConfig_Curve_invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{name: "unknown type", config: Config{Type: "sigmoid"}},
+		{name: "negative expo", config: Config{Type: TypeExpo, Expo: -0.1}},
+		{name: "too high expo", config: Config{Type: TypeExpo, Expo: 1.1}},
+		{name: "negative rate", config: Config{Type: TypeLinear, Rate: -0.5}},
+		{name: "too high rate", config: Config{Type: TypeLinear, Rate: 1.5}},
+		{name: "single point", config: Config{Type: TypePiecewise, Points: []Point{{-1, -1}}}},
+		{name: "missing endpoint", config: Config{Type: TypePiecewise, Points: []Point{{-1, -1}, {0.5, 1}}}},
+		{name: "unsorted points", config: Config{Type: TypePiecewise, Points: []Point{{-1, -1}, {0.5, 0.5}, {0, 0}, {1, 1}}}},
+		{name: "not monotonic", config: Config{Type: TypePiecewise, Points: []Point{{-1, -1}, {0, 0.5}, {0.5, 0.2}, {1, 1}}}},
+		{name: "point out of range", config: Config{Type: TypePiecewise, Points: []Point{{-1, -1.5}, {1, 1}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.config.Curve(); err == nil {
+				t.Errorf("Curve() should fail for %+v", tt.config)
+			}
+		})
+	}
+}
+
+func TestNewConfigsFromJson(t *testing.T) {
+	got, err := NewConfigsFromJson("test_data/curves.json")
+	if err != nil {
+		t.Fatalf("NewConfigsFromJson() error = %v", err)
+	}
+	want := map[string]Config{
+		"steering": {Type: TypeExpo, Expo: 0.3, Rate: 0.8},
+		"throttle": {Type: TypePiecewise, Points: []Point{{-1, -1}, {0, 0}, {0.5, 0.3}, {1, 1}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NewConfigsFromJson() got = %v, want %v", got, want)
+	}
+}
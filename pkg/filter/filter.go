@@ -0,0 +1,256 @@
+// Package filter provides filters applied on raw pwm values of channels to smooth receiver jitter.
+//
+// Filters are described as 'name:param' and can be chained with ',' (ex: 'median:5,ema:0.3'):
+//
+//   - moving-average:N averages last N values
+//   - ema:alpha is an exponential low-pass filter, alpha in ]0, 1], lower values smooth more
+//   - median:N returns median of last N values, to reject spikes
+//   - slew:N limits changes to N pwm units per value
+package filter
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	TypeMovingAverage = "moving-average"
+	TypeEMA           = "ema"
+	TypeMedian        = "median"
+	TypeSlew          = "slew"
+)
+
+// Filter is a stateful filter, each value is filtered according to previous ones
+type Filter interface {
+	Apply(value int) int
+	// Reset forgets previous values, next value is filtered as the first one
+	Reset()
+}
+
+// MovingAverage averages last values
+type MovingAverage struct {
+	values []int
+	next   int
+	count  int
+	sum    int
+}
+
+func NewMovingAverage(size int) *MovingAverage {
+	return &MovingAverage{values: make([]int, size)}
+}
+
+func (m *MovingAverage) Apply(value int) int {
+	if m.count == len(m.values) {
+		m.sum -= m.values[m.next]
+	} else {
+		m.count++
+	}
+	m.values[m.next] = value
+	m.sum += value
+	m.next = (m.next + 1) % len(m.values)
+	return int(math.Round(float64(m.sum) / float64(m.count)))
+}
+
+func (m *MovingAverage) Reset() {
+	m.next, m.count, m.sum = 0, 0, 0
+}
+
+// EMA is an exponential moving average: y = y + alpha * (x - y)
+type EMA struct {
+	alpha       float64
+	value       float64
+	initialized bool
+}
+
+func NewEMA(alpha float64) *EMA {
+	return &EMA{alpha: alpha}
+}
+
+func (e *EMA) Apply(value int) int {
+	if !e.initialized {
+		e.value = float64(value)
+		e.initialized = true
+	} else {
+		e.value += e.alpha * (float64(value) - e.value)
+	}
+	return int(math.Round(e.value))
+}
+
+func (e *EMA) Reset() {
+	e.initialized = false
+}
+
+// Median returns median of last values
+type Median struct {
+	values []int
+	sorted []int
+	next   int
+	count  int
+}
+
+func NewMedian(size int) *Median {
+	return &Median{values: make([]int, size), sorted: make([]int, 0, size)}
+}
+
+func (m *Median) Apply(value int) int {
+	if m.count < len(m.values) {
+		m.count++
+	}
+	m.values[m.next] = value
+	m.next = (m.next + 1) % len(m.values)
+
+	m.sorted = append(m.sorted[:0], m.values[:m.count]...)
+	sort.Ints(m.sorted)
+	if m.count%2 == 1 {
+		return m.sorted[m.count/2]
+	}
+	return int(math.Round(float64(m.sorted[m.count/2-1]+m.sorted[m.count/2]) / 2))
+}
+
+func (m *Median) Reset() {
+	m.next, m.count = 0, 0
+}
+
+// Slew limits difference between 2 consecutive values
+type Slew struct {
+	max         int
+	value       int
+	initialized bool
+}
+
+func NewSlew(max int) *Slew {
+	return &Slew{max: max}
+}
+
+func (s *Slew) Apply(value int) int {
+	switch {
+	case !s.initialized:
+		s.value = value
+		s.initialized = true
+	case value > s.value+s.max:
+		s.value += s.max
+	case value < s.value-s.max:
+		s.value -= s.max
+	default:
+		s.value = value
+	}
+	return s.value
+}
+
+func (s *Slew) Reset() {
+	s.initialized = false
+}
+
+// Chain applies filters in order
+type Chain []Filter
+
+func (c Chain) Apply(value int) int {
+	for _, f := range c {
+		value = f.Apply(value)
+	}
+	return value
+}
+
+func (c Chain) Reset() {
+	for _, f := range c {
+		f.Reset()
+	}
+}
+
+// New builds a filter from its description, 'name:param' or list of 'name:param' separated by ','
+func New(spec string) (Filter, error) {
+	var chain Chain
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		name, param, _ := strings.Cut(s, ":")
+		name = strings.TrimSpace(name)
+		f, err := newFilter(name, strings.TrimSpace(param))
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, f)
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no filter in '%v'", spec)
+	}
+	if len(chain) == 1 {
+		return chain[0], nil
+	}
+	return chain, nil
+}
+
+func newFilter(name, param string) (Filter, error) {
+	var f Filter
+	var err error
+	switch name {
+	case TypeMovingAverage:
+		f, err = newMovingAverage(param)
+	case TypeEMA:
+		f, err = newEMA(param)
+	case TypeMedian:
+		f, err = newMedian(param)
+	case TypeSlew:
+		f, err = newSlew(param)
+	default:
+		return nil, fmt.Errorf("unknown filter '%v'", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %v filter: %w", name, err)
+	}
+	return f, nil
+}
+
+func parseSize(param string) (int, error) {
+	size, err := strconv.Atoi(param)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size '%v': %w", param, err)
+	}
+	if size < 1 {
+		return 0, fmt.Errorf("size %d should be at least 1", size)
+	}
+	return size, nil
+}
+
+func newMovingAverage(param string) (Filter, error) {
+	size, err := parseSize(param)
+	if err != nil {
+		return nil, err
+	}
+	return NewMovingAverage(size), nil
+}
+
+func newMedian(param string) (Filter, error) {
+	size, err := parseSize(param)
+	if err != nil {
+		return nil, err
+	}
+	return NewMedian(size), nil
+}
+
+func newEMA(param string) (Filter, error) {
+	alpha, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid alpha '%v': %w", param, err)
+	}
+	if alpha <= 0. || alpha > 1. {
+		return nil, fmt.Errorf("alpha %v should be in ]0, 1]", alpha)
+	}
+	return NewEMA(alpha), nil
+}
+
+func newSlew(param string) (Filter, error) {
+	max, err := strconv.Atoi(param)
+	if err != nil {
+		return nil, fmt.Errorf("invalid max change '%v': %w", param, err)
+	}
+	if max < 1 {
+		return nil, fmt.Errorf("max change %d should be at least 1", max)
+	}
+	return NewSlew(max), nil
+}
@@ -0,0 +1,154 @@
+package filter
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type sample struct {
+	raw, expected int
+}
+
+func loadStream(t *testing.T, fileName string) []sample {
+	t.Helper()
+	f, err := os.Open(fileName)
+	if err != nil {
+		t.Fatalf("unable to open %v: %v", fileName, err)
+	}
+	defer f.Close()
+
+	var samples []sample
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		raw, err := strconv.Atoi(fields[0])
+		if err != nil {
+			t.Fatalf("invalid raw value in line '%v': %v", line, err)
+		}
+		expected, err := strconv.Atoi(fields[1])
+		if err != nil {
+			t.Fatalf("invalid expected value in line '%v': %v", line, err)
+		}
+		samples = append(samples, sample{raw: raw, expected: expected})
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("unable to read %v: %v", fileName, err)
+	}
+	return samples
+}
+
+func mustFilter(t *testing.T, spec string) Filter {
+	t.Helper()
+	f, err := New(spec)
+	if err != nil {
+		t.Fatalf("unable to build filter '%v': %v", spec, err)
+	}
+	return f
+}
+
+// errors returns rms and max absolute errors of filtered values against expected ones
+func errors(samples []sample, f Filter) (rms float64, max int) {
+	sum := 0.
+	for _, s := range samples {
+		e := s.expected - f.Apply(s.raw)
+		if e < 0 {
+			e = -e
+		}
+		if e > max {
+			max = e
+		}
+		sum += float64(e * e)
+	}
+	return math.Sqrt(sum / float64(len(samples))), max
+}
+
+func TestFilters_noisyStream(t *testing.T) {
+	samples := loadStream(t, "test_data/noisy_steering.csv")
+	rawRMS, rawMax := errors(samples, Chain{})
+
+	tests := []struct {
+		spec string
+		// maxError is the max absolute error expected after filtering, 0 to not check
+		maxError int
+	}{
+		{spec: "moving-average:5"},
+		{spec: "ema:0.3"},
+		{spec: "median:5", maxError: 20},
+		{spec: "slew:10", maxError: 20},
+		{spec: "median:5,ema:0.5", maxError: 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			rms, max := errors(samples, mustFilter(t, tt.spec))
+			if rms >= rawRMS {
+				t.Errorf("rms error %v of filtered values should be lower than raw one %v", rms, rawRMS)
+			}
+			if max > rawMax {
+				t.Errorf("max error %v of filtered values should not be higher than raw one %v", max, rawMax)
+			}
+			if tt.maxError > 0 && max > tt.maxError {
+				t.Errorf("max error %v, want at most %v", max, tt.maxError)
+			}
+		})
+	}
+}
+
+func TestSlew_Apply(t *testing.T) {
+	f := mustFilter(t, "slew:10")
+	previous := f.Apply(1500)
+	for _, s := range loadStream(t, "test_data/noisy_steering.csv") {
+		v := f.Apply(s.raw)
+		if v-previous > 10 || previous-v > 10 {
+			t.Fatalf("change from %v to %v exceeds 10", previous, v)
+		}
+		previous = v
+	}
+}
+
+func TestFilters_Apply(t *testing.T) {
+	tests := []struct {
+		spec   string
+		values []int
+		want   []int
+	}{
+		{spec: "moving-average:3", values: []int{1500, 1530, 1560, 1590}, want: []int{1500, 1515, 1530, 1560}},
+		{spec: "ema:0.5", values: []int{1500, 1600, 1600, 1600}, want: []int{1500, 1550, 1575, 1588}},
+		{spec: "ema:1", values: []int{1500, 1600, 1400}, want: []int{1500, 1600, 1400}},
+		{spec: "median:3", values: []int{1500, 1800, 1510, 1520, 1000, 1530}, want: []int{1500, 1650, 1510, 1520, 1510, 1520}},
+		{spec: "slew:50", values: []int{1500, 1600, 1600, 1400}, want: []int{1500, 1550, 1600, 1550}},
+		{spec: "median:3,slew:50", values: []int{1500, 1800, 1510, 1600}, want: []int{1500, 1550, 1510, 1560}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			f := mustFilter(t, tt.spec)
+			// Filter must behave the same after a reset
+			for i := 0; i < 2; i++ {
+				got := make([]int, 0, len(tt.values))
+				for _, v := range tt.values {
+					got = append(got, f.Apply(v))
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("Apply(%v) = %v, want %v", tt.values, got, tt.want)
+				}
+				f.Reset()
+			}
+		})
+	}
+}
+
+func TestNew_invalid(t *testing.T) {
+	for _, spec := range []string{"", "kalman:3", "median", "median:0", "moving-average:x", "ema:0", "ema:1.5", "slew:-5", "median:5,ema"} {
+		if _, err := New(spec); err == nil {
+			t.Errorf("New(%q) should fail", spec)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+// Package frame implements the binary protocol used by arduino to send channel values.
+//
+// A frame is composed of:
+//
+//	sync (2 bytes: 0xA5 0x5A) | length (1 byte) | payload (length bytes) | crc16 (2 bytes, little endian)
+//
+// The payload starts with the protocol version. Version 1 payload is:
+//
+//	version (1 byte) | timestamp (uint32, millis) | 9 channels (int16) | frequency (uint16)
+//
+// Commands sent to arduino use the same framing with a version 1 command payload:
+//
+//	version (1 byte) | steering pwm (int16) | throttle pwm (int16)
+//
+// All integers are little endian and crc16 (CRC-16/CCITT-FALSE) is computed over length and payload bytes.
+package frame
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+const (
+	Sync1 byte = 0xA5
+	Sync2 byte = 0x5A
+
+	Version1 byte = 1
+
+	ChannelCount = 9
+
+	headerSize  = 3 // sync + length
+	crcSize     = 2
+	payloadSize = 1 + 4 + ChannelCount*2 + 2
+	Size        = headerSize + payloadSize + crcSize
+
+	commandPayloadSize = 1 + 2 + 2
+	CommandSize        = headerSize + commandPayloadSize + crcSize
+)
+
+// Frame holds values sent by arduino
+type Frame struct {
+	Version   byte
+	Timestamp uint32
+	// Channels values, index 0 is channel 1
+	Channels  [ChannelCount]int
+	Frequency int
+}
+
+// Channel returns value of channel (1 to 9)
+func (f *Frame) Channel(channel int) (int, bool) {
+	if channel < 1 || channel > ChannelCount {
+		return 0, false
+	}
+	return f.Channels[channel-1], true
+}
+
+// Marshal encodes frame to its binary representation
+func Marshal(f *Frame) []byte {
+	buf := make([]byte, Size)
+	buf[0] = Sync1
+	buf[1] = Sync2
+	buf[2] = payloadSize
+
+	payload := buf[headerSize : headerSize+payloadSize]
+	payload[0] = Version1
+	binary.LittleEndian.PutUint32(payload[1:], f.Timestamp)
+	for i, v := range f.Channels {
+		binary.LittleEndian.PutUint16(payload[5+2*i:], uint16(int16(v)))
+	}
+	binary.LittleEndian.PutUint16(payload[5+2*ChannelCount:], uint16(f.Frequency))
+
+	binary.LittleEndian.PutUint16(buf[headerSize+payloadSize:], CRC16(buf[2:headerSize+payloadSize]))
+	return buf
+}
+
+// Command holds pwm values to apply on arduino outputs
+type Command struct {
+	Steering int
+	Throttle int
+}
+
+// MarshalCommand encodes command to its binary representation
+func MarshalCommand(c *Command) []byte {
+	buf := make([]byte, CommandSize)
+	buf[0] = Sync1
+	buf[1] = Sync2
+	buf[2] = commandPayloadSize
+
+	payload := buf[headerSize : headerSize+commandPayloadSize]
+	payload[0] = Version1
+	binary.LittleEndian.PutUint16(payload[1:], uint16(int16(c.Steering)))
+	binary.LittleEndian.PutUint16(payload[3:], uint16(int16(c.Throttle)))
+
+	binary.LittleEndian.PutUint16(buf[headerSize+commandPayloadSize:], CRC16(buf[2:headerSize+commandPayloadSize]))
+	return buf
+}
+
+func unmarshalPayload(payload []byte) (*Frame, error) {
+	if len(payload) != payloadSize {
+		return nil, fmt.Errorf("invalid payload size %d, expected %d", len(payload), payloadSize)
+	}
+	if payload[0] != Version1 {
+		return nil, fmt.Errorf("unsupported frame version %d", payload[0])
+	}
+	f := Frame{
+		Version:   payload[0],
+		Timestamp: binary.LittleEndian.Uint32(payload[1:]),
+		Frequency: int(binary.LittleEndian.Uint16(payload[5+2*ChannelCount:])),
+	}
+	for i := range f.Channels {
+		f.Channels[i] = int(int16(binary.LittleEndian.Uint16(payload[5+2*i:])))
+	}
+	return &f, nil
+}
+
+// CRC16 computes CRC-16/CCITT-FALSE checksum
+func CRC16(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// Stats is a snapshot of decoder counters
+type Stats struct {
+	Frames        uint64
+	CRCErrors     uint64
+	InvalidFrames uint64
+	SkippedBytes  uint64
+}
+
+// Counters are decoder counters, they could be shared by several decoders
+type Counters struct {
+	frames        atomic.Uint64
+	crcErrors     atomic.Uint64
+	invalidFrames atomic.Uint64
+	skippedBytes  atomic.Uint64
+}
+
+func (c *Counters) Snapshot() Stats {
+	return Stats{
+		Frames:        c.frames.Load(),
+		CRCErrors:     c.crcErrors.Load(),
+		InvalidFrames: c.invalidFrames.Load(),
+		SkippedBytes:  c.skippedBytes.Load(),
+	}
+}
+
+type Decoder struct {
+	r        *bufio.Reader
+	counters *Counters
+}
+
+// NewDecoder creates a decoder reading frames from r, counters could be nil
+func NewDecoder(r io.Reader, counters *Counters) *Decoder {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	if counters == nil {
+		counters = &Counters{}
+	}
+	return &Decoder{r: br, counters: counters}
+}
+
+func (d *Decoder) Stats() Stats {
+	return d.counters.Snapshot()
+}
+
+// Decode returns next valid frame. Invalid bytes and frames are skipped until decoder is synchronised on a new frame.
+func (d *Decoder) Decode() (*Frame, error) {
+	for {
+		b, err := d.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b != Sync1 {
+			d.counters.skippedBytes.Add(1)
+			continue
+		}
+		next, err := d.r.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if next[0] != Sync2 {
+			d.counters.skippedBytes.Add(1)
+			continue
+		}
+		if _, err := d.r.Discard(1); err != nil {
+			return nil, err
+		}
+
+		// Only peek data: on error, decoder resynchronizes from length byte
+		length, err := d.r.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if int(length[0]) != payloadSize {
+			d.counters.invalidFrames.Add(1)
+			continue
+		}
+		buf, err := d.r.Peek(1 + payloadSize + crcSize)
+		if err != nil {
+			return nil, err
+		}
+		if CRC16(buf[:1+payloadSize]) != binary.LittleEndian.Uint16(buf[1+payloadSize:]) {
+			d.counters.crcErrors.Add(1)
+			continue
+		}
+		f, err := unmarshalPayload(buf[1 : 1+payloadSize])
+		if err != nil {
+			d.counters.invalidFrames.Add(1)
+			continue
+		}
+		if _, err := d.r.Discard(len(buf)); err != nil {
+			return nil, err
+		}
+		d.counters.frames.Add(1)
+		return f, nil
+	}
+}
@@ -0,0 +1,161 @@
+package frame
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestCRC16(t *testing.T) {
+	if got := CRC16([]byte("123456789")); got != 0x29B1 {
+		t.Errorf("CRC16() = %#x, want %#x", got, 0x29B1)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	f := Frame{
+		Version:   Version1,
+		Timestamp: 123456789,
+		Channels:  [ChannelCount]int{1004, 1986, 1500, 8700, 1900, -1, 0, 2008, 32767},
+		Frequency: 50,
+	}
+	buf := Marshal(&f)
+	if len(buf) != Size {
+		t.Fatalf("bad frame size, expected: %d, actual: %d", Size, len(buf))
+	}
+	if buf[0] != Sync1 || buf[1] != Sync2 || buf[2] != payloadSize || buf[3] != Version1 {
+		t.Errorf("bad frame header: %x", buf[:4])
+	}
+
+	got, err := NewDecoder(bytes.NewReader(buf), nil).Decode()
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if !reflect.DeepEqual(*got, f) {
+		t.Errorf("Decode() = %v, want %v", *got, f)
+	}
+}
+
+func TestMarshalCommand(t *testing.T) {
+	buf := MarshalCommand(&Command{Steering: 1986, Throttle: -1})
+	want := []byte{Sync1, Sync2, commandPayloadSize, Version1, 0xC2, 0x07, 0xFF, 0xFF}
+	if !bytes.Equal(buf[:len(want)], want) {
+		t.Errorf("MarshalCommand() = %x, want prefix %x", buf, want)
+	}
+	if len(buf) != CommandSize {
+		t.Fatalf("bad command size, expected: %d, actual: %d", CommandSize, len(buf))
+	}
+	if crc := CRC16(buf[2 : CommandSize-crcSize]); buf[CommandSize-2] != byte(crc) || buf[CommandSize-1] != byte(crc>>8) {
+		t.Errorf("bad command crc: %x", buf[CommandSize-2:])
+	}
+}
+
+func TestDecoder_Decode(t *testing.T) {
+	f1 := Frame{Version: Version1, Timestamp: 1, Channels: [ChannelCount]int{1000, 1100, 1200, 1300, 1400, 1500, 1600, 1700, 1800}, Frequency: 50}
+	f2 := Frame{Version: Version1, Timestamp: 2, Channels: [ChannelCount]int{1800, 1700, 1600, 1500, 1400, 1300, 1200, 1100, 1000}, Frequency: 49}
+	f3 := Frame{Version: Version1, Timestamp: 3, Channels: [ChannelCount]int{-1, -1, -1, -1, -1, -1, -1, -1, -1}, Frequency: 48}
+
+	corrupted := Marshal(&f2)
+	corrupted[10] ^= 0x01
+	badLength := Marshal(&f2)
+	badLength[2] = 200
+
+	var stream bytes.Buffer
+	stream.WriteString("12345,1500,1500\n")
+	stream.Write(Marshal(&f1))
+	stream.Write(corrupted)
+	stream.Write([]byte{Sync1, 0x00})
+	stream.Write(badLength)
+	stream.Write(Marshal(&f3))
+	// Truncated frame
+	stream.Write(Marshal(&f1)[:10])
+
+	d := NewDecoder(&stream, nil)
+	var got []Frame
+	for {
+		f, err := d.Decode()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				t.Errorf("Decode() unexpected error = %v", err)
+			}
+			break
+		}
+		got = append(got, *f)
+	}
+
+	want := []Frame{f1, f3}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode() = %v, want %v", got, want)
+	}
+	stats := d.Stats()
+	if stats.Frames != 2 {
+		t.Errorf("bad frames counter, expected: %d, actual: %d", 2, stats.Frames)
+	}
+	if stats.CRCErrors != 1 {
+		t.Errorf("bad crc errors counter, expected: %d, actual: %d", 1, stats.CRCErrors)
+	}
+	if stats.InvalidFrames != 1 {
+		t.Errorf("bad invalid frames counter, expected: %d, actual: %d", 1, stats.InvalidFrames)
+	}
+	if stats.SkippedBytes == 0 {
+		t.Errorf("skipped bytes should be counted")
+	}
+}
+
+func TestDecoder_sharedCounters(t *testing.T) {
+	var counters Counters
+	f := Frame{Version: Version1, Timestamp: 1}
+	for i := 0; i < 2; i++ {
+		if _, err := NewDecoder(bytes.NewReader(Marshal(&f)), &counters).Decode(); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+	}
+	if counters.Snapshot().Frames != 2 {
+		t.Errorf("bad frames counter, expected: %d, actual: %d", 2, counters.Snapshot().Frames)
+	}
+}
+
+func FuzzDecoder(f *testing.F) {
+	f.Add(Marshal(&Frame{Version: Version1, Timestamp: 42, Channels: [ChannelCount]int{1500, 1500, 1500, 1500, 1500, 1500, 0, 0, 0}, Frequency: 50}))
+	f.Add([]byte("12345,1500,1500,1500,1500,1500,1500,0,0,0,50\n"))
+	f.Add([]byte{Sync1, Sync2, payloadSize, Version1})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d := NewDecoder(bytes.NewReader(data), nil)
+		for {
+			fr, err := d.Decode()
+			if err != nil {
+				return
+			}
+			// Each decoded frame should be encoded to same value
+			decoded, err := NewDecoder(bytes.NewReader(Marshal(fr)), nil).Decode()
+			if err != nil {
+				t.Fatalf("unable to decode marshalled frame %v: %v", fr, err)
+			}
+			if !reflect.DeepEqual(decoded, fr) {
+				t.Fatalf("bad round trip, %v != %v", decoded, fr)
+			}
+		}
+	})
+}
+
+func FuzzMarshal(f *testing.F) {
+	f.Add(uint32(12345), int16(1500), int16(-1), uint16(50))
+	f.Fuzz(func(t *testing.T, timestamp uint32, channel int16, driveMode int16, frequency uint16) {
+		fr := Frame{Version: Version1, Timestamp: timestamp, Frequency: int(frequency)}
+		for i := range fr.Channels {
+			fr.Channels[i] = int(channel)
+		}
+		fr.Channels[5] = int(driveMode)
+
+		got, err := NewDecoder(bytes.NewReader(Marshal(&fr)), nil).Decode()
+		if err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		if !reflect.DeepEqual(*got, fr) {
+			t.Errorf("Decode() = %v, want %v", *got, fr)
+		}
+	})
+}
@@ -0,0 +1,217 @@
+// Package metrics implements a minimal set of metrics exposed in Prometheus text format
+// (https://prometheus.io/docs/instrumenting/exposition_formats/).
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuckets are histogram upper bounds in seconds, adapted to latencies of a few milliseconds
+var DefaultBuckets = []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}
+
+// Counter is a monotonic counter, zero value is ready to use
+type Counter struct {
+	v atomic.Uint64
+}
+
+func (c *Counter) Inc() {
+	c.v.Add(1)
+}
+
+func (c *Counter) Add(n uint64) {
+	c.v.Add(n)
+}
+
+func (c *Counter) Value() uint64 {
+	return c.v.Load()
+}
+
+// Gauge is a value that can go up and down, zero value is ready to use
+type Gauge struct {
+	bits atomic.Uint64
+}
+
+func (g *Gauge) Set(v float64) {
+	g.bits.Store(math.Float64bits(v))
+}
+
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+// Histogram counts observations in buckets, zero value uses DefaultBuckets
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func NewHistogram(buckets []float64) *Histogram {
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	return &Histogram{buckets: b}
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.init()
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i] += 1
+		}
+	}
+	h.sum += v
+	h.count += 1
+}
+
+// init sets default buckets, mutex should be locked
+func (h *Histogram) init() {
+	if h.buckets == nil {
+		h.buckets = DefaultBuckets
+	}
+	if h.counts == nil {
+		h.counts = make([]uint64, len(h.buckets))
+	}
+}
+
+// HistogramSnapshot holds cumulative counts for each bucket upper bound
+type HistogramSnapshot struct {
+	Buckets []float64
+	Counts  []uint64
+	Sum     float64
+	Count   uint64
+}
+
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.init()
+	return HistogramSnapshot{
+		Buckets: append([]float64(nil), h.buckets...),
+		Counts:  append([]uint64(nil), h.counts...),
+		Sum:     h.sum,
+		Count:   h.count,
+	}
+}
+
+type metric struct {
+	name, help, kind string
+	value            func() float64
+	histogram        *Histogram
+}
+
+// Registry holds metrics to expose, metrics are written in registration order
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.metrics {
+		if existing.name == m.name {
+			panic(fmt.Sprintf("metric %v already registered", m.name))
+		}
+	}
+	r.metrics = append(r.metrics, m)
+}
+
+func (r *Registry) Counter(name, help string, c *Counter) {
+	r.register(metric{name: name, help: help, kind: "counter", value: func() float64 { return float64(c.Value()) }})
+}
+
+// CounterFunc registers a counter whose value is read from f on each collect
+func (r *Registry) CounterFunc(name, help string, f func() float64) {
+	r.register(metric{name: name, help: help, kind: "counter", value: f})
+}
+
+func (r *Registry) Gauge(name, help string, g *Gauge) {
+	r.register(metric{name: name, help: help, kind: "gauge", value: g.Value})
+}
+
+// GaugeFunc registers a gauge whose value is read from f on each collect
+func (r *Registry) GaugeFunc(name, help string, f func() float64) {
+	r.register(metric{name: name, help: help, kind: "gauge", value: f})
+}
+
+func (r *Registry) Histogram(name, help string, h *Histogram) {
+	r.register(metric{name: name, help: help, kind: "histogram", histogram: h})
+}
+
+// WriteTo writes all metrics in Prometheus text format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, m := range metrics {
+		fmt.Fprintf(cw, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(cw, "# TYPE %s %s\n", m.name, m.kind)
+		if m.histogram == nil {
+			fmt.Fprintf(cw, "%s %s\n", m.name, formatFloat(m.value()))
+			continue
+		}
+		s := m.histogram.Snapshot()
+		for i, b := range s.Buckets {
+			fmt.Fprintf(cw, "%s_bucket{le=\"%s\"} %d\n", m.name, formatFloat(b), s.Counts[i])
+		}
+		fmt.Fprintf(cw, "%s_bucket{le=\"+Inf\"} %d\n", m.name, s.Count)
+		fmt.Fprintf(cw, "%s_sum %s\n", m.name, formatFloat(s.Sum))
+		fmt.Fprintf(cw, "%s_count %d\n", m.name, s.Count)
+	}
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	return cw.n, cw.w.Flush()
+}
+
+// ServeHTTP exposes metrics, registry could be used as /metrics handler
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = r.WriteTo(w)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
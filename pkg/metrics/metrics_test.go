@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_WriteTo(t *testing.T) {
+	var c Counter
+	var g Gauge
+	h := NewHistogram([]float64{0.1, 0.01})
+	c.Add(3)
+	c.Inc()
+	g.Set(-1.5)
+	for _, v := range []float64{0.005, 0.05, 0.5} {
+		h.Observe(v)
+	}
+
+	r := NewRegistry()
+	r.Counter("frames_total", "Number of frames", &c)
+	r.Gauge("temperature", "Current temperature", &g)
+	r.GaugeFunc("up", "Link state", func() float64 { return 1 })
+	r.Histogram("latency_seconds", "Latency", h)
+
+	var buf bytes.Buffer
+	n, err := r.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("bad written bytes count, expected: %v, actual: %v", buf.Len(), n)
+	}
+	want := `# HELP frames_total Number of frames
+# TYPE frames_total counter
+frames_total 4
+# HELP temperature Current temperature
+# TYPE temperature gauge
+temperature -1.5
+# HELP up Link state
+# TYPE up gauge
+up 1
+# HELP latency_seconds Latency
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.01"} 1
+latency_seconds_bucket{le="0.1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 0.555
+latency_seconds_count 3
+`
+	if buf.String() != want {
+		t.Errorf("WriteTo() =\n%v\nwant:\n%v", buf.String(), want)
+	}
+}
+
+func TestRegistry_duplicate(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("duplicate metric should panic")
+		}
+	}()
+	r := NewRegistry()
+	r.GaugeFunc("up", "", func() float64 { return 1 })
+	r.GaugeFunc("up", "", func() float64 { return 1 })
+}
+
+func TestHistogram_zeroValue(t *testing.T) {
+	var h Histogram
+	h.Observe(0.003)
+	s := h.Snapshot()
+	if len(s.Buckets) != len(DefaultBuckets) {
+		t.Errorf("zero value histogram should use default buckets")
+	}
+	if s.Count != 1 || s.Counts[len(s.Counts)-1] != 1 {
+		t.Errorf("bad histogram snapshot: %+v", s)
+	}
+}
+
+func TestRegistry_ServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.GaugeFunc("up", "Link state", func() float64 { return 1 })
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("bad content type: %v", rec.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rec.Body.String(), "\nup 1\n") {
+		t.Errorf("metric not found in body: %v", rec.Body.String())
+	}
+}
@@ -0,0 +1,148 @@
+// Package record captures raw serial stream with host timestamps and replays it later as a serial stream.
+//
+// A session file contains one json record per line:
+//
+//	{"time":"2023-09-30T10:00:00.123456789Z","data":"<base64 bytes>"}
+package record
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"sync"
+	"time"
+)
+
+// Record is a chunk of bytes read on serial port
+type Record struct {
+	Time time.Time `json:"time"`
+	Data []byte    `json:"data"`
+}
+
+// Recorder is a reader that writes each chunk read from underlying reader to a session file. Recording is best
+// effort: after a write error, recording is disabled and underlying reader is still read.
+type Recorder struct {
+	r   io.Reader
+	mu  sync.Mutex
+	enc *json.Encoder
+	now func() time.Time
+	err error
+}
+
+func NewRecorder(r io.Reader, w io.Writer) *Recorder {
+	return &Recorder{r: r, enc: json.NewEncoder(w), now: time.Now}
+}
+
+func (r *Recorder) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.err != nil {
+			return n, err
+		}
+		data := make([]byte, n)
+		copy(data, p[:n])
+		if errRecord := r.enc.Encode(&Record{Time: r.now(), Data: data}); errRecord != nil {
+			r.err = errRecord
+			zap.S().Errorf("unable to record serial data, recording is disabled: %v", errRecord)
+		}
+	}
+	return n, err
+}
+
+// Err returns write error that disabled recording, nil while recording
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Write forwards content to underlying reader if it's also a writer, written content is not recorded
+func (r *Recorder) Write(p []byte) (int, error) {
+	w, ok := r.r.(io.Writer)
+	if !ok {
+		return 0, errors.New("recorded stream is read only")
+	}
+	return w.Write(p)
+}
+
+func (r *Recorder) Close() error {
+	if c, ok := r.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Replayer is a reader that returns content of a session file. When speed is > 0, recorded timing is honoured
+// (speed 2 replays twice faster than real time), else content is returned as fast as possible.
+type Replayer struct {
+	dec     *json.Decoder
+	src     io.Reader
+	speed   float64
+	pending []byte
+
+	first, start time.Time
+	done         chan struct{}
+	closeOnce    sync.Once
+}
+
+func NewReplayer(r io.Reader, speed float64) *Replayer {
+	return &Replayer{
+		dec:   json.NewDecoder(bufio.NewReader(r)),
+		src:   r,
+		speed: speed,
+		done:  make(chan struct{}),
+	}
+}
+
+func (r *Replayer) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		var rec Record
+		if err := r.dec.Decode(&rec); err != nil {
+			if errors.Is(err, io.EOF) {
+				return 0, io.EOF
+			}
+			return 0, fmt.Errorf("unable to decode session record: %w", err)
+		}
+		if err := r.wait(rec.Time); err != nil {
+			return 0, err
+		}
+		r.pending = rec.Data
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// wait until record time is reached on replay clock
+func (r *Replayer) wait(t time.Time) error {
+	if r.first.IsZero() {
+		r.first = t
+		r.start = time.Now()
+	}
+	if r.speed <= 0 {
+		return nil
+	}
+	delay := time.Duration(float64(t.Sub(r.first))/r.speed) - time.Since(r.start)
+	if delay <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-r.done:
+		return io.ErrClosedPipe
+	}
+}
+
+func (r *Replayer) Close() error {
+	r.closeOnce.Do(func() { close(r.done) })
+	if c, ok := r.src.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
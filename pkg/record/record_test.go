@@ -0,0 +1,178 @@
+package record
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newSession(t *testing.T, records ...Record) *bytes.Buffer {
+	var session bytes.Buffer
+	enc := json.NewEncoder(&session)
+	for i := range records {
+		if err := enc.Encode(&records[i]); err != nil {
+			t.Fatalf("unable to encode record: %v", err)
+		}
+	}
+	return &session
+}
+
+func TestRecorder(t *testing.T) {
+	content := "12345,1500,1500,1500,1500,1500,1500,0,0,0,50\n12350,1500,1500,1500,1500,1500,1500,0,0,0,50\n"
+	var session bytes.Buffer
+	r := NewRecorder(io.LimitReader(strings.NewReader(content), int64(len(content))), &session)
+	now := time.Date(2023, 9, 30, 10, 0, 0, 0, time.UTC)
+	r.now = func() time.Time {
+		now = now.Add(10 * time.Millisecond)
+		return now
+	}
+
+	buf := make([]byte, 16)
+	var read []byte
+	for {
+		n, err := r.Read(buf)
+		read = append(read, buf[:n]...)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+	}
+	if string(read) != content {
+		t.Errorf("Read() = %v, want %v", string(read), content)
+	}
+
+	dec := json.NewDecoder(&session)
+	var recorded []byte
+	var last time.Time
+	count := 0
+	for {
+		var rec Record
+		if err := dec.Decode(&rec); err != nil {
+			if !errors.Is(err, io.EOF) {
+				t.Errorf("unable to decode record: %v", err)
+			}
+			break
+		}
+		if !rec.Time.After(last) {
+			t.Errorf("record times should be increasing: %v <= %v", rec.Time, last)
+		}
+		last = rec.Time
+		recorded = append(recorded, rec.Data...)
+		count++
+	}
+	if string(recorded) != content {
+		t.Errorf("recorded content = %v, want %v", string(recorded), content)
+	}
+	if count != (len(content)+len(buf)-1)/len(buf) {
+		t.Errorf("bad number of records: %v", count)
+	}
+}
+
+// failingWriter fails on each write
+type failingWriter struct {
+	writes int
+}
+
+func (f *failingWriter) Write(_ []byte) (int, error) {
+	f.writes++
+	return 0, errors.New("no space left on device")
+}
+
+func TestRecorder_writeError(t *testing.T) {
+	content := "12345,1500,1500,1500,1500,1500,1500,0,0,0,50\n"
+	w := failingWriter{}
+	r := NewRecorder(strings.NewReader(content), &w)
+
+	buf := make([]byte, 16)
+	var read []byte
+	for {
+		n, err := r.Read(buf)
+		read = append(read, buf[:n]...)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read() should not fail on record error: %v", err)
+		}
+	}
+	if string(read) != content {
+		t.Errorf("Read() = %v, want %v", string(read), content)
+	}
+	if r.Err() == nil {
+		t.Errorf("Err() should return record error")
+	}
+	if w.writes != 1 {
+		t.Errorf("recording should be disabled after first error, writes: %v", w.writes)
+	}
+}
+
+func TestRecorder_Write(t *testing.T) {
+	if _, err := NewRecorder(strings.NewReader(""), io.Discard).Write([]byte("1500,1500\n")); err == nil {
+		t.Errorf("Write() on read only stream should fail")
+	}
+}
+
+func TestReplayer(t *testing.T) {
+	start := time.Date(2023, 9, 30, 10, 0, 0, 0, time.UTC)
+	records := []Record{
+		{Time: start, Data: []byte("12345,1500,1500,")},
+		{Time: start.Add(10 * time.Millisecond), Data: []byte("1500,1500,1500,1500,0,0,0,50\n")},
+		{Time: start.Add(100 * time.Millisecond), Data: []byte("12350,1500,1500,1500,1500,1500,1500,0,0,0,50\n")},
+	}
+	want := "12345,1500,1500,1500,1500,1500,1500,0,0,0,50\n12350,1500,1500,1500,1500,1500,1500,0,0,0,50\n"
+
+	tests := []struct {
+		name       string
+		speed      float64
+		minElapsed time.Duration
+		maxElapsed time.Duration
+	}{
+		{name: "real time", speed: 1., minElapsed: 100 * time.Millisecond, maxElapsed: time.Second},
+		{name: "twice faster", speed: 2., minElapsed: 50 * time.Millisecond, maxElapsed: 100 * time.Millisecond},
+		{name: "as fast as possible", speed: 0., minElapsed: 0, maxElapsed: 20 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			begin := time.Now()
+			got, err := io.ReadAll(NewReplayer(newSession(t, records...), tt.speed))
+			elapsed := time.Since(begin)
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			if string(got) != want {
+				t.Errorf("ReadAll() = %v, want %v", string(got), want)
+			}
+			if elapsed < tt.minElapsed || elapsed > tt.maxElapsed {
+				t.Errorf("bad replay duration %v, should be between %v and %v", elapsed, tt.minElapsed, tt.maxElapsed)
+			}
+		})
+	}
+}
+
+func TestReplayer_Close(t *testing.T) {
+	start := time.Now()
+	r := NewReplayer(newSession(t,
+		Record{Time: start, Data: []byte("first\n")},
+		Record{Time: start.Add(time.Hour), Data: []byte("second\n")},
+	), 1.)
+
+	buf := make([]byte, 64)
+	if _, err := r.Read(buf); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		if err := r.Close(); err != nil {
+			t.Errorf("Close() error = %v", err)
+		}
+	}()
+	if _, err := r.Read(buf); err == nil {
+		t.Errorf("Read() should fail after Close()")
+	}
+}
@@ -0,0 +1,70 @@
+package simulator
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+)
+
+// Pty is a pseudo-terminal: simulator writes lines on master side, Name is the device to open as serial port
+type Pty struct {
+	Master *os.File
+	Name   string
+	// slave is kept open so that master reads don't fail when serial port is closed by client
+	slave *os.File
+}
+
+// OpenPty opens a new pseudo-terminal in raw mode
+func OpenPty() (*Pty, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open pty master: %w", err)
+	}
+	fd := int(master.Fd())
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		_ = master.Close()
+		return nil, fmt.Errorf("unable to unlock pty: %w", err)
+	}
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		_ = master.Close()
+		return nil, fmt.Errorf("unable to get pty number: %w", err)
+	}
+	name := fmt.Sprintf("/dev/pts/%d", n)
+
+	slave, err := os.OpenFile(name, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		_ = master.Close()
+		return nil, fmt.Errorf("unable to open pty slave %v: %w", name, err)
+	}
+	if err := makeRaw(int(slave.Fd())); err != nil {
+		_ = slave.Close()
+		_ = master.Close()
+		return nil, fmt.Errorf("unable to set raw mode on %v: %w", name, err)
+	}
+	return &Pty{Master: master, Name: name, slave: slave}, nil
+}
+
+// makeRaw disables echo and line processing, as cfmakeraw
+func makeRaw(fd int) error {
+	t, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return err
+	}
+	t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	t.Oflag &^= unix.OPOST
+	t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	t.Cflag &^= unix.CSIZE | unix.PARENB
+	t.Cflag |= unix.CS8
+	t.Cc[unix.VMIN] = 1
+	t.Cc[unix.VTIME] = 0
+	return unix.IoctlSetTermios(fd, unix.TCSETS, t)
+}
+
+func (p *Pty) Close() error {
+	errSlave := p.slave.Close()
+	if err := p.Master.Close(); err != nil {
+		return err
+	}
+	return errSlave
+}
@@ -0,0 +1,35 @@
+package simulator
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestOpenPty(t *testing.T) {
+	pty, err := OpenPty()
+	if err != nil {
+		t.Skipf("pseudo-terminal not available: %v", err)
+	}
+	defer pty.Close()
+
+	go func() {
+		_, _ = io.Copy(pty.Master, New(Scenario{Hold(40 * time.Millisecond)}))
+	}()
+
+	port, err := os.OpenFile(pty.Name, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("unable to open %v: %v", pty.Name, err)
+	}
+	defer port.Close()
+
+	line, err := bufio.NewReader(port).ReadString('\n')
+	if err != nil {
+		t.Fatalf("unable to read line from pty: %v", err)
+	}
+	if line != "0,1500,1500,1500,1500,1900,1000,1500,1500,0,50\n" {
+		t.Errorf("bad line read from pty: %q", line)
+	}
+}
@@ -0,0 +1,347 @@
+// Package simulator emulates an arduino wired to a RC receiver. It emits lines in arduino serial format
+//
+//	timestamp,channel_1,...,channel_9,frequency
+//
+// from a scripted scenario (stick sweeps, drive mode switches, record toggles, dropouts...). A Simulator is an
+// io.Reader that could be used in place of serial port, or written on a pseudo-terminal to feed the real binary.
+package simulator
+
+import (
+	"fmt"
+	"github.com/cyrilix/robocar-arduino/pkg/frame"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"io"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultFrequency = 50
+
+	SteeringChannel     = 1
+	ThrottleChannel     = 2
+	SwitchRecordChannel = 5
+	DriveModeChannel    = 6
+
+	PwmMin    = 1000
+	PwmCenter = 1500
+	PwmMax    = 2000
+
+	RecordOnPwm  = 1000
+	RecordOffPwm = 1900
+)
+
+// DriveModePwm are values sent on drive mode channel for each mode
+var DriveModePwm = map[events.DriveMode]int{
+	events.DriveMode_USER:    1000,
+	events.DriveMode_COPILOT: 1500,
+	events.DriveMode_PILOT:   1950,
+}
+
+// Channels holds pwm values, index 0 is channel 1
+type Channels [frame.ChannelCount]int
+
+// Neutral is the transmitter state at rest: sticks centered, record off and user drive mode
+var Neutral = Channels{PwmCenter, PwmCenter, PwmCenter, PwmCenter, RecordOffPwm, 1000, PwmCenter, PwmCenter, 0}
+
+// Step is a part of scenario
+type Step struct {
+	Duration time.Duration
+	// Channels computes channel values at elapsed time since step beginning, from values at end of previous step
+	Channels func(elapsed time.Duration, previous Channels) Channels
+	// Silent steps don't emit any line, as if serial link was lost
+	Silent bool
+}
+
+type Scenario []Step
+
+// Hold keeps channel values unchanged
+func Hold(d time.Duration) Step {
+	return Step{
+		Duration: d,
+		Channels: func(_ time.Duration, previous Channels) Channels { return previous },
+	}
+}
+
+// Set changes channel value then holds it
+func Set(channel, value int, d time.Duration) Step {
+	return Step{
+		Duration: d,
+		Channels: func(_ time.Duration, previous Channels) Channels {
+			previous[channel-1] = value
+			return previous
+		},
+	}
+}
+
+// Sweep moves linearly channel value from 'from' to 'to'
+func Sweep(channel, from, to int, d time.Duration) Step {
+	return Step{
+		Duration: d,
+		Channels: func(elapsed time.Duration, previous Channels) Channels {
+			previous[channel-1] = from + int(float64(to-from)*float64(elapsed)/float64(d))
+			return previous
+		},
+	}
+}
+
+// StickSweep moves stick from center to min, then to max and back to center
+func StickSweep(channel int, d time.Duration) Scenario {
+	return Scenario{
+		Sweep(channel, PwmCenter, PwmMin, d/4),
+		Sweep(channel, PwmMin, PwmMax, d/2),
+		Sweep(channel, PwmMax, PwmCenter, d/4),
+	}
+}
+
+// DriveMode switches drive mode channel then holds it
+func DriveMode(mode events.DriveMode, d time.Duration) Step {
+	return Set(DriveModeChannel, DriveModePwm[mode], d)
+}
+
+// RecordToggle toggles record switch then holds it
+func RecordToggle(d time.Duration) Step {
+	return Step{
+		Duration: d,
+		Channels: func(_ time.Duration, previous Channels) Channels {
+			if previous[SwitchRecordChannel-1] < 1800 {
+				previous[SwitchRecordChannel-1] = RecordOffPwm
+			} else {
+				previous[SwitchRecordChannel-1] = RecordOnPwm
+			}
+			return previous
+		},
+	}
+}
+
+// Dropout stops to emit lines
+func Dropout(d time.Duration) Step {
+	step := Hold(d)
+	step.Silent = true
+	return step
+}
+
+// Concat builds a scenario from steps and scenarios
+func Concat(parts ...interface{}) Scenario {
+	var s Scenario
+	for _, p := range parts {
+		switch v := p.(type) {
+		case Step:
+			s = append(s, v)
+		case Scenario:
+			s = append(s, v...)
+		default:
+			panic(fmt.Sprintf("unsupported scenario part %T", p))
+		}
+	}
+	return s
+}
+
+// Scenarios are predefined scenarios, indexed by name
+var Scenarios = map[string]Scenario{
+	"neutral": {Hold(time.Second)},
+	"sweep": Concat(
+		StickSweep(SteeringChannel, 2*time.Second),
+		StickSweep(ThrottleChannel, 2*time.Second),
+	),
+	"modes": {
+		DriveMode(events.DriveMode_USER, time.Second),
+		DriveMode(events.DriveMode_COPILOT, time.Second),
+		DriveMode(events.DriveMode_PILOT, time.Second),
+		DriveMode(events.DriveMode_USER, time.Second),
+	},
+	"record": {
+		Hold(500 * time.Millisecond),
+		RecordToggle(time.Second),
+		RecordToggle(500 * time.Millisecond),
+	},
+	"dropout": {
+		Hold(time.Second),
+		Dropout(2 * time.Second),
+		Hold(time.Second),
+	},
+	"demo": Concat(
+		Hold(time.Second),
+		RecordToggle(500*time.Millisecond),
+		StickSweep(SteeringChannel, 2*time.Second),
+		Sweep(ThrottleChannel, PwmCenter, 1700, time.Second),
+		DriveMode(events.DriveMode_COPILOT, time.Second),
+		DriveMode(events.DriveMode_PILOT, time.Second),
+		Dropout(time.Second),
+		DriveMode(events.DriveMode_USER, time.Second),
+		Set(ThrottleChannel, PwmCenter, 500*time.Millisecond),
+		RecordToggle(500*time.Millisecond),
+	),
+}
+
+// ScenarioNames returns sorted names of predefined scenarios
+func ScenarioNames() []string {
+	names := make([]string, 0, len(Scenarios))
+	for n := range Scenarios {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type Option func(s *Simulator)
+
+// WithFrequency sets number of lines emitted per second
+func WithFrequency(frequency int) Option {
+	return func(s *Simulator) {
+		s.frequency = frequency
+	}
+}
+
+// WithRealTime emits lines at scenario pace, by default lines are emitted as fast as possible
+func WithRealTime() Option {
+	return func(s *Simulator) {
+		s.realTime = true
+	}
+}
+
+// WithLoop restarts scenario when finished instead of returning io.EOF
+func WithLoop() Option {
+	return func(s *Simulator) {
+		s.loop = true
+	}
+}
+
+// WithNoise adds a random noise between -amplitude and +amplitude to channels with a pulse
+func WithNoise(amplitude int, seed int64) Option {
+	return func(s *Simulator) {
+		s.noise = amplitude
+		s.rand = rand.New(rand.NewSource(seed))
+	}
+}
+
+// WithInitialChannels sets channel values before first step, default to Neutral
+func WithInitialChannels(c Channels) Option {
+	return func(s *Simulator) {
+		s.values = c
+	}
+}
+
+type Simulator struct {
+	scenario  Scenario
+	frequency int
+	realTime  bool
+	loop      bool
+	noise     int
+	rand      *rand.Rand
+
+	// values at end of previous step
+	values      Channels
+	step        int
+	stepElapsed time.Duration
+	elapsed     time.Duration
+	pending     []byte
+
+	begin     time.Time
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func New(scenario Scenario, options ...Option) *Simulator {
+	s := &Simulator{
+		scenario:  scenario,
+		frequency: DefaultFrequency,
+		values:    Neutral,
+		done:      make(chan struct{}),
+	}
+	for _, o := range options {
+		o(s)
+	}
+	return s
+}
+
+func (s *Simulator) Read(p []byte) (int, error) {
+	for len(s.pending) == 0 {
+		line, err := s.next()
+		if err != nil {
+			return 0, err
+		}
+		s.pending = line
+	}
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+// next returns next line to emit
+func (s *Simulator) next() ([]byte, error) {
+	period := time.Second / time.Duration(s.frequency)
+	for {
+		select {
+		case <-s.done:
+			return nil, io.ErrClosedPipe
+		default:
+		}
+		if s.step >= len(s.scenario) {
+			if !s.loop || len(s.scenario) == 0 {
+				return nil, io.EOF
+			}
+			s.step = 0
+		}
+		st := s.scenario[s.step]
+		if s.stepElapsed >= st.Duration {
+			s.values = st.Channels(st.Duration, s.values)
+			s.step += 1
+			s.stepElapsed -= st.Duration
+			continue
+		}
+
+		values := st.Channels(s.stepElapsed, s.values)
+		at := s.elapsed
+		s.elapsed += period
+		s.stepElapsed += period
+		if st.Silent {
+			continue
+		}
+		if err := s.wait(at); err != nil {
+			return nil, err
+		}
+		return []byte(s.line(at, values)), nil
+	}
+}
+
+func (s *Simulator) line(at time.Duration, values Channels) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d", at.Milliseconds())
+	for _, v := range values {
+		if s.noise > 0 && v > 0 {
+			v += s.rand.Intn(2*s.noise+1) - s.noise
+		}
+		fmt.Fprintf(&b, ",%d", v)
+	}
+	fmt.Fprintf(&b, ",%d\n", s.frequency)
+	return b.String()
+}
+
+// wait until line time when real time is enabled
+func (s *Simulator) wait(at time.Duration) error {
+	if !s.realTime {
+		return nil
+	}
+	if s.begin.IsZero() {
+		s.begin = time.Now()
+	}
+	delay := time.Until(s.begin.Add(at))
+	if delay <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-s.done:
+		return io.ErrClosedPipe
+	}
+}
+
+func (s *Simulator) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	return nil
+}
@@ -0,0 +1,183 @@
+package simulator
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readLines(t *testing.T, r io.Reader) [][]int {
+	t.Helper()
+	var lines [][]int
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ",")
+		if len(fields) != 11 {
+			t.Fatalf("bad fields number in line '%v'", scanner.Text())
+		}
+		values := make([]int, len(fields))
+		for i, f := range fields {
+			v, err := strconv.Atoi(f)
+			if err != nil {
+				t.Fatalf("invalid field %d in line '%v': %v", i, scanner.Text(), err)
+			}
+			values[i] = v
+		}
+		lines = append(lines, values)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("unable to read lines: %v", err)
+	}
+	return lines
+}
+
+func TestSimulator(t *testing.T) {
+	tests := []struct {
+		name     string
+		scenario Scenario
+		options  []Option
+		want     []string
+	}{
+		{
+			name:     "hold",
+			scenario: Scenario{Hold(60 * time.Millisecond)},
+			options:  []Option{WithFrequency(50)},
+			want: []string{
+				"0,1500,1500,1500,1500,1900,1000,1500,1500,0,50",
+				"20,1500,1500,1500,1500,1900,1000,1500,1500,0,50",
+				"40,1500,1500,1500,1500,1900,1000,1500,1500,0,50",
+			},
+		},
+		{
+			name:     "sweep",
+			scenario: Scenario{Sweep(SteeringChannel, 1000, 2000, 40*time.Millisecond), Hold(10 * time.Millisecond)},
+			options:  []Option{WithFrequency(100)},
+			want: []string{
+				"0,1000,1500,1500,1500,1900,1000,1500,1500,0,100",
+				"10,1250,1500,1500,1500,1900,1000,1500,1500,0,100",
+				"20,1500,1500,1500,1500,1900,1000,1500,1500,0,100",
+				"30,1750,1500,1500,1500,1900,1000,1500,1500,0,100",
+				"40,2000,1500,1500,1500,1900,1000,1500,1500,0,100",
+			},
+		},
+		{
+			name: "drive mode and record",
+			scenario: Scenario{
+				DriveMode(events.DriveMode_PILOT, 20*time.Millisecond),
+				RecordToggle(20 * time.Millisecond),
+				RecordToggle(20 * time.Millisecond),
+			},
+			options: []Option{WithFrequency(50)},
+			want: []string{
+				"0,1500,1500,1500,1500,1900,1950,1500,1500,0,50",
+				"20,1500,1500,1500,1500,1000,1950,1500,1500,0,50",
+				"40,1500,1500,1500,1500,1900,1950,1500,1500,0,50",
+			},
+		},
+		{
+			name:     "dropout",
+			scenario: Scenario{Hold(20 * time.Millisecond), Dropout(40 * time.Millisecond), Set(ThrottleChannel, 1800, 20*time.Millisecond)},
+			options:  []Option{WithFrequency(50)},
+			want: []string{
+				"0,1500,1500,1500,1500,1900,1000,1500,1500,0,50",
+				"60,1500,1800,1500,1500,1900,1000,1500,1500,0,50",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, err := io.ReadAll(New(tt.scenario, tt.options...))
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			got := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+			if fmt.Sprintf("%v", got) != fmt.Sprintf("%v", tt.want) {
+				t.Errorf("bad lines, expected:\n%v\nactual:\n%v", strings.Join(tt.want, "\n"), strings.Join(got, "\n"))
+			}
+		})
+	}
+}
+
+func TestSimulator_Noise(t *testing.T) {
+	lines := readLines(t, New(Scenario{Hold(time.Second)}, WithNoise(5, 42)))
+	if len(lines) != DefaultFrequency {
+		t.Errorf("bad lines number, expected: %v, actual: %v", DefaultFrequency, len(lines))
+	}
+	noisy := false
+	for _, l := range lines {
+		for ch := 1; ch <= 9; ch++ {
+			expected := Neutral[ch-1]
+			if expected == 0 {
+				if l[ch] != 0 {
+					t.Errorf("noise should not be added to channel %d without pulse: %v", ch, l[ch])
+				}
+				continue
+			}
+			if l[ch] < expected-5 || l[ch] > expected+5 {
+				t.Errorf("bad noise on channel %d, value %v is not in [%v, %v]", ch, l[ch], expected-5, expected+5)
+			}
+			if l[ch] != expected {
+				noisy = true
+			}
+		}
+	}
+	if !noisy {
+		t.Errorf("noise should be added")
+	}
+}
+
+func TestSimulator_RealTime(t *testing.T) {
+	s := New(Scenario{Hold(100 * time.Millisecond)}, WithRealTime())
+	begin := time.Now()
+	lines := readLines(t, s)
+	elapsed := time.Since(begin)
+	if len(lines) != 5 {
+		t.Errorf("bad lines number, expected: %v, actual: %v", 5, len(lines))
+	}
+	if elapsed < 70*time.Millisecond || elapsed > time.Second {
+		t.Errorf("bad duration to emit lines: %v", elapsed)
+	}
+}
+
+func TestSimulator_Loop(t *testing.T) {
+	s := New(Scenario{Hold(20 * time.Millisecond)}, WithLoop(), WithFrequency(100))
+	r := bufio.NewReader(s)
+	for i := 0; i < 5; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("ReadString() error = %v", err)
+		}
+		if !strings.HasPrefix(line, fmt.Sprintf("%d,", i*10)) {
+			t.Errorf("bad line %d: %v", i, line)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if _, err := io.ReadAll(r); err == nil {
+		t.Errorf("Read() should fail after Close()")
+	}
+}
+
+func TestScenarios(t *testing.T) {
+	for _, name := range ScenarioNames() {
+		t.Run(name, func(t *testing.T) {
+			lines := readLines(t, New(Scenarios[name]))
+			if len(lines) == 0 {
+				t.Errorf("scenario should emit lines")
+			}
+			for _, l := range lines {
+				for ch := 1; ch <= 9; ch++ {
+					if l[ch] != 0 && (l[ch] < PwmMin || l[ch] > PwmMax) {
+						t.Errorf("value %v for channel %d is out of pwm range", l[ch], ch)
+					}
+				}
+			}
+		})
+	}
+}
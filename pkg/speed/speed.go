@@ -0,0 +1,102 @@
+// Package speed converts motor sensor pulse period reported by arduino on throttle feedback channel to motor RPM and
+// wheel speed, and integrates travelled distance.
+package speed
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// MaxInterval is the max delay between two periods to integrate distance, longer gaps (link lost, replay pause...)
+// are ignored
+const MaxInterval = 500 * time.Millisecond
+
+// Config describes drivetrain, feedback value is the duration in µs of one electrical revolution of motor
+type Config struct {
+	// PoleCount is the number of magnetic poles of motor, one motor revolution is PoleCount/2 electrical revolutions
+	PoleCount int `json:"pole_count"`
+	// GearRatio is the number of motor revolutions for one wheel revolution
+	GearRatio float64 `json:"gear_ratio"`
+	// WheelDiameter is in meters
+	WheelDiameter float64 `json:"wheel_diameter"`
+	// MinPeriod and MaxPeriod bound valid periods in µs, motor is considered stopped outside. MaxPeriod isn't checked
+	// if 0.
+	MinPeriod int `json:"min_period,omitempty"`
+	MaxPeriod int `json:"max_period,omitempty"`
+}
+
+func (c *Config) Validate() error {
+	if c.PoleCount < 2 || c.PoleCount%2 != 0 {
+		return fmt.Errorf("invalid pole count %d, should be an even number >= 2", c.PoleCount)
+	}
+	if c.GearRatio <= 0 {
+		return fmt.Errorf("invalid gear ratio %v, should be > 0", c.GearRatio)
+	}
+	if c.WheelDiameter <= 0 {
+		return fmt.Errorf("invalid wheel diameter %v, should be > 0", c.WheelDiameter)
+	}
+	if c.MinPeriod < 0 {
+		return fmt.Errorf("invalid min period %d", c.MinPeriod)
+	}
+	if c.MaxPeriod != 0 && c.MaxPeriod <= c.MinPeriod {
+		return fmt.Errorf("max period %d should be greater than min period %d", c.MaxPeriod, c.MinPeriod)
+	}
+	return nil
+}
+
+// MotorRPM converts period to motor revolutions per minute, 0 if period is invalid
+func (c *Config) MotorRPM(period int) float64 {
+	if period <= 0 || period < c.MinPeriod || (c.MaxPeriod > 0 && period > c.MaxPeriod) {
+		return 0.
+	}
+	electricalRPM := float64(time.Minute/time.Microsecond) / float64(period)
+	return electricalRPM / float64(c.PoleCount/2)
+}
+
+// Measure is the result of estimation
+type Measure struct {
+	MotorRPM float64 `json:"motor_rpm"`
+	WheelRPM float64 `json:"wheel_rpm"`
+	// Speed is in m/s
+	Speed float64 `json:"speed"`
+	// Distance is the distance in meters travelled since start or last reset
+	Distance float64 `json:"distance"`
+}
+
+// Estimator computes speed from periods and integrates distance. It isn't safe for concurrent use.
+type Estimator struct {
+	config  Config
+	measure Measure
+	last    time.Time
+}
+
+func NewEstimator(config Config) *Estimator {
+	return &Estimator{config: config}
+}
+
+// Update computes speed from period received at t, distance is integrated with mean speed since previous update
+func (e *Estimator) Update(period int, t time.Time) Measure {
+	motorRPM := e.config.MotorRPM(period)
+	wheelRPM := motorRPM / e.config.GearRatio
+	speed := wheelRPM / 60. * math.Pi * e.config.WheelDiameter
+
+	if !e.last.IsZero() {
+		if dt := t.Sub(e.last); dt > 0 && dt <= MaxInterval {
+			e.measure.Distance += (e.measure.Speed + speed) / 2. * dt.Seconds()
+		}
+	}
+	e.last = t
+	e.measure.MotorRPM, e.measure.WheelRPM, e.measure.Speed = motorRPM, wheelRPM, speed
+	return e.measure
+}
+
+// Measure returns last estimation
+func (e *Estimator) Measure() Measure {
+	return e.measure
+}
+
+// ResetDistance sets travelled distance to 0
+func (e *Estimator) ResetDistance() {
+	e.measure.Distance = 0.
+}
@@ -0,0 +1,89 @@
+package speed
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+var testConfig = Config{PoleCount: 4, GearRatio: 10, WheelDiameter: 0.065, MinPeriod: 100, MaxPeriod: 20000}
+
+func TestConfig_MotorRPM(t *testing.T) {
+	tests := []struct {
+		name   string
+		period int
+		want   float64
+	}{
+		{name: "nominal", period: 1000, want: 30000},
+		{name: "slow", period: 15000, want: 2000},
+		{name: "no pulse", period: 0, want: 0},
+		{name: "under min period", period: 50, want: 0},
+		{name: "over max period", period: 25000, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := testConfig.MotorRPM(tt.period); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("MotorRPM(%v) = %v, want %v", tt.period, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{name: "valid", config: testConfig},
+		{name: "no max period", config: Config{PoleCount: 2, GearRatio: 1, WheelDiameter: 0.1}},
+		{name: "odd pole count", config: Config{PoleCount: 3, GearRatio: 1, WheelDiameter: 0.1}, wantErr: true},
+		{name: "no gear ratio", config: Config{PoleCount: 2, WheelDiameter: 0.1}, wantErr: true},
+		{name: "no wheel diameter", config: Config{PoleCount: 2, GearRatio: 1}, wantErr: true},
+		{name: "max lower than min", config: Config{PoleCount: 2, GearRatio: 1, WheelDiameter: 0.1, MinPeriod: 500, MaxPeriod: 400}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEstimator_Update(t *testing.T) {
+	e := NewEstimator(testConfig)
+	start := time.Unix(1000, 0)
+
+	m := e.Update(1000, start)
+	// 3000 wheel rpm, 50 revolutions per second
+	wantSpeed := 50 * math.Pi * 0.065
+	if m.WheelRPM != 3000 || math.Abs(m.Speed-wantSpeed) > 1e-9 || m.Distance != 0 {
+		t.Errorf("bad first measure %+v", m)
+	}
+
+	// 1s at constant speed
+	for i := 1; i <= 100; i++ {
+		m = e.Update(1000, start.Add(time.Duration(i)*10*time.Millisecond))
+	}
+	if math.Abs(m.Distance-wantSpeed) > 1e-6 {
+		t.Errorf("distance = %v after 1s, want %v", m.Distance, wantSpeed)
+	}
+
+	// Deceleration to stop is integrated with mean speed
+	m = e.Update(0, start.Add(1100*time.Millisecond))
+	if want := wantSpeed * 1.05; math.Abs(m.Distance-want) > 1e-6 || m.Speed != 0 {
+		t.Errorf("bad measure after stop %+v, want distance %v", m, want)
+	}
+
+	// Gap isn't integrated
+	e.Update(1000, start.Add(3*time.Second))
+	if got := e.Measure(); got.Distance != m.Distance {
+		t.Errorf("distance = %v after gap, want %v", got.Distance, m.Distance)
+	}
+
+	e.ResetDistance()
+	if got := e.Measure(); got.Distance != 0 || got.Speed == 0 {
+		t.Errorf("bad measure after reset %+v", got)
+	}
+}
@@ -3,6 +3,7 @@ package tools
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"os"
 )
 
@@ -28,30 +29,108 @@ func NewThresholdConfigFromJson(fileName string) (*ThresholdConfig, error) {
 	if err != nil {
 		return nil, fmt.Errorf("unable to unmarshal json content from %s file: %w", fileName, err)
 	}
+	if err := ft.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid threshold config in %s file: %w", fileName, err)
+	}
 	return &ft, nil
 }
 
+// Interpolation defines how ValueOf computes values between two threshold steps
+type Interpolation string
+
+const (
+	// InterpolationStep returns middle of two threshold steps, values are a staircase
+	InterpolationStep Interpolation = "step"
+	// InterpolationLinear interpolates linearly between two threshold steps
+	InterpolationLinear Interpolation = "linear"
+	// InterpolationMonotoneCubic interpolates with a monotone cubic hermite spline (Fritsch-Butland tangents), values
+	// are smooth and never overshoot threshold steps
+	InterpolationMonotoneCubic Interpolation = "monotone-cubic"
+)
+
+// OutOfRange defines values of ValueOf for pwm values outside of Data range, pwm values under MinValid are always
+// converted to 0
+type OutOfRange string
+
+const (
+	// OutOfRangeZero returns 0 above first pwm value and 1 under last pwm value
+	OutOfRangeZero OutOfRange = "zero"
+	// OutOfRangeClamp returns first threshold step above first pwm value and last threshold step under last pwm value
+	OutOfRangeClamp OutOfRange = "clamp"
+	// OutOfRangeExtrapolate extends linearly first and last segments, values are bounded to [0, 1]
+	OutOfRangeExtrapolate OutOfRange = "extrapolate"
+)
+
 type ThresholdConfig struct {
 	ThresholdSteps []float64 `json:"threshold_steps"`
 	MinValid       int       `json:"min_valid"`
 	Data           []int     `json:"data"`
+	// Interpolation is InterpolationStep if empty
+	Interpolation Interpolation `json:"interpolation,omitempty"`
+	// OutOfRange is OutOfRangeZero if empty
+	OutOfRange OutOfRange `json:"out_of_range,omitempty"`
+}
+
+// Copy returns a deep copy of tc
+func (tc *ThresholdConfig) Copy() *ThresholdConfig {
+	c := *tc
+	c.ThresholdSteps = append([]float64(nil), tc.ThresholdSteps...)
+	c.Data = append([]int(nil), tc.Data...)
+	return &c
+}
+
+// Validate checks a pwm value is defined for each threshold step, steps are sorted by increasing values and pwm
+// values by decreasing values
+func (tc *ThresholdConfig) Validate() error {
+	if len(tc.ThresholdSteps) == 0 {
+		return fmt.Errorf("no threshold step")
+	}
+	if len(tc.ThresholdSteps) != len(tc.Data) {
+		return fmt.Errorf("%d threshold steps for %d pwm values", len(tc.ThresholdSteps), len(tc.Data))
+	}
+	for i := 1; i < len(tc.ThresholdSteps); i++ {
+		if tc.ThresholdSteps[i] <= tc.ThresholdSteps[i-1] {
+			return fmt.Errorf("threshold steps should be sorted by increasing values, step %d (%v) <= step %d (%v)",
+				i, tc.ThresholdSteps[i], i-1, tc.ThresholdSteps[i-1])
+		}
+		if tc.Data[i] >= tc.Data[i-1] {
+			return fmt.Errorf("pwm values should be sorted by decreasing values, value %d (%v) >= value %d (%v)",
+				i, tc.Data[i], i-1, tc.Data[i-1])
+		}
+	}
+	if tc.MinValid > tc.Data[len(tc.Data)-1] {
+		return fmt.Errorf("min valid value %d is greater than last pwm value %d", tc.MinValid, tc.Data[len(tc.Data)-1])
+	}
+	switch tc.Interpolation {
+	case "", InterpolationStep, InterpolationLinear, InterpolationMonotoneCubic:
+	default:
+		return fmt.Errorf("unknown interpolation '%v', should be one of %v, %v or %v", tc.Interpolation,
+			InterpolationStep, InterpolationLinear, InterpolationMonotoneCubic)
+	}
+	switch tc.OutOfRange {
+	case "", OutOfRangeZero, OutOfRangeClamp, OutOfRangeExtrapolate:
+	default:
+		return fmt.Errorf("unknown out of range mode '%v', should be one of %v, %v or %v", tc.OutOfRange,
+			OutOfRangeZero, OutOfRangeClamp, OutOfRangeExtrapolate)
+	}
+	return nil
 }
 
 func (tc *ThresholdConfig) ValueOf(pwm int) float64 {
-	if pwm < tc.MinValid || pwm > tc.Data[0] {
+	if pwm < tc.MinValid {
 		return 0.
 	}
-	if pwm == tc.Data[0] {
-		return tc.ThresholdSteps[0]
+	last := len(tc.Data) - 1
+	if pwm > tc.Data[0] {
+		return tc.outOfRange(pwm, 0, tc.ThresholdSteps[0], 0.)
 	}
-
-	if pwm < tc.Data[len(tc.Data)-1] && pwm >= tc.MinValid {
-		return 1.
+	if pwm < tc.Data[last] {
+		return tc.outOfRange(pwm, last-1, tc.ThresholdSteps[last], 1.)
 	}
-	// search column index
+
+	// search segment index, pwm is between Data[idx] and Data[idx+1]
 	var idx int
-	// Start loop at 1 because first column should be skipped
-	for i := 1; i < len(tc.ThresholdSteps); i++ {
+	for i := 0; i < len(tc.Data); i++ {
 		if pwm == tc.Data[i] {
 			return tc.ThresholdSteps[i]
 		}
@@ -61,5 +140,65 @@ func (tc *ThresholdConfig) ValueOf(pwm int) float64 {
 		}
 	}
 
-	return tc.ThresholdSteps[idx] - (tc.ThresholdSteps[idx]-tc.ThresholdSteps[idx+1])/2.
+	switch tc.Interpolation {
+	case InterpolationLinear:
+		return tc.linear(idx, pwm)
+	case InterpolationMonotoneCubic:
+		return tc.monotoneCubic(idx, pwm)
+	default:
+		return tc.ThresholdSteps[idx] - (tc.ThresholdSteps[idx]-tc.ThresholdSteps[idx+1])/2.
+	}
+}
+
+// outOfRange returns value of pwm outside of Data range, segment is the nearest segment used to extrapolate, bound
+// is the nearest threshold step and zero the legacy value
+func (tc *ThresholdConfig) outOfRange(pwm, segment int, bound, zero float64) float64 {
+	switch tc.OutOfRange {
+	case OutOfRangeClamp:
+		return bound
+	case OutOfRangeExtrapolate:
+		if segment < 0 {
+			// Single threshold step, no segment to extend
+			return bound
+		}
+		return math.Max(0., math.Min(1., tc.linear(segment, pwm)))
+	default:
+		return zero
+	}
+}
+
+// slope returns slope of segment i, between Data[i] and Data[i+1]
+func (tc *ThresholdConfig) slope(i int) float64 {
+	return (tc.ThresholdSteps[i+1] - tc.ThresholdSteps[i]) / float64(tc.Data[i+1]-tc.Data[i])
+}
+
+func (tc *ThresholdConfig) linear(i, pwm int) float64 {
+	return tc.ThresholdSteps[i] + tc.slope(i)*float64(pwm-tc.Data[i])
+}
+
+// tangent returns derivative of spline at Data[i], weighted harmonic mean of adjacent slopes keeps spline monotone
+func (tc *ThresholdConfig) tangent(i int) float64 {
+	if i == 0 {
+		return tc.slope(0)
+	}
+	if i == len(tc.Data)-1 {
+		return tc.slope(i - 1)
+	}
+	d0, d1 := tc.slope(i-1), tc.slope(i)
+	if d0*d1 <= 0 {
+		return 0.
+	}
+	h0, h1 := float64(tc.Data[i-1]-tc.Data[i]), float64(tc.Data[i]-tc.Data[i+1])
+	w0, w1 := 2*h1+h0, h1+2*h0
+	return (w0 + w1) / (w0/d0 + w1/d1)
+}
+
+func (tc *ThresholdConfig) monotoneCubic(i, pwm int) float64 {
+	h := float64(tc.Data[i+1] - tc.Data[i])
+	t := float64(pwm-tc.Data[i]) / h
+	t2, t3 := t*t, t*t*t
+	return (2*t3-3*t2+1)*tc.ThresholdSteps[i] +
+		(t3-2*t2+t)*h*tc.tangent(i) +
+		(-2*t3+3*t2)*tc.ThresholdSteps[i+1] +
+		(t3-t2)*h*tc.tangent(i+1)
 }
@@ -1,6 +1,7 @@
 package tools
 
 import (
+	"math"
 	"reflect"
 	"testing"
 )
@@ -133,3 +134,110 @@ func TestThresholdConfig_ValueOf(t *testing.T) {
 		})
 	}
 }
+
+func TestThresholdConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  ThresholdConfig
+		wantErr bool
+	}{
+		{name: "default", config: defaultThresholdConfig},
+		{name: "empty", config: ThresholdConfig{}, wantErr: true},
+		{name: "missing pwm value", config: ThresholdConfig{ThresholdSteps: []float64{0.1, 0.5}, Data: []int{1000}}, wantErr: true},
+		{name: "unsorted steps", config: ThresholdConfig{ThresholdSteps: []float64{0.5, 0.1}, Data: []int{1000, 800}}, wantErr: true},
+		{name: "unsorted pwm values", config: ThresholdConfig{ThresholdSteps: []float64{0.1, 0.5}, Data: []int{800, 1000}}, wantErr: true},
+		{name: "min valid too high", config: ThresholdConfig{ThresholdSteps: []float64{0.1, 0.5}, MinValid: 900, Data: []int{1000, 800}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestThresholdConfig_ValueOf_modes(t *testing.T) {
+	newConfig := func(interpolation Interpolation, outOfRange OutOfRange) *ThresholdConfig {
+		return &ThresholdConfig{
+			ThresholdSteps: []float64{0.1, 0.5, 0.8},
+			MinValid:       500,
+			Data:           []int{3000, 1000, 600},
+			Interpolation:  interpolation,
+			OutOfRange:     outOfRange,
+		}
+	}
+	tests := []struct {
+		name          string
+		interpolation Interpolation
+		outOfRange    OutOfRange
+		pwm           int
+		want          float64
+	}{
+		{name: "step", interpolation: InterpolationStep, pwm: 2500, want: 0.3},
+		{name: "default is step", pwm: 2500, want: 0.3},
+		{name: "linear", interpolation: InterpolationLinear, pwm: 2500, want: 0.2},
+		{name: "linear at limit", interpolation: InterpolationLinear, pwm: 1000, want: 0.5},
+		{name: "linear second segment", interpolation: InterpolationLinear, pwm: 800, want: 0.65},
+		{name: "monotone cubic at limit", interpolation: InterpolationMonotoneCubic, pwm: 600, want: 0.8},
+		{name: "default above range", pwm: 3200, want: 0},
+		{name: "default under range", pwm: 550, want: 1},
+		{name: "zero above range", outOfRange: OutOfRangeZero, pwm: 3200, want: 0},
+		{name: "clamp above range", outOfRange: OutOfRangeClamp, pwm: 3200, want: 0.1},
+		{name: "clamp under range", outOfRange: OutOfRangeClamp, pwm: 550, want: 0.8},
+		{name: "extrapolate above range", outOfRange: OutOfRangeExtrapolate, pwm: 3200, want: 0.06},
+		{name: "extrapolate far above range", outOfRange: OutOfRangeExtrapolate, pwm: 5000, want: 0},
+		{name: "extrapolate under range", outOfRange: OutOfRangeExtrapolate, pwm: 550, want: 0.8375},
+		{name: "extrapolate under min valid", outOfRange: OutOfRangeExtrapolate, pwm: 450, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newConfig(tt.interpolation, tt.outOfRange).ValueOf(tt.pwm)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("ValueOf(%v) = %v, want %v", tt.pwm, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestThresholdConfig_ValueOf_monotone(t *testing.T) {
+	for _, interpolation := range []Interpolation{InterpolationStep, InterpolationLinear, InterpolationMonotoneCubic} {
+		t.Run(string(interpolation), func(t *testing.T) {
+			tc := defaultThresholdConfig.Copy()
+			tc.Interpolation = interpolation
+			tc.OutOfRange = OutOfRangeExtrapolate
+			previous := tc.ValueOf(tc.MinValid)
+			for pwm := tc.MinValid + 1; pwm < 12000; pwm++ {
+				v := tc.ValueOf(pwm)
+				if v > previous {
+					t.Fatalf("ValueOf(%v) = %v is greater than ValueOf(%v) = %v", pwm, v, pwm-1, previous)
+				}
+				if v < 0 || v > 1 {
+					t.Fatalf("ValueOf(%v) = %v out of [0, 1]", pwm, v)
+				}
+				previous = v
+			}
+			// No overshoot between threshold steps
+			for i := 0; i < len(tc.Data)-1; i++ {
+				for pwm := tc.Data[i+1]; pwm <= tc.Data[i]; pwm++ {
+					if v := tc.ValueOf(pwm); v < tc.ThresholdSteps[i] || v > tc.ThresholdSteps[i+1] {
+						t.Fatalf("ValueOf(%v) = %v out of [%v, %v]", pwm, v, tc.ThresholdSteps[i], tc.ThresholdSteps[i+1])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestThresholdConfig_Validate_modes(t *testing.T) {
+	tc := defaultThresholdConfig.Copy()
+	tc.Interpolation = "spline"
+	if err := tc.Validate(); err == nil {
+		t.Errorf("Validate() should reject unknown interpolation")
+	}
+	tc = defaultThresholdConfig.Copy()
+	tc.OutOfRange = "wrap"
+	if err := tc.Validate(); err == nil {
+		t.Errorf("Validate() should reject unknown out of range mode")
+	}
+}